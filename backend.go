@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nyaruka/courier/config"
 	"github.com/nyaruka/gocommon/urns"
@@ -47,9 +48,24 @@ type Backend interface {
 	// WriteChannelEvent writes the passed in channel even returning any error
 	WriteChannelEvent(context.Context, ChannelEvent) error
 
+	// SaveAttachment saves the passed in attachment data for the channel, returning the URL it can be fetched from
+	SaveAttachment(ctx context.Context, channel Channel, contentType string, data []byte, extension string) (string, error)
+
 	// WriteChannelLogs writes the passed in channel logs to our backend
 	WriteChannelLogs(context.Context, []*ChannelLog) error
 
+	// WriteChannelError writes the passed in handler error, these are recorded separately from any msg or status
+	WriteChannelError(context.Context, *ChannelError) error
+
+	// MarkChannelReceived records that the passed in channel received a msg at the passed in time
+	MarkChannelReceived(context.Context, Channel, time.Time) error
+
+	// GetLastReceived returns when each channel which has received a msg last did so
+	GetLastReceived(context.Context) (map[ChannelUUID]time.Time, error)
+
+	// GetChannelLog returns the stored channel log with the passed in id
+	GetChannelLog(context.Context, int64) (*ChannelLog, error)
+
 	// PopNextOutgoingMsg returns the next message that needs to be sent, callers should call MarkOutgoingMsgComplete with the
 	// returned message when they have dealt with the message (regardless of whether it was sent or not)
 	PopNextOutgoingMsg(context.Context) (Msg, error)
@@ -73,6 +89,61 @@ type Backend interface {
 	Status() string
 }
 
+// ChannelResolver is an optional interface a backend can implement to route incoming messages to a different channel
+// than the one whose URL they were received on, e.g. for shared short codes or number pools. Implementations should
+// return a nil channel when there is no better channel than the receiving one.
+type ChannelResolver interface {
+	// ResolveChannel returns the channel of the passed in type that a message sent to the passed in address from the
+	// passed in URN should be received on
+	ResolveChannel(ctx context.Context, channelType ChannelType, to string, from urns.URN) (Channel, error)
+}
+
+// ReplyChannelResolver is an optional interface a backend can implement to send outgoing messages on a different
+// channel than the one they were queued for, e.g. falling back from WhatsApp to SMS for contacts who can't be reached
+// there. Implementations should return a nil channel when there is no better channel than the message's own.
+type ReplyChannelResolver interface {
+	// ResolveReplyChannel returns the channel the passed in outgoing message should be sent on
+	ResolveReplyChannel(ctx context.Context, msg Msg) (Channel, error)
+}
+
+// ResolveReplyChannel returns the channel the passed in outgoing message should be sent on. This is the message's own
+// channel unless the passed in backend implements ReplyChannelResolver and finds a better one.
+func ResolveReplyChannel(ctx context.Context, b Backend, msg Msg) (Channel, error) {
+	resolver, isResolver := b.(ReplyChannelResolver)
+	if !isResolver {
+		return msg.Channel(), nil
+	}
+
+	resolved, err := resolver.ResolveReplyChannel(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return msg.Channel(), nil
+	}
+	return resolved, nil
+}
+
+// SentMsgLister is an optional interface a backend can implement to list the outgoing msgs of a channel type which are
+// still sent, i.e. which never got a final status report, so they can be failed once their report is overdue
+type SentMsgLister interface {
+	// GetSentMsgs returns up to limit msgs of the passed in channel type which were sent before the passed in time
+	GetSentMsgs(ctx context.Context, channelType ChannelType, sentBefore time.Time, limit int) ([]Msg, error)
+}
+
+// TestMsgCreator is an optional interface a backend can implement to create outgoing messages which are sent
+// directly rather than through its queue, e.g. for diagnosing a channel
+type TestMsgCreator interface {
+	// NewTestMsg creates a new outgoing message on the passed in channel to the passed in URN
+	NewTestMsg(channel Channel, urn urns.URN, text string) Msg
+}
+
+// UnconfirmedMsgLister is an optional interface a backend can implement to list the outgoing msgs of a channel type
+// which were sent in the passed in window but are still wired or sent, i.e. we never got a final status for them
+type UnconfirmedMsgLister interface {
+	GetUnconfirmedMsgs(ctx context.Context, channelType ChannelType, sentAfter time.Time, sentBefore time.Time, limit int) ([]Msg, error)
+}
+
 // NewBackend creates the type of backend passed in
 func NewBackend(config *config.Courier) (Backend, error) {
 	backendFunc, found := registeredBackends[strings.ToLower(config.Backend)]
@@ -77,6 +77,11 @@ func (b *backend) NewOutgoingMsg(channel courier.Channel, urn urns.URN, text str
 	return newMsg(MsgOutgoing, channel, urn, text)
 }
 
+// NewTestMsg creates a new outgoing message to be sent directly rather than through our queue
+func (b *backend) NewTestMsg(channel courier.Channel, urn urns.URN, text string) courier.Msg {
+	return newMsg(MsgOutgoing, channel, urn, text)
+}
+
 // PopNextOutgoingMsg pops the next message that needs to be sent
 func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.Msg, error) {
 	// pop the next message off our queue
@@ -190,6 +195,12 @@ func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus)
 		return err
 	}
 
+	// let any external system that wants to know about final statuses know, statuses written by external id have had
+	// the id of their msg read back when they were written to the db
+	if b.statusWebhook != nil {
+		b.statusWebhook.Notify(status)
+	}
+
 	// if we have an id and are marking an outgoing msg as errored, then clear our sent flag
 	if status.ID() != courier.NilMsgID && status.Status() == courier.MsgErrored {
 		rc := b.redisPool.Get()
@@ -223,6 +234,12 @@ func (b *backend) WriteChannelEvent(ctx context.Context, event courier.ChannelEv
 	return writeChannelEvent(timeout, b, event)
 }
 
+// SaveAttachment saves the passed in attachment data to S3, returning the URL it can be fetched from
+func (b *backend) SaveAttachment(ctx context.Context, channel courier.Channel, contentType string, data []byte, extension string) (string, error) {
+	dbChannel := channel.(*DBChannel)
+	return saveMediaToS3(b, dbChannel.OrgID(), courier.NewMsgUUID().String(), extension, contentType, data)
+}
+
 // WriteChannelLogs persists the passed in logs to our database, for rapidpro we swallow all errors, logging isn't critical
 func (b *backend) WriteChannelLogs(ctx context.Context, logs []*courier.ChannelLog) error {
 	timeout, cancel := context.WithTimeout(ctx, dbTimeout)
@@ -237,6 +254,90 @@ func (b *backend) WriteChannelLogs(ctx context.Context, logs []*courier.ChannelL
 	return nil
 }
 
+// GetUnconfirmedMsgs returns the outgoing msgs of the passed in channel type sent in the passed in window which we never
+// got a final status for
+func (b *backend) GetUnconfirmedMsgs(ctx context.Context, channelType courier.ChannelType, sentAfter time.Time, sentBefore time.Time, limit int) ([]courier.Msg, error) {
+	timeout, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return readUnconfirmedMsgsFromDB(timeout, b, channelType, sentAfter, sentBefore, limit)
+}
+
+// GetSentMsgs returns the outgoing msgs of the passed in channel type sent before the passed in time which never got a
+// final status
+func (b *backend) GetSentMsgs(ctx context.Context, channelType courier.ChannelType, sentBefore time.Time, limit int) ([]courier.Msg, error) {
+	timeout, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return readSentMsgsFromDB(timeout, b, channelType, sentBefore, limit)
+}
+
+// the redis hash of channel UUIDs to the unix time they last received a msg
+const lastReceivedKey = "channel_last_received"
+
+// MarkChannelReceived records the time the passed in channel received a msg in redis
+func (b *backend) MarkChannelReceived(ctx context.Context, channel courier.Channel, receivedOn time.Time) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("hset", lastReceivedKey, channel.UUID().String(), receivedOn.Unix())
+	return err
+}
+
+// GetLastReceived returns when each channel which has received a msg last did so
+func (b *backend) GetLastReceived(ctx context.Context) (map[courier.ChannelUUID]time.Time, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	values, err := redis.Int64Map(rc.Do("hgetall", lastReceivedKey))
+	if err != nil {
+		return nil, err
+	}
+
+	lastReceived := make(map[courier.ChannelUUID]time.Time, len(values))
+	for uuid, unix := range values {
+		channelUUID, err := courier.NewChannelUUID(uuid)
+		if err != nil {
+			continue
+		}
+		lastReceived[channelUUID] = time.Unix(unix, 0).UTC()
+	}
+	return lastReceived, nil
+}
+
+// WriteChannelError persists the passed in error to our database as a channel log without a msg, like channel logs
+// we swallow all errors
+func (b *backend) WriteChannelError(ctx context.Context, channelError *courier.ChannelError) error {
+	timeout, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	log := &courier.ChannelLog{
+		Description: channelError.Description,
+		Channel:     channelError.Channel,
+		MsgID:       courier.NilMsgID,
+		Method:      channelError.Method,
+		URL:         channelError.URL,
+		StatusCode:  courier.NilStatusCode,
+		Error:       channelError.Error,
+		Request:     channelError.Payload,
+		CreatedOn:   channelError.CreatedOn,
+	}
+
+	err := writeChannelLog(timeout, b, log)
+	if err != nil {
+		logrus.WithError(err).Error("error writing channel error")
+	}
+	return nil
+}
+
+// GetChannelLog returns the channel log with the passed in id
+func (b *backend) GetChannelLog(ctx context.Context, id int64) (*courier.ChannelLog, error) {
+	timeout, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return readChannelLog(timeout, b, id)
+}
+
 // Health returns the health of this backend as a string, returning "" if all is well
 func (b *backend) Health() string {
 	// test redis
@@ -461,6 +562,12 @@ func (b *backend) Start() error {
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "statuses"), b.flushStatusFile)
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "events"), b.flushChannelEventFile)
 
+	// start posting final statuses if we have a webhook for them
+	if b.config.StatusWebhookURL != "" {
+		b.statusWebhook = courier.NewStatusWebhook(b.config.StatusWebhookURL, b.config.StatusWebhookSecret)
+		b.statusWebhook.Start(b.stopChan, b.waitGroup)
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"comp":  "backend",
 		"state": "started",
@@ -507,6 +614,8 @@ type backend struct {
 
 	popScript *redis.Script
 
+	statusWebhook *courier.StatusWebhook
+
 	stopChan  chan bool
 	waitGroup *sync.WaitGroup
 }
@@ -118,7 +118,7 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 		"channel_id": 11, 
 		"response_to_id": 15, 
 		"external_id": null,
-		"metadata": {"quick_replies": ["Yes", "No"]}
+		"metadata": {"quick_replies": ["Yes", "No"], "send_at": "2017-07-22T10:00:00Z", "priority": "bulk"}
 	}`
 
 	msg := DBMsg{}
@@ -129,7 +129,9 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 	ts.Equal([]string{"https://foo.bar/image.jpg"}, msg.Attachments())
 	ts.Equal(msg.ExternalID(), "")
 	ts.Equal([]string{"Yes", "No"}, msg.QuickReplies())
+	ts.Equal(time.Date(2017, 7, 22, 10, 0, 0, 0, time.UTC), *msg.SendAt())
 	ts.True(msg.HighPriority())
+	ts.Equal(courier.MsgPriorityBulk, msg.Priority())
 
 	msgJSONNoQR := `{
 		"status": "P", 
@@ -160,6 +162,8 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 	err = json.Unmarshal([]byte(msgJSONNoQR), &msg)
 	ts.NoError(err)
 	ts.Equal([]string{}, msg.QuickReplies())
+	ts.Nil(msg.SendAt())
+	ts.Equal(courier.MsgPriorityHigh, msg.Priority())
 }
 
 func (ts *BackendTestSuite) TestCheckMsgExists() {
@@ -358,6 +362,20 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.True(m.ModifiedOn_.After(now))
 	ts.True(m.SentOn_.After(now))
 
+	// metadata on statuses is merged into that of the msg
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgWired)
+	status.SetMetadata("sms_count", 2)
+	status.SetMetadata("status_name", "PENDING_ENROUTE")
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgWired)
+	status.SetMetadata("status_name", "PENDING_ACCEPTED")
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+	m, err = readMsgFromDB(ts.b, courier.NewMsgID(10001))
+	ts.NoError(err)
+	ts.JSONEq(`{"sms_count": 2, "status_name": "PENDING_ACCEPTED"}`, string(m.Metadata()))
+
 	// update by id, no external id, shouldn't overwrite it
 	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgSent)
 	err = ts.b.WriteMsgStatus(ctx, status)
@@ -368,6 +386,19 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(m.ExternalID_.String, "ext0")
 	ts.True(m.ModifiedOn_.After(now))
 
+	// mark as delivered, a late arriving sent or wired status shouldn't regress it
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgDelivered)
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+	for _, late := range []courier.MsgStatusValue{courier.MsgSent, courier.MsgWired} {
+		status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), late)
+		err = ts.b.WriteMsgStatus(ctx, status)
+		ts.NoError(err)
+		m, err = readMsgFromDB(ts.b, courier.NewMsgID(10001))
+		ts.NoError(err)
+		ts.Equal(m.Status_, courier.MsgDelivered)
+	}
+
 	// update by external id
 	status = ts.b.NewMsgStatusForExternalID(channel, "ext1", courier.MsgFailed)
 	err = ts.b.WriteMsgStatus(ctx, status)
@@ -416,6 +447,141 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.NoError(err)
 	ts.Equal(m.Status_, courier.MsgFailed)
 	ts.Equal(m.ErrorCount_, 3)
+
+	// final statuses written by external id are posted to our webhook with the id of their msg
+	posts := make(chan map[string]interface{}, 1)
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&payload)
+		posts <- payload
+	}))
+	defer webhookServer.Close()
+
+	stopChan := make(chan bool)
+	waitGroup := &sync.WaitGroup{}
+	ts.b.statusWebhook = courier.NewStatusWebhook(webhookServer.URL, "")
+	ts.b.statusWebhook.Start(stopChan, waitGroup)
+	defer func() { ts.b.statusWebhook = nil }()
+
+	status = ts.b.NewMsgStatusForExternalID(channel, "ext1", courier.MsgFailed)
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+
+	select {
+	case payload := <-posts:
+		ts.Equal(float64(10000), payload["id"])
+		ts.Equal("ext1", payload["external_id"])
+		ts.Equal("F", payload["status"])
+	case <-time.After(time.Second):
+		ts.Fail("timed out waiting for webhook post")
+	}
+
+	close(stopChan)
+	waitGroup.Wait()
+}
+
+func (ts *BackendTestSuite) TestMsgStatusMetadata() {
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	status := ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgDelivered)
+	ts.Nil(status.Metadata())
+
+	status.SetMetadata("sms_count", 2)
+	status.SetMetadata("mcc_mnc", "21910")
+	status.SetMetadata("invalid", make(chan int))
+	ts.JSONEq(`{"sms_count": 2, "mcc_mnc": "21910"}`, string(status.Metadata()))
+
+	// prices are kept in our metadata
+	ts.Nil(status.Price())
+	status.SetPrice(&courier.MsgPrice{Amount: 0.01, Currency: "EUR"})
+	ts.Equal(&courier.MsgPrice{Amount: 0.01, Currency: "EUR"}, status.Price())
+	ts.JSONEq(`{"sms_count": 2, "mcc_mnc": "21910", "price": {"amount": 0.01, "currency": "EUR"}}`, string(status.Metadata()))
+
+	// as are campaign references
+	ts.Equal("", status.CampaignReference())
+	status.SetCampaignReference("spring-drive")
+	ts.Equal("spring-drive", status.CampaignReference())
+	ts.JSONEq(`{"sms_count": 2, "mcc_mnc": "21910", "price": {"amount": 0.01, "currency": "EUR"}, "campaign_reference": "spring-drive"}`, string(status.Metadata()))
+
+	// metadata survives being spooled
+	encoded, err := json.Marshal(status)
+	ts.NoError(err)
+	spooled := &DBMsgStatus{}
+	ts.NoError(json.Unmarshal(encoded, spooled))
+	ts.JSONEq(string(status.Metadata()), string(spooled.Metadata()))
+
+	// and statuses without any don't have it in their JSON
+	encoded, err = json.Marshal(ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgDelivered))
+	ts.NoError(err)
+	ts.NotContains(string(encoded), "metadata")
+}
+
+func (ts *BackendTestSuite) TestGetUnconfirmedMsgs() {
+	ctx := context.Background()
+
+	// mark one of our outgoing msgs as sent an hour ago without a final status
+	_, err := ts.b.db.Exec(`UPDATE msgs_msg SET status = 'S', sent_on = NOW() - INTERVAL '1 hour' WHERE id = 10000`)
+	ts.NoError(err)
+
+	msgs, err := ts.b.GetUnconfirmedMsgs(ctx, courier.ChannelType("KN"), time.Now().Add(-time.Hour*24), time.Now().Add(-time.Minute*30), 10)
+	ts.NoError(err)
+
+	found := false
+	for _, msg := range msgs {
+		if msg.ID() == courier.NewMsgID(10000) {
+			found = true
+			ts.Equal("dbc126ed-66bc-4e28-b67b-81dc3327c95d", msg.Channel().UUID().String())
+		}
+	}
+	ts.True(found)
+
+	// but not if it was sent more recently than our window
+	msgs, err = ts.b.GetUnconfirmedMsgs(ctx, courier.ChannelType("KN"), time.Now().Add(-time.Hour*24), time.Now().Add(-time.Hour*2), 10)
+	ts.NoError(err)
+	for _, msg := range msgs {
+		ts.NotEqual(courier.NewMsgID(10000), msg.ID())
+	}
+}
+
+func (ts *BackendTestSuite) TestGetSentMsgs() {
+	ctx := context.Background()
+
+	// mark one of our outgoing msgs as sent six hours ago without a final status
+	_, err := ts.b.db.Exec(`UPDATE msgs_msg SET status = 'S', sent_on = NOW() - INTERVAL '6 hours' WHERE id = 10000`)
+	ts.NoError(err)
+
+	msgs, err := ts.b.GetSentMsgs(ctx, courier.ChannelType("KN"), time.Now().Add(-time.Hour*4), 10)
+	ts.NoError(err)
+
+	found := false
+	for _, msg := range msgs {
+		if msg.ID() == courier.NewMsgID(10000) {
+			found = true
+			ts.Equal("dbc126ed-66bc-4e28-b67b-81dc3327c95d", msg.Channel().UUID().String())
+		}
+	}
+	ts.True(found)
+
+	// but not if its report isn't overdue yet
+	msgs, err = ts.b.GetSentMsgs(ctx, courier.ChannelType("KN"), time.Now().Add(-time.Hour*8), 10)
+	ts.NoError(err)
+	for _, msg := range msgs {
+		ts.NotEqual(courier.NewMsgID(10000), msg.ID())
+	}
+
+	// or if it was scheduled to be delivered more recently than it was sent
+	sendAt := time.Now().Add(-time.Hour * 2).UTC().Format(time.RFC3339Nano)
+	_, err = ts.b.db.Exec(`UPDATE msgs_msg SET metadata = $1 WHERE id = 10000`, fmt.Sprintf(`{"send_at": "%s"}`, sendAt))
+	ts.NoError(err)
+
+	msgs, err = ts.b.GetSentMsgs(ctx, courier.ChannelType("KN"), time.Now().Add(-time.Hour*4), 10)
+	ts.NoError(err)
+	for _, msg := range msgs {
+		ts.NotEqual(courier.NewMsgID(10000), msg.ID())
+	}
+
+	_, err = ts.b.db.Exec(`UPDATE msgs_msg SET metadata = NULL WHERE id = 10000`)
+	ts.NoError(err)
 }
 
 func (ts *BackendTestSuite) TestHealth() {
@@ -629,7 +795,7 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 
 	// create a new courier msg
 	urn := urns.NewTelURNForCountry("12065551212", knChannel.Country())
-	msg := ts.b.NewIncomingMsg(knChannel, urn, "test123").WithExternalID("ext123").WithReceivedOn(now).WithContactName("test contact").(*DBMsg)
+	msg := ts.b.NewIncomingMsg(knChannel, urn, "test123").WithExternalID("ext123").WithReceivedOn(now).WithContactName("test contact").WithMetadata("full_text", "QUIZ test123").(*DBMsg)
 
 	// try to write it to our db
 	err := ts.b.WriteMsg(ctx, msg)
@@ -669,6 +835,7 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	ts.False(m.HighPriority_.Valid)
 	ts.Equal("ext123", m.ExternalID())
 	ts.Equal("test123", m.Text_)
+	ts.JSONEq(`{"full_text":"QUIZ test123"}`, string(m.Metadata()))
 	ts.Equal(0, len(m.Attachments()))
 	ts.Equal(1, m.MessageCount_)
 	ts.Equal(0, m.ErrorCount_)
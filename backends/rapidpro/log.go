@@ -2,6 +2,7 @@ package rapidpro
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 
 	"time"
@@ -28,6 +29,11 @@ func writeChannelLog(ctx context.Context, b *backend, log *courier.ChannelLog) e
 		log.Response += "\n\nError: " + log.Error
 	}
 
+	// same for our correlation id, so logs from the same msg lifecycle can be found
+	if log.CorrelationID != "" {
+		log.Response += "\n\nCorrelation: " + log.CorrelationID
+	}
+
 	// strip null chars from request and response, postgres doesn't like that
 	log.Request = utils.CleanString(log.Request)
 	log.Response = utils.CleanString(log.Response)
@@ -37,3 +43,32 @@ func writeChannelLog(ctx context.Context, b *backend, log *courier.ChannelLog) e
 
 	return err
 }
+
+const selectLogSQL = `
+SELECT description, method, url, request, response, response_status, created_on, request_time
+FROM channels_channellog
+WHERE id = $1
+`
+
+// readChannelLog reads the channel log with the passed in id from the database
+func readChannelLog(ctx context.Context, b *backend, id int64) (*courier.ChannelLog, error) {
+	var method, url, request, response sql.NullString
+	var statusCode, elapsed sql.NullInt64
+	log := &courier.ChannelLog{}
+
+	err := b.db.QueryRowContext(ctx, selectLogSQL, id).Scan(&log.Description, &method, &url, &request, &response, &statusCode, &log.CreatedOn, &elapsed)
+	if err == sql.ErrNoRows {
+		return nil, courier.ErrChannelLogNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Method = method.String
+	log.URL = url.String
+	log.Request = request.String
+	log.Response = response.String
+	log.StatusCode = int(statusCode.Int64)
+	log.Elapsed = time.Duration(elapsed.Int64) * time.Millisecond
+	return log, nil
+}
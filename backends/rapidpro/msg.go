@@ -116,9 +116,9 @@ func newMsg(direction MsgDirection, channel courier.Channel, urn urns.URN, text
 
 const insertMsgSQL = `
 INSERT INTO msgs_msg(org_id, direction, text, attachments, msg_count, error_count, high_priority, status, 
-                     visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, queued_on, sent_on)
+                     visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, queued_on, sent_on, metadata)
               VALUES(:org_id, :direction, :text, :attachments, :msg_count, :error_count, :high_priority, :status, 
-                     :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :queued_on, :sent_on)
+                     :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :queued_on, :sent_on, :metadata)
 RETURNING id
 `
 
@@ -163,7 +163,7 @@ func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
 
 const selectMsgSQL = `
 SELECT org_id, direction, text, attachments, msg_count, error_count, high_priority, status, 
-       visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, queued_on, sent_on
+       visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, queued_on, sent_on, metadata
 FROM msgs_msg
 WHERE id = $1
 `
@@ -176,6 +176,74 @@ func readMsgFromDB(b *backend, id courier.MsgID) (*DBMsg, error) {
 	return m, err
 }
 
+const selectUnconfirmedMsgsSQL = `
+SELECT m.id, m.org_id, m.direction, m.text, m.attachments, m.msg_count, m.error_count, m.high_priority, m.status, 
+       m.visibility, m.external_id, m.channel_id, m.contact_id, m.contact_urn_id, m.created_on, m.modified_on, 
+       m.next_attempt, m.queued_on, m.sent_on, c.uuid AS channel_uuid
+FROM msgs_msg m INNER JOIN channels_channel c ON (m.channel_id = c.id)
+WHERE c.channel_type = $1 AND m.direction = 'O' AND m.status IN ('W', 'S') AND m.sent_on > $2 AND m.sent_on < $3
+ORDER BY m.sent_on ASC
+LIMIT $4
+`
+
+// unconfirmedMsg is a msg read with the UUID of its channel so we can populate it
+type unconfirmedMsg struct {
+	DBMsg
+	ChannelUUID string `db:"channel_uuid"`
+}
+
+// readUnconfirmedMsgsFromDB reads the outgoing msgs of the passed in channel type sent in the passed in window which
+// are still wired or sent
+func readUnconfirmedMsgsFromDB(ctx context.Context, b *backend, channelType courier.ChannelType, sentAfter time.Time, sentBefore time.Time, limit int) ([]courier.Msg, error) {
+	return readChannelTypeMsgsFromDB(ctx, b, channelType, selectUnconfirmedMsgsSQL, string(channelType), sentAfter, sentBefore, limit)
+}
+
+const selectSentMsgsSQL = `
+SELECT m.id, m.org_id, m.direction, m.text, m.attachments, m.msg_count, m.error_count, m.high_priority, m.status, 
+       m.visibility, m.external_id, m.channel_id, m.contact_id, m.contact_urn_id, m.created_on, m.modified_on, 
+       m.next_attempt, m.queued_on, m.sent_on, c.uuid AS channel_uuid
+FROM msgs_msg m INNER JOIN channels_channel c ON (m.channel_id = c.id)
+WHERE c.channel_type = $1 AND m.direction = 'O' AND m.status = 'S' AND 
+      GREATEST(m.sent_on, CAST(NULLIF(COALESCE(NULLIF(m.metadata, ''), '{}')::jsonb->>'send_at', '') AS timestamptz)) < $2
+ORDER BY m.sent_on ASC
+LIMIT $3
+`
+
+// readSentMsgsFromDB reads the outgoing msgs of the passed in channel type sent before the passed in time which are
+// still sent, msgs scheduled with a send_at are only read once that is also before the passed in time
+func readSentMsgsFromDB(ctx context.Context, b *backend, channelType courier.ChannelType, sentBefore time.Time, limit int) ([]courier.Msg, error) {
+	return readChannelTypeMsgsFromDB(ctx, b, channelType, selectSentMsgsSQL, string(channelType), sentBefore, limit)
+}
+
+// readChannelTypeMsgsFromDB reads the msgs of the passed in channel type selected by the passed in query, populating
+// each with its channel
+func readChannelTypeMsgsFromDB(ctx context.Context, b *backend, channelType courier.ChannelType, query string, args ...interface{}) ([]courier.Msg, error) {
+	rows := []*unconfirmedMsg{}
+	err := b.db.SelectContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.Msg, 0, len(rows))
+	for _, row := range rows {
+		channelUUID, err := courier.NewChannelUUID(row.ChannelUUID)
+		if err != nil {
+			return nil, err
+		}
+
+		channel, err := b.GetChannel(ctx, channelType, channelUUID)
+		if err != nil {
+			return nil, err
+		}
+
+		m := row.DBMsg
+		m.ChannelUUID_ = channelUUID
+		m.channel = channel
+		msgs = append(msgs, &m)
+	}
+	return msgs, nil
+}
+
 //-----------------------------------------------------------------------------
 // Media download and classification
 //-----------------------------------------------------------------------------
@@ -234,23 +302,28 @@ func downloadMediaToS3(b *backend, orgID OrgID, msgUUID courier.MsgUUID, mediaUR
 		}
 	}
 
+	s3URL, err := saveMediaToS3(b, orgID, msgUUID.String(), extension, mimeType, body)
+	if err != nil {
+		return "", err
+	}
+
+	// return our new media URL, which is prefixed by our content type
+	return fmt.Sprintf("%s:%s", mimeType, s3URL), nil
+}
+
+// saveMediaToS3 writes the passed in media to S3 under a path derived from our org and name, returning its URL
+func saveMediaToS3(b *backend, orgID OrgID, name string, extension string, mimeType string, body []byte) (string, error) {
 	// create our filename
-	filename := msgUUID.String()
+	filename := name
 	if extension != "" {
-		filename = fmt.Sprintf("%s.%s", msgUUID, extension)
+		filename = fmt.Sprintf("%s.%s", name, extension)
 	}
 	path := filepath.Join(b.config.S3MediaPrefix, strconv.FormatInt(orgID.Int64, 10), filename[:4], filename[4:8], filename)
 	if !strings.HasPrefix(path, "/") {
 		path = fmt.Sprintf("/%s", path)
 	}
 
-	s3URL, err := utils.PutS3File(b.s3Client, b.config.S3MediaBucket, path, mimeType, body)
-	if err != nil {
-		return "", err
-	}
-
-	// return our new media URL, which is prefixed by our content type
-	return fmt.Sprintf("%s:%s", mimeType, s3URL), nil
+	return utils.PutS3File(b.s3Client, b.config.S3MediaBucket, path, mimeType, body)
 }
 
 //-----------------------------------------------------------------------------
@@ -368,20 +441,24 @@ type DBMsg struct {
 	workerToken    queue.WorkerToken
 	alreadyWritten bool
 	quickReplies   []string
+	sendAt         *time.Time
+	priority       courier.MsgPriority
 }
 
-func (m *DBMsg) Channel() courier.Channel { return m.channel }
-func (m *DBMsg) ID() courier.MsgID        { return m.ID_ }
-func (m *DBMsg) EventID() int64           { return m.ID_.Int64 }
-func (m *DBMsg) UUID() courier.MsgUUID    { return m.UUID_ }
-func (m *DBMsg) Text() string             { return m.Text_ }
-func (m *DBMsg) Attachments() []string    { return []string(m.Attachments_) }
-func (m *DBMsg) ExternalID() string       { return m.ExternalID_.String }
-func (m *DBMsg) URN() urns.URN            { return m.URN_ }
-func (m *DBMsg) ContactName() string      { return m.ContactName_ }
-func (m *DBMsg) HighPriority() bool       { return m.HighPriority_.Valid && m.HighPriority_.Bool }
-func (m *DBMsg) ReceivedOn() *time.Time   { return &m.SentOn_ }
-func (m *DBMsg) SentOn() *time.Time       { return &m.SentOn_ }
+func (m *DBMsg) Channel() courier.Channel  { return m.channel }
+func (m *DBMsg) ID() courier.MsgID         { return m.ID_ }
+func (m *DBMsg) EventID() int64            { return m.ID_.Int64 }
+func (m *DBMsg) UUID() courier.MsgUUID     { return m.UUID_ }
+func (m *DBMsg) Text() string              { return m.Text_ }
+func (m *DBMsg) Attachments() []string     { return []string(m.Attachments_) }
+func (m *DBMsg) ExternalID() string        { return m.ExternalID_.String }
+func (m *DBMsg) URN() urns.URN             { return m.URN_ }
+func (m *DBMsg) ContactName() string       { return m.ContactName_ }
+func (m *DBMsg) HighPriority() bool        { return m.HighPriority_.Valid && m.HighPriority_.Bool }
+func (m *DBMsg) ReceivedOn() *time.Time    { return &m.SentOn_ }
+func (m *DBMsg) SentOn() *time.Time        { return &m.SentOn_ }
+func (m *DBMsg) CreatedOn() time.Time      { return m.CreatedOn_ }
+func (m *DBMsg) Metadata() json.RawMessage { return m.Metadata_ }
 
 func (m *DBMsg) QuickReplies() []string {
 	if m.quickReplies != nil {
@@ -402,6 +479,53 @@ func (m *DBMsg) QuickReplies() []string {
 	return m.quickReplies
 }
 
+// SendAt returns the time this msg should be delivered at, read from the send_at value in our metadata if
+// present, or nil if it should be delivered immediately
+func (m *DBMsg) SendAt() *time.Time {
+	if m.sendAt != nil {
+		return m.sendAt
+	}
+
+	if m.Metadata_ == nil {
+		return nil
+	}
+
+	sendAtStr, err := jsonparser.GetString(m.Metadata_, "send_at")
+	if err != nil {
+		return nil
+	}
+
+	sendAt, err := time.Parse(time.RFC3339Nano, sendAtStr)
+	if err != nil {
+		return nil
+	}
+
+	m.sendAt = &sendAt
+	return m.sendAt
+}
+
+// Priority returns the priority of this msg, read from the priority value in our metadata if present, otherwise
+// high for high priority msgs and normal for everything else
+func (m *DBMsg) Priority() courier.MsgPriority {
+	if m.priority != "" {
+		return m.priority
+	}
+
+	m.priority = courier.MsgPriorityNormal
+	if m.HighPriority() {
+		m.priority = courier.MsgPriorityHigh
+	}
+
+	if m.Metadata_ != nil {
+		priority, _ := jsonparser.GetString(m.Metadata_, "priority")
+		switch courier.MsgPriority(priority) {
+		case courier.MsgPriorityHigh, courier.MsgPriorityNormal, courier.MsgPriorityBulk:
+			m.priority = courier.MsgPriority(priority)
+		}
+	}
+	return m.priority
+}
+
 // fingerprint returns a fingerprint for this msg, suitable for figuring out if this is a dupe
 func (m *DBMsg) fingerprint() string {
 	return fmt.Sprintf("%s:%s:%s", m.channel.UUID(), m.URN_, m.Text_)
@@ -427,3 +551,34 @@ func (m *DBMsg) WithAttachment(url string) courier.Msg {
 	m.Attachments_ = append(m.Attachments_, url)
 	return m
 }
+
+// WithSendAt can be used to set the time a message should be delivered at
+func (m *DBMsg) WithSendAt(date time.Time) courier.Msg { m.sendAt = &date; return m }
+
+// WithCreatedOn can be used to set the time this msg was created
+func (m *DBMsg) WithCreatedOn(date time.Time) courier.Msg { m.CreatedOn_ = date; return m }
+
+// WithPriority can be used to override the priority of this msg
+func (m *DBMsg) WithPriority(priority courier.MsgPriority) courier.Msg { m.priority = priority; return m }
+
+// WithChannel can be used to change the channel this msg is sent on
+func (m *DBMsg) WithChannel(channel courier.Channel) courier.Msg {
+	m.channel = channel
+	m.ChannelUUID_ = channel.UUID()
+	if dbChannel, isDB := channel.(*DBChannel); isDB {
+		m.ChannelID_ = dbChannel.ID()
+	}
+	return m
+}
+
+// WithMetadata can be used to set a value in the metadata of this msg, values which can't be encoded as JSON are ignored
+func (m *DBMsg) WithMetadata(key string, value interface{}) courier.Msg {
+	if m.Metadata_ == nil {
+		m.Metadata_ = json.RawMessage("{}")
+	}
+	encoded, err := json.Marshal(value)
+	if err == nil {
+		m.Metadata_, _ = jsonparser.Set(m.Metadata_, encoded, key)
+	}
+	return m
+}
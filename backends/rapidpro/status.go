@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/buger/jsonparser"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 )
@@ -64,14 +65,18 @@ func checkMsgExists(b *backend, status courier.MsgStatus) (err error) {
 	return err
 }
 
-// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message
+// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message,
+// retries are never scheduled sooner than any retry_after the provider asked for. Providers can send intermediate
+// reports after (or again after) a delivery report, so a delivered message is never regressed to wired or sent. Any
+// metadata on the status is merged into that of the msg.
 const updateMsgID = `
 UPDATE msgs_msg SET 
-	status = CASE WHEN :status = 'E' THEN CASE WHEN error_count >= 2 OR status = 'F' THEN 'F' ELSE 'E' END ELSE :status END,
+	status = CASE WHEN status = 'D' AND :status IN ('W', 'S') THEN status WHEN :status = 'E' THEN CASE WHEN error_count >= 2 OR status = 'F' THEN 'F' ELSE 'E' END ELSE :status END,
 	error_count = CASE WHEN :status = 'E' THEN error_count + 1 ELSE error_count END,
-	next_attempt = CASE WHEN :status = 'E' THEN NOW() + (5 * (error_count+1) * interval '1 minutes') ELSE next_attempt END,
+	next_attempt = CASE WHEN :status = 'E' THEN NOW() + GREATEST(5 * (error_count+1) * interval '1 minutes', CAST(:retry_after AS integer) * interval '1 seconds') ELSE next_attempt END,
 	external_id = CASE WHEN :external_id != '' THEN :external_id ELSE external_id END,
-	sent_on = CASE WHEN :status = 'W' THEN NOW() ELSE sent_on END,
+	sent_on = CASE WHEN :status = 'W' AND status != 'D' THEN NOW() ELSE sent_on END,
+	metadata = CASE WHEN CAST(:metadata AS jsonb) IS NULL THEN metadata ELSE (COALESCE(NULLIF(metadata, ''), '{}')::jsonb || CAST(:metadata AS jsonb))::text END,
 	modified_on = :modified_on
 
 	WHERE msgs_msg.id IN
@@ -83,10 +88,11 @@ UPDATE msgs_msg SET
 
 const updateMsgExternalID = `
 UPDATE msgs_msg SET 
-	status = CASE WHEN :status = 'E' THEN CASE WHEN error_count >= 2 OR status = 'F' THEN 'F' ELSE 'E' END ELSE :status END,
+	status = CASE WHEN status = 'D' AND :status IN ('W', 'S') THEN status WHEN :status = 'E' THEN CASE WHEN error_count >= 2 OR status = 'F' THEN 'F' ELSE 'E' END ELSE :status END,
 	error_count = CASE WHEN :status = 'E' THEN error_count + 1 ELSE error_count END,
-	next_attempt = CASE WHEN :status = 'E' THEN NOW() + (5 * (error_count+1) * interval '1 minutes') ELSE next_attempt END,
-	sent_on = CASE WHEN :status = 'W' THEN NOW() ELSE sent_on END,
+	next_attempt = CASE WHEN :status = 'E' THEN NOW() + GREATEST(5 * (error_count+1) * interval '1 minutes', CAST(:retry_after AS integer) * interval '1 seconds') ELSE next_attempt END,
+	sent_on = CASE WHEN :status = 'W' AND status != 'D' THEN NOW() ELSE sent_on END,
+	metadata = CASE WHEN CAST(:metadata AS jsonb) IS NULL THEN metadata ELSE (COALESCE(NULLIF(metadata, ''), '{}')::jsonb || CAST(:metadata AS jsonb))::text END,
 	modified_on = :modified_on
 
 WHERE msgs_msg.id IN
@@ -147,6 +153,10 @@ type DBMsgStatus struct {
 	ExternalID_  string                 `json:"external_id,omitempty"    db:"external_id"`
 	Status_      courier.MsgStatusValue `json:"status"                   db:"status"`
 	ModifiedOn_  time.Time              `json:"modified_on"              db:"modified_on"`
+	RetryAfter_  int                    `json:"retry_after,omitempty"    db:"retry_after"`
+
+	CorrelationID_ string          `json:"correlation_id,omitempty"`
+	Metadata_      json.RawMessage `json:"metadata,omitempty" db:"metadata"`
 
 	logs []*courier.ChannelLog
 }
@@ -162,5 +172,58 @@ func (s *DBMsgStatus) SetExternalID(id string) { s.ExternalID_ = id }
 func (s *DBMsgStatus) Logs() []*courier.ChannelLog    { return s.logs }
 func (s *DBMsgStatus) AddLog(log *courier.ChannelLog) { s.logs = append(s.logs, log) }
 
+func (s *DBMsgStatus) RetryAfter() time.Duration         { return time.Duration(s.RetryAfter_) * time.Second }
+func (s *DBMsgStatus) SetRetryAfter(delay time.Duration) { s.RetryAfter_ = int(delay / time.Second) }
+
+func (s *DBMsgStatus) CorrelationID() string      { return s.CorrelationID_ }
+func (s *DBMsgStatus) SetCorrelationID(id string) { s.CorrelationID_ = id }
+
+// Price returns what the provider charged for our msg, read from the price in our metadata
+func (s *DBMsgStatus) Price() *courier.MsgPrice {
+	encoded, _, _, err := jsonparser.Get(s.Metadata_, "price")
+	if err != nil {
+		return nil
+	}
+	price := &courier.MsgPrice{}
+	if json.Unmarshal(encoded, price) != nil {
+		return nil
+	}
+	return price
+}
+
+// SetPrice sets what the provider charged for our msg, it is stored in our metadata so that it is kept on the msg
+func (s *DBMsgStatus) SetPrice(price *courier.MsgPrice) {
+	if price != nil {
+		s.SetMetadata("price", price)
+	}
+}
+
+// CampaignReference returns the campaign our msg was sent for, read from the campaign reference in our metadata
+func (s *DBMsgStatus) CampaignReference() string {
+	ref, _ := jsonparser.GetString(s.Metadata_, "campaign_reference")
+	return ref
+}
+
+// SetCampaignReference sets the campaign our msg was sent for, it is stored in our metadata so that it is kept on the msg
+func (s *DBMsgStatus) SetCampaignReference(ref string) {
+	if ref != "" {
+		s.SetMetadata("campaign_reference", ref)
+	}
+}
+
+func (s *DBMsgStatus) Metadata() json.RawMessage { return s.Metadata_ }
+
+// SetMetadata sets a value in the metadata of this status, values which can't be encoded as JSON are ignored. Metadata
+// is merged into that of the msg when the status is written.
+func (s *DBMsgStatus) SetMetadata(key string, value interface{}) {
+	if s.Metadata_ == nil {
+		s.Metadata_ = json.RawMessage("{}")
+	}
+	encoded, err := json.Marshal(value)
+	if err == nil {
+		s.Metadata_, _ = jsonparser.Set(s.Metadata_, encoded, key)
+	}
+}
+
 func (s *DBMsgStatus) Status() courier.MsgStatusValue          { return s.Status_ }
 func (s *DBMsgStatus) SetStatus(status courier.MsgStatusValue) { s.Status_ = status }
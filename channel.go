@@ -45,6 +45,80 @@ const (
 
 	// ConfigCallbackDomain is the domain that should be used for this channel when registering callbacks
 	ConfigCallbackDomain = "callback_domain"
+
+	// ConfigCallbackDomains is a list of domains, in order of preference, that callbacks can be registered against,
+	// the first which is up is used and if none are we fall back to the callback domain
+	ConfigCallbackDomains = "callback_domains"
+
+	// ConfigCallbackToken is a shared secret which requests to a channel's routes must include, either as a token
+	// query parameter or X-Callback-Token header, channels without one are only protected by their UUID
+	ConfigCallbackToken = "callback_token"
+
+	// ConfigAllowedIPs is the CIDR ranges requests to a channel's routes are only allowed from, e.g. its provider's
+	// published ranges, channels without any allow requests from anywhere
+	ConfigAllowedIPs = "allowed_ips"
+
+	// ConfigTextPrefix is a template that will be prepended to the text of outgoing messages
+	ConfigTextPrefix = "text_prefix"
+
+	// ConfigTextSuffix is a template that will be appended to the text of outgoing messages
+	ConfigTextSuffix = "text_suffix"
+
+	// ConfigMaxConcurrentSends is the maximum number of sends that can be in flight at once for a channel
+	ConfigMaxConcurrentSends = "max_concurrent_sends"
+
+	// ConfigRequestErrorStatuses maps the types of request errors (timeout, canceled, dns, connection_refused or
+	// connection) to the status a send that hit them should be given, e.g. {"timeout": "W"}
+	ConfigRequestErrorStatuses = "request_error_statuses"
+
+	// ConfigHTTPHeaders is a map of headers added to the requests a channel makes to its provider, e.g. for accounts
+	// or proxies which need a particular header, these can override the defaults like User-Agent but not auth
+	ConfigHTTPHeaders = "http_headers"
+
+	// ConfigMaxAge is the number of seconds after which a message which still hasn't been sent is failed rather than
+	// sent, e.g. because it sat in our queue during an outage
+	ConfigMaxAge = "max_age"
+
+	// ConfigSendRetries is the number of times a send which failed to get a response, or got a 5xx or 429, is retried
+	// straight away before it is left to be retried later
+	ConfigSendRetries = "send_retries"
+
+	// ConfigSendDeadline is the number of seconds a send can take across all of its retries, once it has passed the
+	// send is errored rather than tried again
+	ConfigSendDeadline = "send_deadline"
+
+	// ConfigCircuitBreakerThreshold is the number of consecutive failed sends after which we stop sending on a channel
+	ConfigCircuitBreakerThreshold = "circuit_breaker_threshold"
+
+	// ConfigCircuitBreakerCooldown is the number of seconds we wait before trying to send again on a channel whose
+	// circuit breaker has tripped
+	ConfigCircuitBreakerCooldown = "circuit_breaker_cooldown"
+
+	// ConfigAllowedDestinations restricts the destinations a channel can send to, either a list of numbers or a
+	// regular expression which must match the whole number
+	ConfigAllowedDestinations = "allowed_destinations"
+
+	// ConfigBlockedDestinations is the destinations a channel can't send to, either a list of numbers or a regular
+	// expression which must match the whole number
+	ConfigBlockedDestinations = "blocked_destinations"
+
+	// ConfigRecipientRateLimit is the maximum number of messages a channel sends to the same destination within its
+	// recipient rate window
+	ConfigRecipientRateLimit = "recipient_rate_limit"
+
+	// ConfigRecipientRateWindow is the number of seconds over which a channel's recipient rate limit applies
+	ConfigRecipientRateWindow = "recipient_rate_window"
+
+	// ConfigMaxClockSkew is the number of seconds the time a provider says it received a message can be off from our
+	// clock before we log a warning about it
+	ConfigMaxClockSkew = "max_clock_skew"
+
+	// ConfigClampClockSkew is whether messages whose received time is off by more than the max clock skew are
+	// received at our current time instead
+	ConfigClampClockSkew = "clamp_clock_skew"
+
+	// ConfigDebugHTTP is whether the full requests a channel makes to its provider, and their responses, are logged
+	ConfigDebugHTTP = "debug_http"
 )
 
 // ChannelType is our typing of the two char channel types
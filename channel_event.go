@@ -12,6 +12,8 @@ type ChannelEventType string
 const (
 	NewConversation ChannelEventType = "new_conversation"
 	Referral        ChannelEventType = "referral"
+	StopContact     ChannelEventType = "stop_contact"
+	LinkClicked     ChannelEventType = "link_clicked"
 )
 
 //-----------------------------------------------------------------------------
@@ -1,15 +1,25 @@
 package courier
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/nyaruka/courier/utils"
+	uuid "github.com/satori/go.uuid"
 )
 
 // NilStatusCode is used when we have an error before even sending anything
 const NilStatusCode int = 417
 
+// ErrChannelLogNotFound is returned when trying to look up a channel log that doesn't exist
+var ErrChannelLogNotFound = errors.New("channel log not found")
+
 // NewChannelLog creates a new channel log for the passed in channel, id, and request and response info
 func NewChannelLog(description string, channel Channel, msgID MsgID, method string, url string, statusCode int,
 	request string, response string, elapsed time.Duration, err error) *ChannelLog {
@@ -52,6 +62,18 @@ func NewChannelLogFromRR(description string, channel Channel, msgID MsgID, rr *u
 	return log
 }
 
+// NewCorrelationID generates a new id for tying together the logs of a msg's lifecycle, e.g. its send and the status
+// reports which follow it. Handlers whose providers echo back data on status reports should send this along.
+func NewCorrelationID() string {
+	return uuid.NewV4().String()
+}
+
+// WithCorrelationID sets the correlation id of the passed in ChannelLog
+func (l *ChannelLog) WithCorrelationID(id string) *ChannelLog {
+	l.CorrelationID = id
+	return l
+}
+
 // WithError augments the passed in ChannelLog with the passed in description and error if error is not nil
 func (l *ChannelLog) WithError(description string, err error) *ChannelLog {
 	if err != nil {
@@ -62,6 +84,56 @@ func (l *ChannelLog) WithError(description string, err error) *ChannelLog {
 	return l
 }
 
+// NewRequest reconstructs the outgoing HTTP request captured in this log so that it can be replayed
+func (l *ChannelLog) NewRequest() (*http.Request, error) {
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(l.Request)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse logged request: %s", err)
+	}
+
+	// the logged request only has our path, use our full URL instead
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	req.Host = u.Host
+	req.RequestURI = ""
+
+	return req, nil
+}
+
+// ChannelLogSampler decides which channel logs get written, keeping every 1 in N successful sets of logs but never
+// sampling out a set which contains an error or which the caller flags as failed
+type ChannelLogSampler struct {
+	rate  int64
+	count int64
+}
+
+// NewChannelLogSampler creates a new sampler which keeps 1 in every rate successful sets of logs, a rate of 1 or
+// less keeps everything
+func NewChannelLogSampler(rate int) *ChannelLogSampler {
+	return &ChannelLogSampler{rate: int64(rate)}
+}
+
+// Sample returns the passed in logs if they should be written, or nil if they have been sampled out
+func (s *ChannelLogSampler) Sample(logs []*ChannelLog, failed bool) []*ChannelLog {
+	if s.rate <= 1 || failed {
+		return logs
+	}
+
+	for _, l := range logs {
+		if l.Error != "" {
+			return logs
+		}
+	}
+
+	if atomic.AddInt64(&s.count, 1)%s.rate != 1 {
+		return nil
+	}
+	return logs
+}
+
 func (l *ChannelLog) String() string {
 	return fmt.Sprintf("%s: %d %s %d\n%s\n%s\n%s", l.Description, l.StatusCode, l.URL, l.Elapsed, l.Error, l.Request, l.Response)
 }
@@ -81,4 +153,37 @@ type ChannelLog struct {
 	Response    string
 	Elapsed     time.Duration
 	CreatedOn   time.Time
+
+	// CorrelationID ties this log to the others from the same msg lifecycle, empty if there aren't any
+	CorrelationID string
+}
+
+// ChannelError is an error a handler hit processing a request which didn't result in a msg, status or event, such as
+// a rejected webhook or an unknown status. It includes the raw payload so it can be inspected later.
+type ChannelError struct {
+	Channel     Channel
+	Description string
+	Method      string
+	URL         string
+	Payload     string
+	Error       string
+	CreatedOn   time.Time
+}
+
+// NewChannelError creates a new channel error for the passed in channel, request and payload
+func NewChannelError(description string, channel Channel, r *http.Request, payload string, err error) *ChannelError {
+	errString := ""
+	if err != nil {
+		errString = err.Error()
+	}
+
+	return &ChannelError{
+		Channel:     channel,
+		Description: description,
+		Method:      r.Method,
+		URL:         r.URL.String(),
+		Payload:     payload,
+		Error:       errString,
+		CreatedOn:   time.Now(),
+	}
 }
@@ -0,0 +1,35 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelLogSampler(t *testing.T) {
+	channel := NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "MCK", "2020", "US", map[string]interface{}{})
+	success := []*ChannelLog{NewChannelLog("Message Sent", channel, NewMsgID(10), "POST", "https://foo.bar", 200, "", "", 0, nil)}
+	errored := []*ChannelLog{NewChannelLog("Message Send Error", channel, NewMsgID(10), "POST", "https://foo.bar", 500, "", "", 0, assert.AnError)}
+
+	// a rate of 1 keeps everything
+	sampler := NewChannelLogSampler(1)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, success, sampler.Sample(success, false))
+	}
+
+	// otherwise we keep 1 in every N successes
+	sampler = NewChannelLogSampler(3)
+	kept := 0
+	for i := 0; i < 9; i++ {
+		if sampler.Sample(success, false) != nil {
+			kept++
+		}
+	}
+	assert.Equal(t, 3, kept)
+
+	// but never sample out errors or failures
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, errored, sampler.Sample(errored, false))
+		assert.Equal(t, success, sampler.Sample(success, true))
+	}
+}
@@ -0,0 +1,139 @@
+package courier
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// ParseCIDRs parses the passed in CIDR ranges, e.g. 10.0.0.0/8, returning an error for the first which is invalid
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s': %s", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ChannelAllowedIPs returns the networks in the allowed_ips configured on the passed in channel, if any, which requests
+// to its routes are only allowed from
+func ChannelAllowedIPs(channel Channel) ([]*net.IPNet, error) {
+	var cidrs []string
+	switch config := channel.ConfigForKey(ConfigAllowedIPs, nil).(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		cidrs = config
+	case []interface{}:
+		for _, value := range config {
+			cidr, isString := value.(string)
+			if !isString {
+				return nil, fmt.Errorf("invalid allowed_ips set for %s channel: %v", channel.ChannelType(), config)
+			}
+			cidrs = append(cidrs, cidr)
+		}
+	default:
+		return nil, fmt.Errorf("invalid allowed_ips set for %s channel: %v", channel.ChannelType(), config)
+	}
+
+	networks, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed_ips set for %s channel: %s", channel.ChannelType(), err)
+	}
+	return networks, nil
+}
+
+// ClientIP returns the IP address of the client which made the passed in request, or nil if it can't be determined.
+// Requests from one of the passed in trusted proxies are attributed to the address that proxy reports, walking back
+// through X-Forwarded-For until we reach an address which isn't one of our proxies, or using X-Real-IP if there is no
+// X-Forwarded-For. These headers are ignored on requests from anywhere else as they could have been spoofed.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if !ipInNetworks(ip, trustedProxies) {
+		return ip
+	}
+
+	// each proxy appends the address it received the request from, so the client is the last one we don't trust
+	forwarded := []string{}
+	for _, header := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !ipInNetworks(ip, trustedProxies) {
+			return ip
+		}
+	}
+
+	if len(forwarded) == 0 {
+		realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+		if realIP != nil {
+			return realIP
+		}
+	}
+	return ip
+}
+
+// RequestClientIP returns the IP address of the client which made the passed in request, or nil if it can't be
+// determined. Our router resolves this from the headers of any trusted proxies before routing the request, so this is
+// the request's remote address.
+func RequestClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// trustedRealIP is router middleware which replaces the remote address of requests from the passed in trusted proxies
+// with the address of the client behind them, the headers of requests from anywhere else are ignored. Without any
+// trusted proxies we behave like chi's RealIP and believe the headers of every request.
+func trustedRealIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trustedProxies) == 0 {
+			return middleware.RealIP(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+
+			ip := ClientIP(r, trustedProxies)
+			if ip != nil && ip.String() != host {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ipInNetworks returns whether the passed in IP is in any of the passed in networks
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
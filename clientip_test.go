@@ -0,0 +1,111 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", " 192.168.1.1/32"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(networks))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/8", "10.0.0.1"})
+	assert.EqualError(t, err, "invalid CIDR '10.0.0.1': invalid CIDR address: 10.0.0.1")
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseCIDRs([]string{"10.0.0.0/8", "172.16.0.0/12"})
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		clientIP   string
+	}{
+		// requests straight from clients are theirs, whatever their headers say
+		{"8.8.8.8:1234", nil, "", "8.8.8.8"},
+		{"8.8.8.8:1234", []string{"1.1.1.1"}, "1.1.1.1", "8.8.8.8"},
+		{"8.8.8.8", nil, "", "8.8.8.8"},
+
+		// requests through our proxies are from the last address we don't trust
+		{"10.0.0.1:1234", []string{"1.1.1.1"}, "", "1.1.1.1"},
+		{"10.0.0.1:1234", []string{"9.9.9.9, 1.1.1.1, 172.16.0.2"}, "", "1.1.1.1"},
+		{"10.0.0.1:1234", []string{"9.9.9.9, 1.1.1.1", "172.16.0.2"}, "", "1.1.1.1"},
+		{"10.0.0.1:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+
+		// falling back to X-Real-IP if there is no X-Forwarded-For
+		{"10.0.0.1:1234", nil, "1.1.1.1", "1.1.1.1"},
+		{"10.0.0.1:1234", []string{"1.1.1.1"}, "2.2.2.2", "1.1.1.1"},
+
+		// and to the last proxy we trusted if the chain is all proxies or we can't read it
+		{"10.0.0.1:1234", []string{"172.16.0.2"}, "", "172.16.0.2"},
+		{"10.0.0.1:1234", []string{"1.1.1.1, unknown"}, "", "10.0.0.1"},
+		{"10.0.0.1:1234", nil, "", "10.0.0.1"},
+	}
+
+	for _, tc := range tcs {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for _, forwarded := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", forwarded)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		assert.Equal(t, tc.clientIP, ClientIP(r, proxies).String(), "client ip mismatch for %s %v", tc.remoteAddr, tc.forwarded)
+	}
+
+	// garbage remote addresses give us no client
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "pipe"
+	assert.Nil(t, ClientIP(r, proxies))
+
+}
+
+func TestRequestClientIP(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", map[string]interface{}{}))
+
+	tcs := []struct {
+		trustedProxies []string
+		remoteAddr     string
+		forwarded      string
+		clientIP       string
+	}{
+		// our router resolves the client behind our trusted proxies
+		{[]string{"10.0.0.0/8"}, "10.0.0.1:1234", "1.1.1.1", "1.1.1.1"},
+		{[]string{"10.0.0.0/8"}, "8.8.8.8:1234", "1.1.1.1", "8.8.8.8"},
+
+		// and without any trusts the headers of every request
+		{nil, "8.8.8.8:1234", "1.1.1.1, 10.0.0.1", "1.1.1.1"},
+		{nil, "8.8.8.8:1234", "", "8.8.8.8"},
+	}
+
+	for _, tc := range tcs {
+		cfg := config.NewTest()
+		cfg.TrustedProxies = tc.trustedProxies
+		s := NewServerWithLogger(cfg, mb, logrus.New())
+
+		clientIP := ""
+		s.AddHandlerRoute(NewHandler(), "POST", "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+			clientIP = RequestClientIP(r).String()
+			return nil, WriteIgnored(ctx, w, r, "ignored")
+		})
+
+		r := httptest.NewRequest("POST", "/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		s.Router().ServeHTTP(httptest.NewRecorder(), r)
+		assert.Equal(t, tc.clientIP, clientIP, "client ip mismatch for %s %s", tc.remoteAddr, tc.forwarded)
+	}
+}
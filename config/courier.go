@@ -57,12 +57,50 @@ type Courier struct {
 	// StatusPassword is the password that is needed to authenticate against the /status endpoint
 	StatusPassword string `default:""`
 
+	// AdminToken is the token needed to use our maintenance endpoints, empty disables them
+	AdminToken string `default:""`
+
+	// StatusPollWindow is how many minutes a sent msg can go without a status report before we poll its provider for
+	// its status, for handlers which support it, 0 disables polling
+	StatusPollWindow int `default:"0"`
+
+	// FailUnreportedMsgs is whether we fail sent msgs which are still missing a delivery report once the window their
+	// handler declares for them has passed, off by default as some channels never send reports
+	FailUnreportedMsgs bool `default:"false"`
+
+	// StatusWebhookURL is a URL we post the statuses of msgs to once they are delivered or failed, empty disables it
+	StatusWebhookURL string `default:""`
+
+	// StatusWebhookSecret is the secret used to sign the payloads we post to our status webhook
+	StatusWebhookSecret string `default:""`
+
+	// MaxRequestBytes is the largest request body we accept on channel routes, larger requests are rejected with a 413, 0 disables the limit
+	MaxRequestBytes int `default:"1048576"`
+
+	// ChannelLogSampleRate controls how many successful channel logs are written, 1 in every N, logs with errors are always written
+	ChannelLogSampleRate int `default:"1"`
+
 	// LogLevel controls the logging level courier uses
 	LogLevel string `default:"error"`
 
+	// DebugHTTP controls whether the full requests channels make to their providers, and their responses, are logged
+	// with any credentials redacted, for handlers which support it. Channels can also enable this with debug_http.
+	DebugHTTP bool `default:"false"`
+
 	// IgnoreDeliveryReports controls whether we ignore delivered status reports (errors will still be handled)
 	IgnoreDeliveryReports bool `default:"false"`
 
+	// TrustedProxies is the CIDR ranges of the proxies in front of us, e.g. our load balancer, whose X-Forwarded-For and
+	// X-Real-IP headers we trust to tell us the address of the client behind them, empty trusts those of every request
+	TrustedProxies []string
+
+	// HandlerMiddleware is the middleware we wrap channel handler routes in, outermost first, any of log and time
+	HandlerMiddleware []string
+
+	// AllowedIPs is the IP ranges requests to the routes of channel types are only allowed from, each a channel type and
+	// a CIDR range, e.g. IB:62.140.31.0/24, channel types without any are open to everyone
+	AllowedIPs []string
+
 	// IncludeChannels is the list of channels to enable, empty means include all
 	IncludeChannels []string
 
@@ -3,6 +3,7 @@ package courier
 import (
 	"context"
 	"net/http"
+	"time"
 )
 
 // Event is our interface for the types of things a ChannelHandleFunc can return.
@@ -24,6 +25,39 @@ type ChannelHandler interface {
 	SendMsg(context.Context, Msg) (MsgStatus, error)
 }
 
+// SendLimitedHandler is an optional interface handlers can implement to limit the number of concurrent sends
+// per channel. AcquireSend should block until a send can proceed, returning a function to call once it is done.
+type SendLimitedHandler interface {
+	AcquireSend(context.Context, Channel) (func(), error)
+}
+
+// BulkSendingHandler is an optional interface handlers can implement to send many msgs in as few requests to their
+// provider as possible, e.g. for large broadcasts. Our senders pass them the msgs waiting on the same channel together,
+// and a status is returned for each of the passed in msgs, in order.
+type BulkSendingHandler interface {
+	SendMsgs(context.Context, []Msg) []MsgStatus
+}
+
+// ConfigValidatingHandler is an optional interface handlers can implement to validate a channel's config before it
+// goes live. If verify is true, the handler should also check the configured credentials against the provider.
+type ConfigValidatingHandler interface {
+	ValidateConfig(ctx context.Context, channel Channel, verify bool) error
+}
+
+// StatusPollingHandler is an optional interface handlers can implement to query their provider for the current status
+// of a sent msg. It is used to reconcile msgs whose status reports never arrived and should return a nil status if the
+// msg is still pending with the provider.
+type StatusPollingHandler interface {
+	PollStatus(context.Context, Msg) (MsgStatus, error)
+}
+
+// DeliveryReportingHandler is an optional interface handlers can implement to declare how long after sending a msg
+// their provider can take to send its final status report. Msgs which are still sent once this window has passed are
+// failed, as their reports are never coming.
+type DeliveryReportingHandler interface {
+	DeliveryReportWindow() time.Duration
+}
+
 // RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
@@ -1,6 +1,10 @@
 package courier
 
-import "context"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 func init() {
 	RegisterHandler(NewHandler())
@@ -9,6 +13,9 @@ func init() {
 type dummyHandler struct {
 	server  Server
 	backend Backend
+
+	mutex     sync.Mutex
+	bulkSends [][]MsgID
 }
 
 // NewHandler returns a new Dummy handler
@@ -30,3 +37,36 @@ func (h *dummyHandler) Initialize(s Server) error {
 func (h *dummyHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
 	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgSent), nil
 }
+
+// SendMsgs sends the passed in messages together, recording their ids so tests can check how they were batched
+func (h *dummyHandler) SendMsgs(ctx context.Context, msgs []Msg) []MsgStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ids := make([]MsgID, len(msgs))
+	statuses := make([]MsgStatus, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID()
+		statuses[i] = h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgSent)
+	}
+	h.bulkSends = append(h.bulkSends, ids)
+	return statuses
+}
+
+// BulkSends returns the ids of the messages in each of the bulk sends we've made
+func (h *dummyHandler) BulkSends() [][]MsgID {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.bulkSends
+}
+
+// PollStatus returns a delivered status for msgs with an external id of "delivered", all others are still pending
+func (h *dummyHandler) PollStatus(ctx context.Context, msg Msg) (MsgStatus, error) {
+	if msg.ExternalID() != "delivered" {
+		return nil, nil
+	}
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgDelivered), nil
+}
+
+// DeliveryReportWindow returns how long our provider can take to send a final status report
+func (h *dummyHandler) DeliveryReportWindow() time.Duration { return time.Hour * 4 }
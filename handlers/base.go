@@ -2,18 +2,25 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"reflect"
 	"regexp"
 	"strings"
+	"text/template"
 
 	"github.com/gorilla/schema"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/phonenumbers"
 	validator "gopkg.in/go-playground/validator.v9"
 )
 
@@ -26,11 +33,24 @@ type BaseHandler struct {
 	name        string
 	server      courier.Server
 	backend     courier.Backend
+	limiter     *SendLimiter
+	breaker     *CircuitBreaker
+	recipients  *RecipientLimiter
+	ack         *courier.Ack
+	ignored     int
+	phoneFormat PhoneFormat
 }
 
 // NewBaseHandler returns a newly constructed BaseHandler with the passed in parameters
 func NewBaseHandler(channelType courier.ChannelType, name string) BaseHandler {
-	return BaseHandler{channelType: channelType, name: name}
+	return BaseHandler{
+		channelType: channelType,
+		name:        name,
+		limiter:     NewSendLimiter(),
+		breaker:     NewCircuitBreaker(),
+		recipients:  NewRecipientLimiter(),
+		ignored:     http.StatusOK,
+	}
 }
 
 // SetServer can be used to change the server on a BaseHandler
@@ -49,6 +69,73 @@ func (h *BaseHandler) Backend() courier.Backend {
 	return h.backend
 }
 
+// SetAck sets the acknowledgement our provider expects when we accept msgs and status updates from it, handlers which
+// set one should use WriteMsgSuccess and WriteStatusSuccess on the handler to write their responses
+func (h *BaseHandler) SetAck(ack *courier.Ack) {
+	h.ack = ack
+}
+
+// SetIgnoredStatus sets the status code we respond with when we ignore a request, e.g. a duplicate, for providers
+// which retry requests that aren't acknowledged with a specific code. Handlers which set one should use WriteIgnored
+// on the handler to write their responses.
+func (h *BaseHandler) SetIgnoredStatus(statusCode int) {
+	h.ignored = statusCode
+}
+
+// SetPhoneFormat sets the format our provider expects phone numbers to be sent in
+func (h *BaseHandler) SetPhoneFormat(format PhoneFormat) {
+	h.phoneFormat = format
+}
+
+// FormatPhone returns the phone number of the passed in msg's URN in the format our provider expects
+func (h *BaseHandler) FormatPhone(msg courier.Msg) string {
+	return FormatPhoneNumber(msg.URN(), msg.Channel().Country(), h.phoneFormat)
+}
+
+// WriteMsgSuccess writes our ack if we have one, or the default JSON response otherwise, for the passed in msgs
+func (h *BaseHandler) WriteMsgSuccess(ctx context.Context, w http.ResponseWriter, r *http.Request, msgs []courier.Msg) error {
+	if h.ack != nil {
+		return courier.WriteMsgAck(ctx, w, r, msgs, h.ack)
+	}
+	return courier.WriteMsgSuccess(ctx, w, r, msgs)
+}
+
+// WriteStatusSuccess writes our ack if we have one, or the default JSON response otherwise, for the passed in statuses
+func (h *BaseHandler) WriteStatusSuccess(ctx context.Context, w http.ResponseWriter, r *http.Request, statuses []courier.MsgStatus) error {
+	if h.ack != nil {
+		return courier.WriteStatusAck(ctx, w, r, statuses, h.ack)
+	}
+	return courier.WriteStatusSuccess(ctx, w, r, statuses)
+}
+
+// WriteIgnored writes the default JSON response for an ignored request with our ignored status code
+func (h *BaseHandler) WriteIgnored(ctx context.Context, w http.ResponseWriter, r *http.Request, details string) error {
+	return courier.WriteIgnoredWithStatus(ctx, w, r, h.ignored, details)
+}
+
+// AcquireSend blocks until the passed in channel is below its configured limit of concurrent sends
+func (h *BaseHandler) AcquireSend(ctx context.Context, channel courier.Channel) (func(), error) {
+	return h.limiter.Acquire(ctx, channel)
+}
+
+// SendAllowed returns whether the circuit breaker for the passed in channel allows a send, handlers which check this
+// must report the outcome of their sends using RecordSend
+func (h *BaseHandler) SendAllowed(channel courier.Channel) bool {
+	return h.breaker.Allow(channel)
+}
+
+// RecordSend records the outcome of a send on the passed in channel with our circuit breaker, sends which errored
+// count towards opening the circuit
+func (h *BaseHandler) RecordSend(channel courier.Channel, status courier.MsgStatus) {
+	h.breaker.Record(channel, status.Status() == courier.MsgErrored)
+}
+
+// CheckRecipientRate returns an error if the passed in channel has already sent as many messages to the passed in URN as
+// its recipient rate limit allows, otherwise the send is counted towards that limit
+func (h *BaseHandler) CheckRecipientRate(channel courier.Channel, urn urns.URN) error {
+	return h.recipients.Allow(channel, urn)
+}
+
 // ChannelType returns the channel type that this handler deals with
 func (h *BaseHandler) ChannelType() courier.ChannelType {
 	return h.channelType
@@ -84,6 +171,108 @@ func NameFromFirstLastUsername(first string, last string, username string) strin
 	return ""
 }
 
+var nonTelCharsRegex = regexp.MustCompile(`[^0-9a-z+]`)
+
+// NewTelURNForChannel creates a new tel URN for the passed in number, falling back to the channel's country only when
+// the number isn't already in international format. Numbers with a leading + are always parsed as international, as
+// are numbers which aren't valid in the channel's country but are valid once prefixed with a +.
+func NewTelURNForChannel(number string, channel courier.Channel) urns.URN {
+	country := channel.Country()
+	cleaned := nonTelCharsRegex.ReplaceAllString(strings.ToLower(strings.TrimSpace(number)), "")
+
+	// valid as a national number in our country, use that
+	if !strings.HasPrefix(cleaned, "+") {
+		parsed, err := phonenumbers.Parse(cleaned, country)
+		if err == nil && phonenumbers.IsValidNumber(parsed) {
+			return urns.NewTelURNForCountry(cleaned, country)
+		}
+	}
+
+	// valid as an international number, don't let our country get in the way
+	parsed, err := phonenumbers.Parse("+"+strings.TrimPrefix(cleaned, "+"), "")
+	if err == nil && phonenumbers.IsValidNumber(parsed) {
+		return urns.NewURNFromParts(urns.TelScheme, phonenumbers.Format(parsed, phonenumbers.E164), "")
+	}
+
+	return urns.NewTelURNForCountry(number, country)
+}
+
+// PhoneFormat is the format a provider expects the phone numbers we send to to be in
+type PhoneFormat int
+
+const (
+	// PhoneFormatE164 is international format with a leading +, e.g. +250788383383
+	PhoneFormatE164 PhoneFormat = iota
+
+	// PhoneFormatE164NoPlus is international format without a leading +, e.g. 250788383383
+	PhoneFormatE164NoPlus
+
+	// PhoneFormatNational is the digits of the national format, e.g. 0788383383, numbers from countries other than
+	// the channel's are left in international format without a leading +
+	PhoneFormatNational
+)
+
+var nonDigitsRegex = regexp.MustCompile(`[^0-9]`)
+
+// FormatPhoneNumber formats the path of the passed in URN as the passed in format. Numbers which aren't valid, such
+// as short codes, are only stripped of any leading + when the format doesn't want one.
+func FormatPhoneNumber(urn urns.URN, country string, format PhoneFormat) string {
+	path := urn.Path()
+	parsed, err := phonenumbers.Parse("+"+strings.TrimPrefix(path, "+"), "")
+	if err != nil || !phonenumbers.IsValidNumber(parsed) {
+		if format == PhoneFormatE164 {
+			return path
+		}
+		return strings.TrimLeft(path, "+")
+	}
+
+	switch format {
+	case PhoneFormatE164NoPlus:
+		return strings.TrimPrefix(phonenumbers.Format(parsed, phonenumbers.E164), "+")
+	case PhoneFormatNational:
+		if phonenumbers.GetRegionCodeForNumber(parsed) == strings.ToUpper(country) {
+			return nonDigitsRegex.ReplaceAllString(phonenumbers.Format(parsed, phonenumbers.NATIONAL), "")
+		}
+		return strings.TrimPrefix(phonenumbers.Format(parsed, phonenumbers.E164), "+")
+	default:
+		return phonenumbers.Format(parsed, phonenumbers.E164)
+	}
+}
+
+// ResolveChannel returns the channel an incoming message sent to the passed in address should be received on. This
+// is the passed in channel unless our backend implements courier.ChannelResolver and finds a better one.
+func ResolveChannel(ctx context.Context, b courier.Backend, channel courier.Channel, to string, from urns.URN) (courier.Channel, error) {
+	resolver, isResolver := b.(courier.ChannelResolver)
+	if !isResolver || to == "" {
+		return channel, nil
+	}
+
+	resolved, err := resolver.ResolveChannel(ctx, channel.ChannelType(), to, from)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return channel, nil
+	}
+	return resolved, nil
+}
+
+// StatusForRequestError returns the status a send which failed to get a response should be given, this is errored
+// so that it is retried unless the channel maps the type of error to wired or failed in its request_error_statuses
+func StatusForRequestError(channel courier.Channel, rr *utils.RequestResponse) courier.MsgStatusValue {
+	if rr == nil || rr.ErrorType == "" {
+		return courier.MsgErrored
+	}
+
+	statuses, _ := channel.ConfigForKey(courier.ConfigRequestErrorStatuses, nil).(map[string]interface{})
+	status, _ := statuses[string(rr.ErrorType)].(string)
+	switch courier.MsgStatusValue(status) {
+	case courier.MsgWired, courier.MsgFailed:
+		return courier.MsgStatusValue(status)
+	}
+	return courier.MsgErrored
+}
+
 // Validate validates the passe din struct using our shared validator instance
 func Validate(form interface{}) error {
 	return validate.Struct(form)
@@ -128,6 +317,18 @@ func DecodeAndValidateQueryParams(form interface{}, r *http.Request) error {
 	return nil
 }
 
+// ReadBody reads the body of the passed in request, replacing it so it can still be decoded afterwards
+func ReadBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request body: %s", err)
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
 // DecodeAndValidateJSON takes the passed in envelope and tries to unmarshal it from the body
 // of the passed in request, then validating it
 func DecodeAndValidateJSON(envelope interface{}, r *http.Request) error {
@@ -152,6 +353,132 @@ func DecodeAndValidateJSON(envelope interface{}, r *http.Request) error {
 	return nil
 }
 
+// DecodeAndValidateXML takes the passed in envelope and tries to unmarshal it from the body
+// of the passed in request, then validating it
+func DecodeAndValidateXML(envelope interface{}, r *http.Request) error {
+	// read our body
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	defer r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+
+	// try to decode our envelope
+	if err = xml.Unmarshal(body, envelope); err != nil {
+		return fmt.Errorf("unable to parse request XML: %s", err)
+	}
+
+	// check our input is valid
+	err = validate.Struct(envelope)
+	if err != nil {
+		return fmt.Errorf("request XML doesn't match required schema: %s", err)
+	}
+
+	return nil
+}
+
+// DecodeAndValidateJSONItems is like DecodeAndValidateJSON for envelopes which carry a batch of items, items being a
+// pointer to the slice of them in envelope. Each item is validated on its own, invalid ones being removed from the
+// slice and their errors returned, so that a single bad item doesn't reject the whole batch.
+func DecodeAndValidateJSONItems(envelope interface{}, items interface{}, r *http.Request) ([]error, error) {
+	err := DecodeAndValidateJSON(envelope, r)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateItems(items), nil
+}
+
+// ValidateItems validates each item of the slice pointed to by items, removing those which are invalid from it and
+// returning their errors
+func ValidateItems(items interface{}) []error {
+	value := reflect.ValueOf(items)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return []error{fmt.Errorf("items must be a pointer to a slice, not %T", items)}
+	}
+
+	slice := value.Elem()
+	valid := reflect.MakeSlice(slice.Type(), 0, slice.Len())
+	errs := []error{}
+	for i := 0; i < slice.Len(); i++ {
+		item := slice.Index(i)
+
+		var err error
+		switch item.Kind() {
+		case reflect.Struct:
+			err = validate.Struct(item.Addr().Interface())
+		case reflect.Ptr:
+			if item.IsNil() {
+				err = fmt.Errorf("item is null")
+			} else {
+				err = validate.Struct(item.Interface())
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("item %d doesn't match required schema: %s", i, err))
+			continue
+		}
+		valid = reflect.Append(valid, item)
+	}
+
+	slice.Set(valid)
+	return errs
+}
+
+// TextTemplateContext is the context available to the prefix and suffix templates applied by ApplyTextTemplates
+type TextTemplateContext struct {
+	To      string
+	From    string
+	Channel string
+	ID      string
+}
+
+// ApplyTextTemplates wraps the passed in outgoing text with the prefix and suffix templates configured on the msg's
+// channel, if any. Templates use text/template syntax and can reference the fields of TextTemplateContext, for
+// example a suffix of "\nReply STOP to {{.From}} to opt out"
+func ApplyTextTemplates(msg courier.Msg, text string) (string, error) {
+	prefix := msg.Channel().StringConfigForKey(courier.ConfigTextPrefix, "")
+	suffix := msg.Channel().StringConfigForKey(courier.ConfigTextSuffix, "")
+	if prefix == "" && suffix == "" {
+		return text, nil
+	}
+
+	context := TextTemplateContext{
+		To:      msg.URN().Path(),
+		From:    msg.Channel().Address(),
+		Channel: msg.Channel().Name(),
+		ID:      msg.ID().String(),
+	}
+
+	output := &bytes.Buffer{}
+	if err := executeTextTemplate(output, courier.ConfigTextPrefix, prefix, context); err != nil {
+		return "", err
+	}
+	output.WriteString(text)
+	if err := executeTextTemplate(output, courier.ConfigTextSuffix, suffix, context); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// executeTextTemplate parses and executes the passed in template into our output
+func executeTextTemplate(output *bytes.Buffer, name string, text string, context TextTemplateContext) error {
+	if text == "" {
+		return nil
+	}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid %s template: %s", name, err)
+	}
+
+	err = t.Execute(output, context)
+	if err != nil {
+		return fmt.Errorf("error executing %s template: %s", name, err)
+	}
+	return nil
+}
+
 /*
 DecodePossibleBase64 detects and decodes a possibly base64 encoded messages by doing:
  * check it's at least 60 characters
@@ -213,3 +540,67 @@ func SplitMsg(text string, max int) []string {
 
 	return parts
 }
+
+// MaxAttachmentSize is the largest attachment in bytes that FetchAttachment will download
+var MaxAttachmentSize int64 = 1024 * 1024 * 25
+
+// FetchAttachment downloads the media at the passed in URL and saves it to the backend's attachment store,
+// returning an attachment string (content type and storage URL) suitable for use with Msg.WithAttachment. Providers
+// whose media needs credentials can pass in authorize to add them to our request, nil fetches without any.
+func FetchAttachment(ctx context.Context, b courier.Backend, channel courier.Channel, url string, authorize func(*http.Request) error) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", utils.HTTPUserAgent)
+
+	if authorize != nil {
+		err = authorize(req)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resp, err := utils.GetHTTPClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("received non 200 status fetching attachment: %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > MaxAttachmentSize {
+		return "", fmt.Errorf("attachment too large: %d bytes", resp.ContentLength)
+	}
+
+	// read one more byte than we allow so we can tell if we were truncated
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxAttachmentSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > MaxAttachmentSize {
+		return "", fmt.Errorf("attachment too large: more than %d bytes", MaxAttachmentSize)
+	}
+
+	// use the content type we were given, otherwise try to figure it out from the body
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	extension := ""
+	extensions, err := mime.ExtensionsByType(contentType)
+	if err == nil && len(extensions) > 0 {
+		extension = extensions[0][1:]
+	}
+
+	storageURL, err := b.SaveAttachment(ctx, channel, contentType, body, extension)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%s", contentType, storageURL), nil
+}
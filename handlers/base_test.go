@@ -1,8 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,6 +33,47 @@ b3RlY3Rpb25zIGF3YXkuIAoKVm90ZSBOTyBvbiBhbnkgYmlsbCB0aGF0IGRvZXNuJ3QgY29udGFp
 biBwcm90ZWN0aW9ucyBpbnN0ZWFkIG9mIHB1bmlzaG1lbnRzLiBXZSBhcmUgd2F0Y2hpbmcgY2xv
 c2VseS4g`
 
+func TestDecodeAndValidateJSONItems(t *testing.T) {
+	type item struct {
+		ID   string `json:"id" validate:"required"`
+		Text string `json:"text"`
+	}
+	type envelope struct {
+		Items []item `json:"items" validate:"required"`
+	}
+
+	// invalid items are dropped and their errors returned, the valid ones are kept in order
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"items":[{"id":"1","text":"a"},{"text":"b"},{"id":"3","text":"c"}]}`))
+	env := &envelope{}
+	errs, err := DecodeAndValidateJSONItems(env, &env.Items, r)
+	assert.NoError(t, err)
+	assert.Equal(t, []item{{"1", "a"}, {"3", "c"}}, env.Items)
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "item 1 doesn't match required schema")
+
+	// an invalid envelope is still an error
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"other":[]}`))
+	env = &envelope{}
+	_, err = DecodeAndValidateJSONItems(env, &env.Items, r)
+	assert.Error(t, err)
+
+	// as is JSON we can't parse
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"items":`))
+	env = &envelope{}
+	_, err = DecodeAndValidateJSONItems(env, &env.Items, r)
+	assert.Error(t, err)
+
+	// pointer items are validated too, with nulls being invalid
+	ptrs := []*item{{ID: "1"}, nil, {Text: "c"}}
+	errs = ValidateItems(&ptrs)
+	assert.Equal(t, []*item{{ID: "1"}}, ptrs)
+	assert.Equal(t, 2, len(errs))
+
+	// and we only accept pointers to slices
+	errs = ValidateItems(ptrs)
+	assert.Equal(t, 1, len(errs))
+}
+
 func TestDecodePossibleBase64(t *testing.T) {
 	assert := assert.New(t)
 	assert.Equal("This test\nhas a newline", DecodePossibleBase64("This test\nhas a newline"))
@@ -43,3 +92,189 @@ func TestSplitMsg(t *testing.T) {
 	assert.Equal([]string{" "}, SplitMsg(" ", 20))
 	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMsg("This is a message   longer than 10", 20))
 }
+
+func TestNewTelURNForChannel(t *testing.T) {
+	usChannel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil)
+	rwChannel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "RW", nil)
+	noCountryChannel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "", nil)
+
+	tcs := []struct {
+		number  string
+		channel courier.Channel
+		urn     string
+	}{
+		{"0788383383", rwChannel, "tel:+250788383383"},
+		{"2067799294", usChannel, "tel:+12067799294"},
+		{"(206) 779-9294", usChannel, "tel:+12067799294"},
+		{"+385916242493", usChannel, "tel:+385916242493"},
+		{"385916242493", usChannel, "tel:+385916242493"},
+		{"+250788383383", usChannel, "tel:+250788383383"},
+		{"4532123456", usChannel, "tel:+4532123456"},
+		{"4532123456", noCountryChannel, "tel:+4532123456"},
+		{"12345", usChannel, "tel:12345"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.urn, string(NewTelURNForChannel(tc.number, tc.channel)), "urn mismatch for %s", tc.number)
+	}
+}
+
+func TestFormatPhoneNumber(t *testing.T) {
+	tcs := []struct {
+		urn     urns.URN
+		country string
+		format  PhoneFormat
+		number  string
+	}{
+		{"tel:+250788383383", "RW", PhoneFormatE164, "+250788383383"},
+		{"tel:+250788383383", "RW", PhoneFormatE164NoPlus, "250788383383"},
+		{"tel:+250788383383", "RW", PhoneFormatNational, "0788383383"},
+		{"tel:+250788383383", "US", PhoneFormatNational, "250788383383"},
+		{"tel:+12067799294", "us", PhoneFormatNational, "2067799294"},
+		{"tel:12345", "RW", PhoneFormatE164, "12345"},
+		{"tel:+12345", "RW", PhoneFormatE164NoPlus, "12345"},
+		{"tel:12345", "RW", PhoneFormatNational, "12345"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.number, FormatPhoneNumber(tc.urn, tc.country, tc.format), "number mismatch for %s", tc.urn)
+	}
+}
+
+func TestApplyTextTemplates(t *testing.T) {
+	mb := courier.NewMockBackend()
+
+	tcs := []struct {
+		config map[string]interface{}
+		text   string
+		output string
+		err    string
+	}{
+		{map[string]interface{}{}, "Hello", "Hello", ""},
+		{map[string]interface{}{courier.ConfigTextPrefix: "[Acme] "}, "Hello", "[Acme] Hello", ""},
+		{map[string]interface{}{courier.ConfigTextSuffix: "\nReply STOP to {{.From}}"}, "Hello", "Hello\nReply STOP to 2020", ""},
+		{map[string]interface{}{courier.ConfigTextPrefix: "{{.To}}: ", courier.ConfigTextSuffix: " ({{.ID}})"}, "Hello", "+250788383383: Hello (10)", ""},
+		{map[string]interface{}{courier.ConfigTextPrefix: "{{.To"}, "Hello", "", "invalid text_prefix template: template: text_prefix:1: unclosed action"},
+		{map[string]interface{}{courier.ConfigTextSuffix: "{{.Missing}}"}, "Hello", "", "error executing text_suffix template: template: text_suffix:1:2: executing \"text_suffix\" at <.Missing>: can't evaluate field Missing in type handlers.TextTemplateContext"},
+	}
+
+	for _, tc := range tcs {
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", tc.config)
+		msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), urns.URN("tel:+250788383383"), tc.text, false, nil)
+
+		output, err := ApplyTextTemplates(msg, tc.text)
+		if tc.err == "" {
+			assert.NoError(t, err)
+			assert.Equal(t, tc.output, output)
+		} else {
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+func TestFetchAttachment(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("imagebytes"))
+		case "/unknown":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("<html><body>hello</body></html>"))
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 101)))
+		case "/private.png":
+			if r.Header.Get("Authorization") != "App sesame" {
+				w.WriteHeader(401)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("privatebytes"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	mb := courier.NewMockBackend()
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil)
+	ctx := context.Background()
+
+	attachment, err := FetchAttachment(ctx, mb, channel, server.URL+"/image.png", nil)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(attachment, "image/png:https://backend.com/attachments/"))
+	assert.True(strings.HasSuffix(attachment, ".png"))
+	_, url := courier.SplitAttachment(attachment)
+	assert.Equal([]byte("imagebytes"), mb.GetAttachment(url))
+
+	attachment, err = FetchAttachment(ctx, mb, channel, server.URL+"/unknown", nil)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(attachment, "text/html:"))
+
+	_, err = FetchAttachment(ctx, mb, channel, server.URL+"/missing", nil)
+	assert.EqualError(err, "received non 200 status fetching attachment: 404")
+
+	// media which needs credentials is fetched with those our handler adds
+	_, err = FetchAttachment(ctx, mb, channel, server.URL+"/private.png", nil)
+	assert.EqualError(err, "received non 200 status fetching attachment: 401")
+
+	authorize := func(req *http.Request) error { req.Header.Set("Authorization", "App sesame"); return nil }
+	attachment, err = FetchAttachment(ctx, mb, channel, server.URL+"/private.png", authorize)
+	assert.NoError(err)
+	_, url = courier.SplitAttachment(attachment)
+	assert.Equal([]byte("privatebytes"), mb.GetAttachment(url))
+
+	_, err = FetchAttachment(ctx, mb, channel, server.URL+"/private.png", func(req *http.Request) error { return errors.New("no token") })
+	assert.EqualError(err, "no token")
+
+	defer func(size int64) { MaxAttachmentSize = size }(MaxAttachmentSize)
+	MaxAttachmentSize = 100
+	_, err = FetchAttachment(ctx, mb, channel, server.URL+"/large", nil)
+	assert.EqualError(err, "attachment too large: 101 bytes")
+}
+
+func TestStatusForRequestError(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{
+		courier.ConfigRequestErrorStatuses: map[string]interface{}{"timeout": "W", "dns": "F", "canceled": "D"},
+	})
+	unmapped := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil)
+
+	tcs := []struct {
+		channel   courier.Channel
+		errorType utils.RequestErrorType
+		status    courier.MsgStatusValue
+	}{
+		{channel, utils.RequestErrorTimeout, courier.MsgWired},
+		{channel, utils.RequestErrorDNS, courier.MsgFailed},
+		{channel, utils.RequestErrorCanceled, courier.MsgErrored},
+		{channel, utils.RequestErrorConnectionRefused, courier.MsgErrored},
+		{channel, "", courier.MsgErrored},
+		{unmapped, utils.RequestErrorTimeout, courier.MsgErrored},
+	}
+
+	for _, tc := range tcs {
+		status := StatusForRequestError(tc.channel, &utils.RequestResponse{ErrorType: tc.errorType})
+		assert.Equal(t, tc.status, status, "status mismatch for %s", tc.errorType)
+	}
+	assert.Equal(t, courier.MsgErrored, StatusForRequestError(channel, nil))
+}
+
+func TestWriteIgnored(t *testing.T) {
+	h := NewBaseHandler(courier.ChannelType("IB"), "Infobip")
+	r := httptest.NewRequest(http.MethodPost, "/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive", nil)
+
+	// by default ignored requests are acknowledged with a 200
+	w := httptest.NewRecorder()
+	assert.NoError(t, h.WriteIgnored(context.Background(), w, r, "ignoring request, duplicate"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "ignoring request, duplicate")
+
+	h.SetIgnoredStatus(http.StatusAccepted)
+
+	w = httptest.NewRecorder()
+	assert.NoError(t, h.WriteIgnored(context.Background(), w, r, "ignoring request, duplicate"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), "ignoring request, duplicate")
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// CircuitState is the state of the circuit for a channel
+type CircuitState string
+
+// Possible values for CircuitState
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// the number of seconds we wait before probing a channel if it doesn't configure a cooldown
+const defaultCircuitCooldown = 60
+
+// our threshold, channels without one are never stopped, and our cooldown in seconds
+var (
+	flagCircuitThreshold = IntFlag{Key: courier.ConfigCircuitBreakerThreshold}
+	flagCircuitCooldown  = IntFlag{Key: courier.ConfigCircuitBreakerCooldown, Default: defaultCircuitCooldown}
+)
+
+// CircuitBreaker stops sends on channels which keep failing, as configured by the circuit_breaker_threshold and
+// circuit_breaker_cooldown config values on the channel. Once a channel has failed threshold times in a row its circuit
+// opens and sends are refused until the cooldown has passed, then a single probe send is allowed through. If that
+// succeeds the circuit closes again, otherwise it stays open for another cooldown. Channels without a threshold set
+// are never stopped.
+type CircuitBreaker struct {
+	mutex    sync.Mutex
+	circuits map[courier.ChannelUUID]*circuit
+	now      func() time.Time
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedOn time.Time
+}
+
+// NewCircuitBreaker creates a new CircuitBreaker with all circuits closed
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{circuits: make(map[courier.ChannelUUID]*circuit), now: time.Now}
+}
+
+// Allow returns whether a send on the passed in channel should go ahead, if it does its outcome must be passed to Record
+func (b *CircuitBreaker) Allow(channel courier.Channel) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, found := b.circuits[channel.UUID()]
+	if !found || c.state == CircuitClosed {
+		return true
+	}
+
+	// once our cooldown has passed let a probe through, we also let another through if a probe never reported back
+	if b.now().Sub(c.openedOn) < time.Duration(flagCircuitCooldown.Get(channel))*time.Second {
+		return false
+	}
+	c.state = CircuitHalfOpen
+	c.openedOn = b.now()
+	return true
+}
+
+// Record records whether a send on the passed in channel failed, opening its circuit if it has failed too many times
+func (b *CircuitBreaker) Record(channel courier.Channel, failed bool) {
+	threshold := flagCircuitThreshold.Get(channel)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !failed || threshold <= 0 {
+		delete(b.circuits, channel.UUID())
+		return
+	}
+
+	c, found := b.circuits[channel.UUID()]
+	if !found {
+		c = &circuit{state: CircuitClosed}
+		b.circuits[channel.UUID()] = c
+	}
+
+	c.failures++
+	if c.state == CircuitHalfOpen || c.failures >= threshold {
+		c.state = CircuitOpen
+		c.openedOn = b.now()
+	}
+}
+
+// State returns the current state of the circuit for the passed in channel
+func (b *CircuitBreaker) State(channel courier.Channel) CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, found := b.circuits[channel.UUID()]
+	if !found {
+		return CircuitClosed
+	}
+	return c.state
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	unprotected := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{})
+	protected := courier.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "IB", "2021", "US",
+		map[string]interface{}{
+			courier.ConfigCircuitBreakerThreshold: float64(3),
+			courier.ConfigCircuitBreakerCooldown:  float64(30),
+		})
+
+	now := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker()
+	breaker.now = func() time.Time { return now }
+
+	// channels without a threshold never open
+	for i := 0; i < 5; i++ {
+		assert.True(breaker.Allow(unprotected))
+		breaker.Record(unprotected, true)
+	}
+	assert.Equal(CircuitClosed, breaker.State(unprotected))
+
+	// a success resets our count of failures
+	breaker.Record(protected, true)
+	breaker.Record(protected, true)
+	breaker.Record(protected, false)
+	breaker.Record(protected, true)
+	breaker.Record(protected, true)
+	assert.Equal(CircuitClosed, breaker.State(protected))
+	assert.True(breaker.Allow(protected))
+
+	// our third failure in a row opens the circuit
+	breaker.Record(protected, true)
+	assert.Equal(CircuitOpen, breaker.State(protected))
+	assert.False(breaker.Allow(protected))
+
+	// until our cooldown passes
+	now = now.Add(time.Second * 29)
+	assert.False(breaker.Allow(protected))
+
+	// then a single probe is let through
+	now = now.Add(time.Second * 2)
+	assert.True(breaker.Allow(protected))
+	assert.Equal(CircuitHalfOpen, breaker.State(protected))
+	assert.False(breaker.Allow(protected))
+
+	// which failing opens the circuit for another cooldown
+	breaker.Record(protected, true)
+	assert.Equal(CircuitOpen, breaker.State(protected))
+	assert.False(breaker.Allow(protected))
+
+	// a probe which never reports back doesn't leave us half open forever
+	now = now.Add(time.Second * 31)
+	assert.True(breaker.Allow(protected))
+	assert.False(breaker.Allow(protected))
+	now = now.Add(time.Second * 31)
+	assert.True(breaker.Allow(protected))
+
+	// and a successful probe closes it
+	breaker.Record(protected, false)
+	assert.Equal(CircuitClosed, breaker.State(protected))
+	assert.True(breaker.Allow(protected))
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+)
+
+// how long we wait for a callback domain to answer a health check
+const callbackCheckTimeout = time.Second * 5
+
+// CheckCallbackDomain returns whether the courier instance behind the passed in domain is answering requests
+func CheckCallbackDomain(domain string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), callbackCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/", domain), nil)
+	if err != nil {
+		return false
+	}
+	_, err = utils.MakeHTTPRequest(req.WithContext(ctx))
+	return err == nil
+}
+
+// AddCallbackToken adds the callback token of the passed in channel, if it has one, to the passed in URL of one of its
+// routes, handlers should use this on the callback URLs they give their providers
+func AddCallbackToken(callbackURL string, channel courier.Channel) string {
+	token := channel.StringConfigForKey(courier.ConfigCallbackToken, "")
+	if token == "" {
+		return callbackURL
+	}
+
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return callbackURL
+	}
+	query := parsed.Query()
+	query.Set(courier.CallbackTokenParam, token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// CallbackDomains picks the domain a channel should register its callbacks against from the callback_domains
+// configured on it, so that providers aren't sent somewhere that won't answer. Health checks are cached so that we
+// don't check a domain on every send.
+type CallbackDomains struct {
+	mutex   sync.Mutex
+	checked map[string]callbackCheck
+	ttl     time.Duration
+	check   func(string) bool
+	now     func() time.Time
+}
+
+type callbackCheck struct {
+	healthy   bool
+	checkedOn time.Time
+}
+
+// NewCallbackDomains creates a new CallbackDomains which uses the passed in function to check domains, caching the
+// results for the passed in duration
+func NewCallbackDomains(ttl time.Duration, check func(string) bool) *CallbackDomains {
+	return &CallbackDomains{
+		checked: make(map[string]callbackCheck),
+		ttl:     ttl,
+		check:   check,
+		now:     time.Now,
+	}
+}
+
+// Select returns the first healthy callback domain configured on the passed in channel, if it has none configured or
+// none are healthy then its callback domain is returned, or the passed in fallback domain if that isn't set either
+func (c *CallbackDomains) Select(channel courier.Channel, fallbackDomain string) string {
+	domains, _ := channel.ConfigForKey(courier.ConfigCallbackDomains, nil).([]interface{})
+	for _, d := range domains {
+		domain, isStr := d.(string)
+		if isStr && domain != "" && c.healthy(domain) {
+			return domain
+		}
+	}
+	return channel.CallbackDomain(fallbackDomain)
+}
+
+// healthy returns whether the passed in domain is healthy, checking it if our last check has expired
+func (c *CallbackDomains) healthy(domain string) bool {
+	c.mutex.Lock()
+	last, found := c.checked[domain]
+	c.mutex.Unlock()
+
+	if found && c.now().Sub(last.checkedOn) < c.ttl {
+		return last.healthy
+	}
+
+	// we don't hold our lock while checking, at worst a domain is checked a few times at once
+	healthy := c.check(domain)
+
+	c.mutex.Lock()
+	c.checked[domain] = callbackCheck{healthy: healthy, checkedOn: c.now()}
+	c.mutex.Unlock()
+
+	return healthy
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackDomains(t *testing.T) {
+	healthy := map[string]bool{"cb1.example.com": false, "cb2.example.com": true}
+	checks := 0
+	domains := NewCallbackDomains(time.Minute, func(domain string) bool { checks++; return healthy[domain] })
+
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	domains.now = func() time.Time { return now }
+
+	plain := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, "courier.example.com", domains.Select(plain, "courier.example.com"))
+	assert.Equal(t, 0, checks)
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{
+		courier.ConfigCallbackDomains: []interface{}{"cb1.example.com", "cb2.example.com"},
+	})
+
+	// first domain is down so we use the second
+	assert.Equal(t, "cb2.example.com", domains.Select(channel, "courier.example.com"))
+	assert.Equal(t, 2, checks)
+
+	// checks are cached, so the first coming back up isn't noticed straight away
+	healthy["cb1.example.com"] = true
+	assert.Equal(t, "cb2.example.com", domains.Select(channel, "courier.example.com"))
+	assert.Equal(t, 2, checks)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "cb1.example.com", domains.Select(channel, "courier.example.com"))
+	assert.Equal(t, 3, checks)
+
+	// when none are up we fall back to the channel's callback domain, then the passed in one
+	healthy["cb1.example.com"], healthy["cb2.example.com"] = false, false
+	now = now.Add(time.Minute)
+	assert.Equal(t, "courier.example.com", domains.Select(channel, "courier.example.com"))
+
+	channel.(*courier.MockChannel).SetConfig(courier.ConfigCallbackDomain, "backup.example.com")
+	assert.Equal(t, "backup.example.com", domains.Select(channel, "courier.example.com"))
+}
+
+func TestAddCallbackToken(t *testing.T) {
+	withToken := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{courier.ConfigCallbackToken: "sesame"})
+	withoutToken := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil)
+
+	assert.Equal(t, "https://courier.com/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered", AddCallbackToken("https://courier.com/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered", withoutToken))
+	assert.Equal(t, "https://courier.com/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered?token=sesame", AddCallbackToken("https://courier.com/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered", withToken))
+	assert.Equal(t, "https://courier.com/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered?foo=bar&token=sesame", AddCallbackToken("https://courier.com/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered?foo=bar", withToken))
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// what credentials are replaced with in the traffic we log
+const redacted = "********"
+
+// headers whose values are always credentials
+var redactedHeaderRegex = regexp.MustCompile(`(?mi)^((?:proxy-)?authorization|x-api-key|cookie|set-cookie):[^\r\n]*`)
+
+// JSON and form fields whose values are always credentials
+var redactedJSONRegex = regexp.MustCompile(`(?i)("(?:access_token|refresh_token|password|client_secret|api_?key)"\s*:\s*)"[^"]*"`)
+var redactedFormRegex = regexp.MustCompile(`(?i)\b(access_token|refresh_token|password|client_secret|api_?key)=[^&\s]*`)
+
+// the channel config keys whose values are credentials, which are redacted wherever they appear
+var secretConfigKeys = []string{courier.ConfigPassword, courier.ConfigAPIKey, courier.ConfigAuthToken}
+
+// DebugHTTPEnabled returns whether the HTTP traffic of the passed in channel should be logged, which is the case if
+// our server or the channel has it enabled
+func (h *BaseHandler) DebugHTTPEnabled(channel courier.Channel) bool {
+	if h.server != nil && h.server.Config().DebugHTTP {
+		return true
+	}
+	enabled, _ := channel.ConfigForKey(courier.ConfigDebugHTTP, false).(bool)
+	return enabled
+}
+
+// DebugHTTP logs the full request and response of the passed in send, and of any attempts at it which were retried,
+// if debug logging of HTTP traffic is enabled for the passed in channel. This is in addition to the channel logs of the
+// send, and is meant for temporarily debugging a provider. Credentials are redacted, including the values of the
+// channel's config for the passed in secret keys. Traffic is logged at info level.
+func (h *BaseHandler) DebugHTTP(channel courier.Channel, rr *utils.RequestResponse, secretKeys ...string) {
+	if rr == nil || !h.DebugHTTPEnabled(channel) {
+		return
+	}
+
+	secrets := make([]string, 0, len(secretConfigKeys)+len(secretKeys))
+	for _, keys := range [][]string{secretConfigKeys, secretKeys} {
+		for _, key := range keys {
+			secrets = append(secrets, channel.StringConfigForKey(key, ""))
+		}
+	}
+
+	attempts := make([]*utils.RequestResponse, 0, len(rr.Retried)+1)
+	attempts = append(attempts, rr.Retried...)
+	attempts = append(attempts, rr)
+	for i, attempt := range attempts {
+		logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).
+			WithField("attempt", i+1).WithField("elapsed", attempt.Elapsed).
+			WithField("request", RedactHTTP(attempt.Request, secrets...)).
+			WithField("response", RedactHTTP(attempt.Response, secrets...)).
+			Info("channel http traffic")
+	}
+}
+
+// RedactHTTP returns the passed in dump of an HTTP request or response with any credentials in it replaced, which are
+// the values of auth headers and credential fields, and any of the passed in secrets wherever they appear
+func RedactHTTP(dump string, secrets ...string) string {
+	dump = redactedHeaderRegex.ReplaceAllString(dump, "$1: "+redacted)
+	dump = redactedJSONRegex.ReplaceAllString(dump, `$1"`+redacted+`"`)
+	dump = redactedFormRegex.ReplaceAllString(dump, "$1="+redacted)
+
+	// longer secrets are replaced first so that secrets which contain others are redacted whole
+	sorted := make([]string, len(secrets))
+	copy(sorted, secrets)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, secret := range sorted {
+		if secret != "" {
+			dump = strings.Replace(dump, secret, redacted, -1)
+		}
+	}
+	return dump
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// CheckDestination returns an error describing why the passed in channel can't send to the passed in URN, or nil if
+// it can. Channels can restrict their destinations with the allowed_destinations and blocked_destinations configs,
+// a destination must be allowed (if that is set) and not blocked. Handlers should fail sends which aren't allowed
+// without making any request to their provider.
+func CheckDestination(channel courier.Channel, urn urns.URN) error {
+	destination := urn.Path()
+
+	allowed, err := matchesDestinations(channel, courier.ConfigAllowedDestinations, destination)
+	if err != nil {
+		return err
+	}
+	if allowed != nil && !*allowed {
+		return fmt.Errorf("destination %s is not in the allowed destinations for this channel", destination)
+	}
+
+	blocked, err := matchesDestinations(channel, courier.ConfigBlockedDestinations, destination)
+	if err != nil {
+		return err
+	}
+	if blocked != nil && *blocked {
+		return fmt.Errorf("destination %s is in the blocked destinations for this channel", destination)
+	}
+	return nil
+}
+
+// CheckNotSelf returns an error if the passed in URN is the number of the passed in sender, or nil if it isn't. A
+// channel messaging its own number is always a misconfiguration and can loop, so handlers should fail these sends
+// without making any request to their provider. Both numbers are normalized using the channel's country so e.g. a
+// sender in national format matches the same number in international format, senders which aren't numbers never match.
+func CheckNotSelf(channel courier.Channel, urn urns.URN, sender string) error {
+	if urn.Scheme() != urns.TelScheme || !isPhoneNumber(sender) {
+		return nil
+	}
+
+	if NewTelURNForChannel(sender, channel).Path() == urn.Path() {
+		return fmt.Errorf("cannot send to self, destination %s is the channel's own number", urn.Path())
+	}
+	return nil
+}
+
+// isPhoneNumber returns whether the passed in string looks like a phone number, i.e. has no letters
+func isPhoneNumber(number string) bool {
+	return number != "" && strings.IndexFunc(number, unicode.IsLetter) < 0
+}
+
+// matchesDestinations returns whether the passed in destination matches the list or pattern in the passed in config,
+// nil if the channel doesn't have that config. Numbers in lists match with or without a leading +.
+func matchesDestinations(channel courier.Channel, key string, destination string) (*bool, error) {
+	matches := false
+
+	switch config := channel.ConfigForKey(key, nil).(type) {
+	case nil:
+		return nil, nil
+
+	case string:
+		pattern, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", config))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern for channel: %s", key, err)
+		}
+		matches = pattern.MatchString(destination)
+
+	case []string:
+		matches = containsDestination(config, destination)
+
+	case []interface{}:
+		numbers := make([]string, 0, len(config))
+		for _, number := range config {
+			if str, isStr := number.(string); isStr {
+				numbers = append(numbers, str)
+			}
+		}
+		matches = containsDestination(numbers, destination)
+
+	default:
+		return nil, fmt.Errorf("invalid %s for channel, must be a list or pattern", key)
+	}
+
+	return &matches, nil
+}
+
+func containsDestination(numbers []string, destination string) bool {
+	destination = strings.TrimPrefix(destination, "+")
+	for _, number := range numbers {
+		if strings.TrimPrefix(strings.TrimSpace(number), "+") == destination {
+			return true
+		}
+	}
+	return false
+}
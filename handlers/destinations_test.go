@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDestination(t *testing.T) {
+	tcs := []struct {
+		config map[string]interface{}
+		urn    urns.URN
+		err    string
+	}{
+		{map[string]interface{}{}, "tel:+250788383383", ""},
+		{map[string]interface{}{courier.ConfigAllowedDestinations: []interface{}{"+250788383383", "250788000000"}}, "tel:+250788383383", ""},
+		{map[string]interface{}{courier.ConfigAllowedDestinations: []interface{}{"+250788383383", "250788000000"}}, "tel:+250788000000", ""},
+		{map[string]interface{}{courier.ConfigAllowedDestinations: []string{"+250788383383"}}, "tel:+12065551212", "destination +12065551212 is not in the allowed destinations for this channel"},
+		{map[string]interface{}{courier.ConfigAllowedDestinations: `\+250788\d{6}`}, "tel:+250788383383", ""},
+		{map[string]interface{}{courier.ConfigAllowedDestinations: `\+250788\d{6}`}, "tel:+2507883833831", "destination +2507883833831 is not in the allowed destinations for this channel"},
+		{map[string]interface{}{courier.ConfigBlockedDestinations: []interface{}{"250788383383"}}, "tel:+250788383383", "destination +250788383383 is in the blocked destinations for this channel"},
+		{map[string]interface{}{courier.ConfigBlockedDestinations: `\+1.*`}, "tel:+12065551212", "destination +12065551212 is in the blocked destinations for this channel"},
+		{map[string]interface{}{courier.ConfigBlockedDestinations: `\+1.*`}, "tel:+250788383383", ""},
+		{map[string]interface{}{
+			courier.ConfigAllowedDestinations: `\+250.*`,
+			courier.ConfigBlockedDestinations: []interface{}{"+250788383383"},
+		}, "tel:+250788383383", "destination +250788383383 is in the blocked destinations for this channel"},
+		{map[string]interface{}{courier.ConfigAllowedDestinations: `(`}, "tel:+250788383383", "invalid allowed_destinations pattern for channel: error parsing regexp: missing closing ): `^(?:()$`"},
+		{map[string]interface{}{courier.ConfigBlockedDestinations: 12}, "tel:+250788383383", "invalid blocked_destinations for channel, must be a list or pattern"},
+	}
+
+	for _, tc := range tcs {
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", tc.config)
+		err := CheckDestination(channel, tc.urn)
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for %s", tc.urn)
+		} else {
+			assert.EqualError(t, err, tc.err, "error mismatch for %s", tc.urn)
+		}
+	}
+}
+
+func TestCheckNotSelf(t *testing.T) {
+	tcs := []struct {
+		sender string
+		urn    urns.URN
+		err    string
+	}{
+		{"+250788383383", "tel:+250788383383", "cannot send to self, destination +250788383383 is the channel's own number"},
+		{"250788383383", "tel:+250788383383", "cannot send to self, destination +250788383383 is the channel's own number"},
+		{"0788 383 383", "tel:+250788383383", "cannot send to self, destination +250788383383 is the channel's own number"},
+		{"+250788383383", "tel:+250788000000", ""},
+		{"2020", "tel:2020", "cannot send to self, destination 2020 is the channel's own number"},
+		{"2020", "tel:+250788383383", ""},
+		{"FightFuture", "tel:+250788383383", ""},
+		{"", "tel:+250788383383", ""},
+		{"+250788383383", "whatsapp:250788383383", ""},
+	}
+
+	for _, tc := range tcs {
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "RW", map[string]interface{}{})
+		err := CheckNotSelf(channel, tc.urn, tc.sender)
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for %s to %s", tc.sender, tc.urn)
+		} else {
+			assert.EqualError(t, err, tc.err, "error mismatch for %s to %s", tc.sender, tc.urn)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// the max age of messages in seconds, channels without one never expire their messages
+var flagMaxAge = IntFlag{Key: courier.ConfigMaxAge}
+
+// CheckExpired returns an error if the passed in message is older than the max_age configured on its channel, or nil
+// if it can still be sent. Handlers should fail expired messages without sending them, as a late message (e.g. an OTP
+// which sat in our queue during an outage) can be worse than none at all. Channels without a max age never expire
+// their messages.
+func CheckExpired(msg courier.Msg, now time.Time) error {
+	maxAge := time.Duration(flagMaxAge.Get(msg.Channel())) * time.Second
+	if maxAge <= 0 || msg.CreatedOn().IsZero() {
+		return nil
+	}
+
+	age := now.Sub(msg.CreatedOn())
+	if age > maxAge {
+		return fmt.Errorf("expired before send, message is %s old and max age is %s", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckExpired(t *testing.T) {
+	mb := courier.NewMockBackend()
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tcs := []struct {
+		maxAge    interface{}
+		createdOn time.Time
+		err       string
+	}{
+		{nil, now.Add(-time.Hour * 24), ""},
+		{600, time.Time{}, ""},
+		{600, now.Add(-time.Minute * 5), ""},
+		{600.0, now.Add(-time.Minute * 10), ""},
+		{600, now.Add(-time.Hour * 2), "expired before send, message is 2h0m0s old and max age is 10m0s"},
+		{90.0, now.Add(-time.Minute * 2), "expired before send, message is 2m0s old and max age is 1m30s"},
+
+		// max ages which aren't whole seconds are invalid and ignored
+		{90.5, now.Add(-time.Minute * 2), ""},
+	}
+
+	for _, tc := range tcs {
+		config := map[string]interface{}{}
+		if tc.maxAge != nil {
+			config[courier.ConfigMaxAge] = tc.maxAge
+		}
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", config)
+		msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), urns.URN("tel:+250788383383"), "Your code is 1234", false, nil)
+		msg.WithCreatedOn(tc.createdOn)
+
+		err := CheckExpired(msg, now)
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for max age %v", tc.maxAge)
+		} else if assert.Error(t, err) {
+			assert.Equal(t, tc.err, err.Error())
+		}
+	}
+}
@@ -47,7 +47,7 @@ var handleTestCases = []ChannelHandleTestCase{
 	{Label: "Failed No Params", URL: failedNoParams, Status: 400, Response: "field 'id' required"},
 	{Label: "Failed Valid", URL: failedValid, Status: 200, Response: `"status":"F"`},
 	{Label: "Invalid Status", URL: invalidStatus, Status: 404, Response: `page not found`},
-	{Label: "Sent Valid", URL: sentValid, Status: 200, Response: `"status":"S"`},
+	{Label: "Sent Valid", URL: sentValid, Status: 200, ResponseJSON: `{"message":"Status Update Accepted","data":{"statuses":[{"channel_uuid":"8eb23e93-5ecb-45ba-b726-3b064e0c56ab","status":"S","msg_id":12345}]}}`},
 	{Label: "Delivered Valid", URL: deliveredValid, Status: 200, Data: "nothing", Response: `"status":"D"`},
 	{Label: "Delivered Valid Post", URL: deliveredValidPost, Data: "id=12345", Status: 200, Response: `"status":"D"`},
 }
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/nyaruka/courier"
+)
+
+// ConfigFlag is a typed channel config value which handlers read with a default, and which can be validated
+type ConfigFlag interface {
+	// Validate returns an error if the flag is set on the passed in channel to a value it doesn't accept
+	Validate(channel courier.Channel) error
+}
+
+// ValidateFlags validates each of the passed in flags for the passed in channel, returning the first error
+func ValidateFlags(channel courier.Channel, flags ...ConfigFlag) error {
+	for _, flag := range flags {
+		err := flag.Validate(channel)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BoolFlag is a boolean channel config value, e.g. whether a channel sends binary messages
+type BoolFlag struct {
+	Key     string
+	Default bool
+}
+
+// Get returns the value of this flag on the passed in channel, or its default if it isn't set to a boolean
+func (f BoolFlag) Get(channel courier.Channel) bool {
+	value, isBool := channel.ConfigForKey(f.Key, f.Default).(bool)
+	if !isBool {
+		return f.Default
+	}
+	return value
+}
+
+// Validate returns an error if this flag is set on the passed in channel to something other than a boolean
+func (f BoolFlag) Validate(channel courier.Channel) error {
+	value := channel.ConfigForKey(f.Key, nil)
+	if _, isBool := value.(bool); value != nil && !isBool {
+		return fmt.Errorf("invalid %s set for %s channel: %v", f.Key, channel.ChannelType(), value)
+	}
+	return nil
+}
+
+// IntFlag is an integer channel config value, e.g. a limit. Numbers in channel configs decoded from JSON are floats so
+// those are accepted as long as they are whole. Values below Min are invalid, so Min should be set when zero isn't
+// a valid value, in which case it can be used as the default to mean the flag isn't set.
+type IntFlag struct {
+	Key     string
+	Default int
+	Min     int
+}
+
+// Get returns the value of this flag on the passed in channel, or its default if it isn't set to a valid integer
+func (f IntFlag) Get(channel courier.Channel) int {
+	value, valid := f.value(channel)
+	if !valid {
+		return f.Default
+	}
+	return value
+}
+
+// Validate returns an error if this flag is set on the passed in channel to something other than an integer of at
+// least our minimum
+func (f IntFlag) Validate(channel courier.Channel) error {
+	value := channel.ConfigForKey(f.Key, nil)
+	if _, valid := f.value(channel); value != nil && !valid {
+		return fmt.Errorf("invalid %s set for %s channel: %v", f.Key, channel.ChannelType(), value)
+	}
+	return nil
+}
+
+// value returns the value of this flag on the passed in channel, and whether it is set to a valid integer
+func (f IntFlag) value(channel courier.Channel) (int, bool) {
+	var value int
+	switch configured := channel.ConfigForKey(f.Key, nil).(type) {
+	case int:
+		value = configured
+	case float64:
+		if configured != float64(int(configured)) {
+			return 0, false
+		}
+		value = int(configured)
+	default:
+		return 0, false
+	}
+	return value, value >= f.Min
+}
+
+// StringFlag is a string channel config value, e.g. a mode. If Values is set, only those are valid.
+type StringFlag struct {
+	Key     string
+	Default string
+	Values  []string
+}
+
+// Get returns the value of this flag on the passed in channel, or its default if it isn't set to a valid string
+func (f StringFlag) Get(channel courier.Channel) string {
+	value, valid := f.value(channel)
+	if !valid {
+		return f.Default
+	}
+	return value
+}
+
+// Validate returns an error if this flag is set on the passed in channel to something other than one of our values
+func (f StringFlag) Validate(channel courier.Channel) error {
+	value := channel.ConfigForKey(f.Key, nil)
+	if _, valid := f.value(channel); value != nil && value != "" && !valid {
+		return fmt.Errorf("invalid %s set for %s channel: '%v'", f.Key, channel.ChannelType(), value)
+	}
+	return nil
+}
+
+// value returns the value of this flag on the passed in channel, and whether it is set to a valid string, empty
+// strings being the same as not set
+func (f StringFlag) value(channel courier.Channel) (string, bool) {
+	value, isString := channel.ConfigForKey(f.Key, nil).(string)
+	if !isString || value == "" {
+		return "", false
+	}
+	if len(f.Values) == 0 {
+		return value, true
+	}
+	for _, v := range f.Values {
+		if value == v {
+			return value, true
+		}
+	}
+	return "", false
+}
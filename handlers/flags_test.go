@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoolFlag(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil).(*courier.MockChannel)
+	flag := BoolFlag{Key: "binary"}
+	defaultOn := BoolFlag{Key: "binary", Default: true}
+
+	// unset flags have their default
+	assert.False(t, flag.Get(channel))
+	assert.True(t, defaultOn.Get(channel))
+	assert.NoError(t, flag.Validate(channel))
+
+	channel.SetConfig("binary", true)
+	assert.True(t, flag.Get(channel))
+	channel.SetConfig("binary", false)
+	assert.False(t, defaultOn.Get(channel))
+	assert.NoError(t, flag.Validate(channel))
+
+	// anything other than a boolean is invalid, and read as the default
+	channel.SetConfig("binary", "true")
+	assert.False(t, flag.Get(channel))
+	assert.True(t, defaultOn.Get(channel))
+	assert.EqualError(t, flag.Validate(channel), "invalid binary set for IB channel: true")
+}
+
+func TestIntFlag(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil).(*courier.MockChannel)
+	flag := IntFlag{Key: "max_segments", Min: 1}
+
+	assert.Equal(t, 0, flag.Get(channel))
+	assert.NoError(t, flag.Validate(channel))
+
+	// ints and whole floats, as decoded from JSON, are accepted
+	channel.SetConfig("max_segments", 3)
+	assert.Equal(t, 3, flag.Get(channel))
+	assert.NoError(t, flag.Validate(channel))
+	channel.SetConfig("max_segments", float64(4))
+	assert.Equal(t, 4, flag.Get(channel))
+	assert.NoError(t, flag.Validate(channel))
+
+	// anything else, or values below our minimum, are invalid and read as the default
+	tcs := []interface{}{float64(0), -1, 2.5, "3"}
+	for _, value := range tcs {
+		channel.SetConfig("max_segments", value)
+		assert.Equal(t, 0, flag.Get(channel), "unexpected value for %v", value)
+		assert.Error(t, flag.Validate(channel), "expected error for %v", value)
+	}
+	assert.EqualError(t, flag.Validate(channel), "invalid max_segments set for IB channel: 3")
+}
+
+func TestStringFlag(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil).(*courier.MockChannel)
+	flag := StringFlag{Key: "long_sender", Default: "send", Values: []string{"reject", "truncate"}}
+	anything := StringFlag{Key: "long_sender"}
+
+	assert.Equal(t, "send", flag.Get(channel))
+	assert.NoError(t, ValidateFlags(channel, flag, anything))
+
+	// empty strings are the same as unset
+	channel.SetConfig("long_sender", "")
+	assert.Equal(t, "send", flag.Get(channel))
+	assert.NoError(t, ValidateFlags(channel, flag, anything))
+
+	channel.SetConfig("long_sender", "reject")
+	assert.Equal(t, "reject", flag.Get(channel))
+	assert.NoError(t, ValidateFlags(channel, flag, anything))
+
+	// only our values are valid when we have them
+	channel.SetConfig("long_sender", "drop")
+	assert.Equal(t, "send", flag.Get(channel))
+	assert.Equal(t, "drop", anything.Get(channel))
+	assert.EqualError(t, ValidateFlags(channel, anything, flag), "invalid long_sender set for IB channel: 'drop'")
+
+	channel.SetConfig("long_sender", 5)
+	assert.Equal(t, "send", flag.Get(channel))
+	assert.EqualError(t, flag.Validate(channel), "invalid long_sender set for IB channel: '5'")
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"unicode/utf16"
+
+	"github.com/nyaruka/courier/gsm7"
+)
+
+// characters outside of GSM7 which gsm7.ReplaceNonGSM7Chars leaves alone but which have a close enough equivalent
+var gsm7Transliterations = map[rune]string{
+	'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e", 'ï': "i", 'ī': "i", 'ı': "i", 'ý': "y", 'ÿ': "y", 'ā': "a", 'ą': "a",
+	'ć': "c", 'č': "c", 'ď': "d", 'ğ': "g", 'ł': "l", 'ń': "n", 'ň': "n", 'ő': "o", 'ō': "o", 'ř': "r", 'ś': "s",
+	'š': "s", 'ş': "s", 'ť': "t", 'ű': "u", 'ū': "u", 'ů': "u", 'ź': "z", 'ż': "z", 'ž': "z",
+
+	'Ë': "E", 'Ę': "E", 'Ě': "E", 'Ï': "I", 'İ': "I", 'Ý': "Y", 'Ą': "A", 'Ć': "C", 'Č': "C", 'Ď': "D", 'Ğ': "G",
+	'Ł': "L", 'Ń': "N", 'Ň': "N", 'Ő': "O", 'Ř': "R", 'Ś': "S", 'Š': "S", 'Ş': "S", 'Ť': "T", 'Ű': "U", 'Ů': "U",
+	'Ź': "Z", 'Ż': "Z", 'Ž': "Z",
+
+	'…': "...", '—': "-", '«': "\"", '»': "\"", '„': "\"",
+}
+
+// TransliterateToGSM7 returns the passed in text with every character outside of GSM7 replaced by its closest GSM7
+// equivalent, characters without one (e.g. emoji) are replaced with a ?. Channels which want their messages to always
+// be billed as GSM7 segments can use this rather than relying on their provider's transliteration.
+func TransliterateToGSM7(text string) string {
+	text = gsm7.ReplaceNonGSM7Chars(text)
+	if gsm7.IsGSM7(text) {
+		return text
+	}
+
+	output := bytes.Buffer{}
+	for _, r := range text {
+		if gsm7.IsGSM7(string(r)) {
+			output.WriteRune(r)
+		} else if replacement, found := gsm7Transliterations[r]; found {
+			output.WriteString(replacement)
+		} else {
+			output.WriteRune('?')
+		}
+	}
+	return output.String()
+}
+
+// the GSM 03.38 default alphabet, each character's index is its septet value
+const gsm7Alphabet = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// the GSM 03.38 extension table, these characters are sent as an escape followed by their septet value
+var gsm7Extension = map[rune]byte{
+	'\f': 0x0A, '^': 0x14, '{': 0x28, '}': 0x29, '\\': 0x2F, '[': 0x3C, '~': 0x3D, ']': 0x3E, '|': 0x40, '€': 0x65,
+}
+
+var gsm7Septets = make(map[rune]byte)
+
+func init() {
+	for i, r := range []rune(gsm7Alphabet) {
+		gsm7Septets[r] = byte(i)
+	}
+}
+
+// EncodeGSM7 returns the passed in text as unpacked GSM 03.38 septets, one per byte, for providers which take binary
+// content with a GSM7 data coding scheme. The text is transliterated first so anything without an equivalent is sent
+// as a ?.
+func EncodeGSM7(text string) []byte {
+	encoded := make([]byte, 0, len(text))
+	for _, r := range TransliterateToGSM7(text) {
+		if septet, found := gsm7Septets[r]; found {
+			encoded = append(encoded, septet)
+		} else if septet, found := gsm7Extension[r]; found {
+			encoded = append(encoded, 0x1B, septet)
+		} else {
+			encoded = append(encoded, gsm7Septets['?'])
+		}
+	}
+	return encoded
+}
+
+const (
+	// EncodingGSM7 is the encoding of text made up only of GSM 03.38 characters, each taking one or two septets
+	EncodingGSM7 = "GSM7"
+
+	// EncodingUCS2 is the encoding of any other text, each character taking one or two UTF-16 code units
+	EncodingUCS2 = "UCS2"
+)
+
+// how many units fit in a single message, and in each part of a concatenated one once its header is added
+const (
+	gsm7SingleSegment = 160
+	gsm7MultiSegment  = 153
+	ucs2SingleSegment = 70
+	ucs2MultiSegment  = 67
+)
+
+// CountSegments returns the encoding the passed in text will be sent with and how many SMS segments it will take.
+// Extension characters take two septets and characters outside the basic multilingual plane two code units, neither
+// of which are split across segments. Empty text still takes a single segment.
+func CountSegments(text string) (string, int) {
+	encoding, single, multi := EncodingGSM7, gsm7SingleSegment, gsm7MultiSegment
+	units := make([]int, 0, len(text))
+	for _, r := range text {
+		if _, found := gsm7Septets[r]; found {
+			units = append(units, 1)
+		} else if _, found := gsm7Extension[r]; found {
+			units = append(units, 2)
+		} else {
+			encoding, single, multi = EncodingUCS2, ucs2SingleSegment, ucs2MultiSegment
+			break
+		}
+	}
+
+	if encoding == EncodingUCS2 {
+		units = units[:0]
+		for _, r := range text {
+			units = append(units, len(utf16.Encode([]rune{r})))
+		}
+	}
+
+	total := 0
+	for _, u := range units {
+		total += u
+	}
+	if total <= single {
+		return encoding, 1
+	}
+
+	segments, used := 1, 0
+	for _, u := range units {
+		if used+u > multi {
+			segments++
+			used = 0
+		}
+		used += u
+	}
+	return encoding, segments
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransliterateToGSM7(t *testing.T) {
+	tcs := []struct {
+		text   string
+		output string
+	}{
+		{"Hello World", "Hello World"},
+		{"Café crème à Noël", "Café crème à Noel"},
+		{"Łódź … Škoda", "Lodz ... Skoda"},
+		{"“Quoted” – text", "\"Quoted\" - text"},
+		{"Thanks 👍🏽", "Thanks ??"},
+		{"你好", "??"},
+	}
+
+	for _, tc := range tcs {
+		output := TransliterateToGSM7(tc.text)
+		assert.Equal(t, tc.output, output, "transliteration mismatch for %s", tc.text)
+		assert.True(t, gsm7.IsGSM7(output), "output not GSM7 for %s", tc.text)
+	}
+}
+
+func TestEncodeGSM7(t *testing.T) {
+	tcs := []struct {
+		text    string
+		encoded []byte
+	}{
+		{"Hi!", []byte{0x48, 0x69, 0x21}},
+		{"@£$", []byte{0x00, 0x01, 0x02}},
+		{"ñ à", []byte{0x7D, 0x20, 0x7F}},
+		{"{€}", []byte{0x1B, 0x28, 0x1B, 0x65, 0x1B, 0x29}},
+		{"Łódź 👍", []byte{0x4C, 0x6F, 0x64, 0x7A, 0x20, 0x3F}},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.encoded, EncodeGSM7(tc.text), "encoding mismatch for %s", tc.text)
+	}
+}
+
+func TestCountSegments(t *testing.T) {
+	tcs := []struct {
+		text     string
+		encoding string
+		segments int
+	}{
+		{"", EncodingGSM7, 1},
+		{"Hi there", EncodingGSM7, 1},
+		{strings.Repeat("a", 160), EncodingGSM7, 1},
+		{strings.Repeat("a", 161), EncodingGSM7, 2},
+		{strings.Repeat("a", 306), EncodingGSM7, 2},
+		{strings.Repeat("a", 307), EncodingGSM7, 3},
+		{strings.Repeat("€", 80), EncodingGSM7, 1},
+		{strings.Repeat("€", 81), EncodingGSM7, 2},
+		{strings.Repeat("a", 152) + "€", EncodingGSM7, 1},
+		{strings.Repeat("a", 152) + "€" + strings.Repeat("a", 8), EncodingGSM7, 2},
+		{strings.Repeat("a", 152) + "€" + strings.Repeat("a", 152), EncodingGSM7, 3},
+		{"Łódź", EncodingUCS2, 1},
+		{strings.Repeat("ł", 70), EncodingUCS2, 1},
+		{strings.Repeat("ł", 71), EncodingUCS2, 2},
+		{strings.Repeat("ł", 134), EncodingUCS2, 2},
+		{strings.Repeat("ł", 135), EncodingUCS2, 3},
+		{strings.Repeat("👍", 35), EncodingUCS2, 1},
+		{strings.Repeat("👍", 36), EncodingUCS2, 2},
+		{strings.Repeat("a", 66) + "👍" + strings.Repeat("a", 4), EncodingUCS2, 2},
+		{strings.Repeat("a", 66) + "👍" + strings.Repeat("a", 67), EncodingUCS2, 3},
+	}
+
+	for _, tc := range tcs {
+		encoding, segments := CountSegments(tc.text)
+		assert.Equal(t, tc.encoding, encoding, "encoding mismatch for %s", tc.text)
+		assert.Equal(t, tc.segments, segments, "segment count mismatch for %s", tc.text)
+	}
+}
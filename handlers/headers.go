@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/nyaruka/courier"
+)
+
+// SetChannelHeaders adds the http_headers configured on the passed in channel to the passed in request. Handlers should
+// call this before setting their provider's authorization as configured headers aren't allowed to replace it.
+func SetChannelHeaders(req *http.Request, channel courier.Channel) {
+	headers, _ := channel.ConfigForKey(courier.ConfigHTTPHeaders, nil).(map[string]interface{})
+	for name, value := range headers {
+		if http.CanonicalHeaderKey(name) == "Authorization" || value == nil {
+			continue
+		}
+		req.Header.Set(name, fmt.Sprint(value))
+	}
+}
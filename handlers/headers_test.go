@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetChannelHeaders(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{
+		courier.ConfigHTTPHeaders: map[string]interface{}{
+			"User-Agent":    "Acme/1.0",
+			"x-account-id":  1234,
+			"authorization": "Bearer sneaky",
+			"X-Empty":       nil,
+		},
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/send", nil)
+	req.Header.Set("Authorization", "Basic abc")
+	SetChannelHeaders(req, channel)
+
+	assert.Equal(t, "Acme/1.0", req.Header.Get("User-Agent"))
+	assert.Equal(t, "1234", req.Header.Get("X-Account-Id"))
+	assert.Equal(t, "Basic abc", req.Header.Get("Authorization"))
+	assert.Equal(t, "", req.Header.Get("X-Empty"))
+
+	// channels without headers leave requests alone
+	req, _ = http.NewRequest(http.MethodPost, "https://example.com/send", nil)
+	SetChannelHeaders(req, courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil))
+	assert.Equal(t, 0, len(req.Header))
+}
@@ -0,0 +1,358 @@
+package infobip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/pkg/errors"
+)
+
+// Large broadcasts are too slow to send with a request per message, so SMS can also be submitted to Infobip in bulk,
+// many messages to a request. Each message is sent with its own id, which Infobip echoes back in the results for each
+// destination, so we can give every message its own status. Messages which need something our bulk requests can't
+// carry, e.g. OTP and omnichannel messages or extra params, are sent one by one as usual.
+
+// the most messages we submit to Infobip in a single request
+const bulkMaxMessages = 100
+
+// bulkMsg is a message we are submitting in bulk, along with where its status goes
+type bulkMsg struct {
+	index   int
+	msg     courier.Msg
+	message *ibOutgoingMessage
+}
+
+// SendMsgs sends the passed in messages, submitting SMS on the same channel together, and returns a status for each
+// in the same order
+func (h *handler) SendMsgs(ctx context.Context, msgs []courier.Msg) []courier.MsgStatus {
+	statuses := make([]courier.MsgStatus, len(msgs))
+
+	// our messages are grouped by the channel and endpoint they are submitted to
+	batches := make(map[string][]*bulkMsg)
+	keys := make([]string, 0)
+
+	for i, msg := range msgs {
+		if !bulkSendable(msg) {
+			statuses[i] = h.sendOne(ctx, msg)
+			continue
+		}
+
+		statuses[i] = h.checkSend(msg)
+		if statuses[i] != nil {
+			continue
+		}
+
+		message, failed, err := h.newBulkMessage(msg)
+		if err != nil {
+			statuses[i] = h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+			statuses[i].AddLog(courier.NewChannelLog("Message Send Error", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+				"", "", 0, err))
+			continue
+		}
+		if failed != nil {
+			statuses[i] = failed
+			continue
+		}
+
+		mode := sendModeText
+		if message.Binary != nil {
+			mode = sendModeBinary
+		}
+		key := fmt.Sprintf("%s:%s", msg.Channel().UUID(), mode)
+		if _, found := batches[key]; !found {
+			keys = append(keys, key)
+		}
+		batches[key] = append(batches[key], &bulkMsg{index: i, msg: msg, message: message})
+	}
+
+	for _, key := range keys {
+		batch := batches[key]
+		for start := 0; start < len(batch); start += bulkMaxMessages {
+			end := start + bulkMaxMessages
+			if end > len(batch) {
+				end = len(batch)
+			}
+
+			// messages sent one by one have already been recorded by SendMsg, and those we never sent have no outcome
+			for i, status := range h.sendBulk(ctx, batch[start:end]) {
+				b := batch[start+i]
+				statuses[b.index] = status
+				h.RecordSend(b.msg.Channel(), status)
+				h.schedulePoll(b.msg, status)
+			}
+		}
+	}
+	return statuses
+}
+
+// bulkSendable returns whether the passed in message can be submitted in bulk
+func bulkSendable(msg courier.Msg) bool {
+	if flagChannel.Get(msg.Channel()) != channelSMS {
+		return false
+	}
+	extraParams, _ := msg.Channel().ConfigForKey(configExtraParams, nil).(map[string]interface{})
+	return len(extraParams) == 0
+}
+
+// sendOne sends the passed in message by itself, errors becoming its status as they would for any other send
+func (h *handler) sendOne(ctx context.Context, msg courier.Msg) courier.MsgStatus {
+	status, err := h.SendMsg(ctx, msg)
+	if err != nil {
+		if status == nil {
+			status = h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+		}
+		status.AddLog(courier.NewChannelLog("Message Send Error", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+	}
+	return status
+}
+
+// newBulkMessage builds the SMS we submit for the passed in message, or the failed status it gets if it can't be sent
+func (h *handler) newBulkMessage(msg courier.Msg) (*ibOutgoingMessage, courier.MsgStatus, error) {
+	err := checkCredentials(msg.Channel())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	text, err := outgoingText(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callbackDomain := h.callbackDomains.Select(msg.Channel(), h.Server().Config().Domain)
+	message, failed, err := h.newSMSMessage(msg, text, callbackDomain, newCorrelationID())
+	if err != nil || failed != nil {
+		return nil, failed, err
+	}
+
+	// a channel messaging its own number can loop forever, so fail these without sending
+	err = handlers.CheckNotSelf(msg.Channel(), msg.URN(), message.From)
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+		status.AddLog(courier.NewChannelLog("Send To Self", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return nil, status, nil
+	}
+	return message, nil, nil
+}
+
+// sendBulk submits the passed in messages, which are all on the same channel and sent to the same endpoint, in a
+// single request, returning their statuses in the same order
+func (h *handler) sendBulk(ctx context.Context, batch []*bulkMsg) []courier.MsgStatus {
+	channel := batch[0].msg.Channel()
+	mode := sendModeText
+	if batch[0].message.Binary != nil {
+		mode = sendModeBinary
+	}
+
+	envelope := ibOutgoingEnvelope{Messages: make([]ibOutgoingMessage, len(batch))}
+	statuses := make([]courier.MsgStatus, len(batch))
+	for i, b := range batch {
+		envelope.Messages[i] = *b.message
+		statuses[i] = h.Backend().NewMsgStatusForID(channel, b.msg.ID(), courier.MsgErrored)
+		statuses[i].SetCorrelationID(b.message.CallbackData)
+		statuses[i].SetCampaignReference(b.message.CampaignReference)
+	}
+
+	// addLogs gives every status its own copy of a log, as each is written against its own message
+	addLogs := func(newLog func(b *bulkMsg) *courier.ChannelLog) {
+		for i, b := range batch {
+			statuses[i].AddLog(newLog(b).WithCorrelationID(b.message.CallbackData))
+		}
+	}
+
+	requestBody := &bytes.Buffer{}
+	err := json.NewEncoder(requestBody).Encode(envelope)
+	if err != nil {
+		addLogs(func(b *bulkMsg) *courier.ChannelLog {
+			return courier.NewChannelLog("Message Send Error", channel, b.msg.ID(), "", "", courier.NilStatusCode, "", "", 0, err)
+		})
+		return statuses
+	}
+
+	// channels using OAuth need a current access token, without one we can't send for now
+	authorization, err := h.authorization(ctx, channel)
+	if err != nil {
+		addLogs(func(b *bulkMsg) *courier.ChannelLog {
+			return courier.NewChannelLog("Access Token Error", channel, b.msg.ID(), "", "", courier.NilStatusCode, "", "", 0, err)
+		})
+		return statuses
+	}
+
+	postURL := h.sendURL(channel, mode)
+	rr, err := handlers.MakeSendRequest(ctx, channel, h.sendOptions(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, postURL, bytes.NewReader(requestBody.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		setAuthorization(req, channel)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return req, nil
+	})
+
+	h.DebugHTTP(channel, rr, configClientSecret)
+
+	// a token Infobip no longer accepts, e.g. because it was revoked, is replaced on our next send
+	if authorization != "" && rr != nil && rr.StatusCode == http.StatusUnauthorized {
+		h.tokens.Forget(channel)
+	}
+
+	// without a response we couldn't even build our request, so there's nothing to log but our error
+	if rr == nil {
+		addLogs(func(b *bulkMsg) *courier.ChannelLog {
+			return courier.NewChannelLog("Message Send Error", channel, b.msg.ID(), "", "", courier.NilStatusCode, "", "", 0, err)
+		})
+		return statuses
+	}
+
+	for _, attempt := range rr.Retried {
+		addLogs(func(b *bulkMsg) *courier.ChannelLog {
+			return courier.NewChannelLogFromRR("Message Send Retried", channel, b.msg.ID(), attempt).WithError("Message Send Retried", attemptError(attempt))
+		})
+	}
+
+	bulkID := ""
+	if err == nil {
+		bulkID, _ = jsonparser.GetString(rr.Body, "bulkId")
+	}
+	logs := make([]*courier.ChannelLog, len(batch))
+	for i, b := range batch {
+		description := sentDescription(b.msg, b.message.From, len(batch), bulkID)
+		logs[i] = courier.NewChannelLogFromRR(description, channel, b.msg.ID(), rr).WithCorrelationID(b.message.CallbackData)
+		statuses[i].AddLog(logs[i])
+	}
+
+	// errors with our request as a whole are errors for all of our messages
+	failAll := func(status courier.MsgStatusValue, err error) []courier.MsgStatus {
+		for i := range batch {
+			logs[i].WithError("Message Send Error", err)
+			statuses[i].SetStatus(status)
+		}
+		return statuses
+	}
+
+	if err != nil {
+		if nonJSONErr := nonJSONResponse(rr); nonJSONErr != nil && err != handlers.ErrSendDeadlineExceeded {
+			err = nonJSONErr
+		}
+		status := courier.MsgErrored
+		if err != handlers.ErrSendDeadlineExceeded {
+			status = handlers.StatusForRequestError(channel, rr)
+		}
+		if rr.RetryAfter > 0 {
+			for _, s := range statuses {
+				s.SetRetryAfter(rr.RetryAfter)
+			}
+		}
+		return failAll(status, err)
+	}
+	if nonJSONErr := nonJSONResponse(rr); nonJSONErr != nil {
+		return failAll(courier.MsgErrored, nonJSONErr)
+	}
+	if rr.BodyTruncated {
+		return failAll(courier.MsgErrored, errors.Errorf("response body longer than %d bytes", sendMaxBodyBytes))
+	}
+	exceptionID, exceptionText, found := serviceException([]byte(rr.Body))
+	if found {
+		return failAll(courier.MsgFailed, errors.Errorf("received service exception %s: %s", exceptionID, exceptionText))
+	}
+
+	response := &ibBulkResponse{}
+	err = json.Unmarshal(rr.Body, response)
+	if err != nil {
+		return failAll(courier.MsgErrored, errors.Errorf("unable to parse bulk response: %s", err))
+	}
+
+	// results are for the ids we sent with, messages without one, or whose id Infobip reassigned, are matched to the
+	// result in the same position as long as it isn't for another of our messages
+	results := make(map[string]*ibBulkResult, len(response.Messages))
+	for i := range response.Messages {
+		results[response.Messages[i].MessageID] = &response.Messages[i]
+	}
+	sentIDs := make(map[string]bool, len(batch))
+	for _, b := range batch {
+		sentIDs[b.message.Destinations[0].MessageID] = true
+	}
+
+	groupIDs := successGroupIDs(channel)
+	for i, b := range batch {
+		var result *ibBulkResult
+		messageID := b.message.Destinations[0].MessageID
+		if messageID != "" {
+			result = results[messageID]
+		}
+		if result == nil && i < len(response.Messages) && !sentIDs[response.Messages[i].MessageID] {
+			result = &response.Messages[i]
+		}
+
+		if bulkID != "" {
+			statuses[i].SetMetadata("bulk_id", bulkID)
+		}
+
+		if result == nil {
+			logs[i].WithError("Message Send Error", errors.New("no result for message in bulk response"))
+			continue
+		}
+		externalID := externalIDForMessage(b.msg, messageID, result.MessageID, logs[i])
+		if externalID != "" {
+			statuses[i].SetExternalID(externalID)
+		}
+
+		// destinations on Infobip's blacklist or a do not disturb register will never be delivered to
+		if isBlacklisted(result.Status.ID, result.Status.Name) {
+			logs[i].WithError("Destination Blacklisted", errors.Errorf("destination rejected by Infobip as blacklisted: %s", result.Status.Name))
+			statuses[i].SetStatus(courier.MsgFailed)
+			continue
+		}
+
+		if !groupIDs[result.Status.GroupID] {
+			logs[i].WithError("Message Send Error", errors.Errorf("received error status: '%d'", result.Status.GroupID))
+			continue
+		}
+
+		statuses[i].SetStatus(courier.MsgWired)
+	}
+	return statuses
+}
+
+// {
+// 	"bulkId": "2034072219640523072",
+// 	"messages": [
+// 	  {
+// 		"to": "41793026727",
+// 		"status": {
+// 		  "groupId": 1,
+// 		  "groupName": "PENDING",
+// 		  "id": 26,
+// 		  "name": "MESSAGE_ACCEPTED",
+// 		  "description": "Message sent to next instance"
+// 		},
+// 		"messageId": "10"
+// 	  }
+// 	]
+// }
+type ibBulkResponse struct {
+	BulkID   string         `json:"bulkId"`
+	Messages []ibBulkResult `json:"messages"`
+}
+
+type ibBulkResult struct {
+	To        string `json:"to"`
+	MessageID string `json:"messageId"`
+	Status    struct {
+		GroupID   int64  `json:"groupId"`
+		GroupName string `json:"groupName"`
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+	} `json:"status"`
+}
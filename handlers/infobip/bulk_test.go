@@ -0,0 +1,255 @@
+package infobip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+// newBulkChannel returns a channel which sends to the passed in URL
+func newBulkChannel(uuid string, url string) *courier.MockChannel {
+	return courier.NewMockChannel(uuid, "IB", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigPassword: "Password",
+			courier.ConfigUsername: "Username",
+			courier.ConfigBaseURL:  url,
+		}).(*courier.MockChannel)
+}
+
+// newBulkMsgs returns count messages on the passed in channel, with ids starting at firstID
+func newBulkMsgs(mb *courier.MockBackend, channel courier.Channel, firstID int64, count int) []courier.Msg {
+	msgs := make([]courier.Msg, count)
+	for i := range msgs {
+		urn := urns.URN(fmt.Sprintf("tel:+2507883%05d", i))
+		msgs[i] = mb.NewOutgoingMsg(channel, courier.NewMsgID(firstID+int64(i)), urn, "Broadcast", false, nil)
+	}
+	return msgs
+}
+
+func TestSendMsgs(t *testing.T) {
+	// our server accepts every message it is sent, except those to a blacklisted or unknown number
+	var mutex sync.Mutex
+	var batches []ibOutgoingEnvelope
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		envelope := ibOutgoingEnvelope{}
+		json.NewDecoder(r.Body).Decode(&envelope)
+
+		mutex.Lock()
+		batches = append(batches, envelope)
+		mutex.Unlock()
+
+		// results are returned in the reverse order to the messages
+		results := make([]map[string]interface{}, 0, len(envelope.Messages))
+		for i := len(envelope.Messages) - 1; i >= 0; i-- {
+			message := envelope.Messages[i]
+			status := map[string]interface{}{"groupId": 1, "groupName": "PENDING", "id": 26, "name": "MESSAGE_ACCEPTED"}
+			switch message.Destinations[0].To {
+			case "250788300001":
+				status = map[string]interface{}{"groupId": 5, "groupName": "REJECTED", "id": 87, "name": "REJECTED_DESTINATION_BLACKLISTED"}
+			case "250788300002":
+				status = map[string]interface{}{"groupId": 5, "groupName": "REJECTED", "id": 51, "name": "MISSING_TO"}
+			}
+			results = append(results, map[string]interface{}{
+				"to":        message.Destinations[0].To,
+				"messageId": message.Destinations[0].MessageID,
+				"status":    status,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"bulkId": fmt.Sprintf("BULK%d", len(batches)), "messages": results})
+	}))
+	defer server.Close()
+
+	channel := newBulkChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", server.URL)
+	other := newBulkChannel("a5a3a0a3-0f1d-4b63-8e7a-9d0c0b9bd8f2", server.URL)
+
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	mb.AddChannel(other)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// each message gets the status of its own result, whatever order they come back in
+	msgs := newBulkMsgs(mb, channel, 10, 4)
+	statuses := h.SendMsgs(context.Background(), msgs)
+	assert.Equal(t, 1, len(batches))
+	assert.Equal(t, 4, len(batches[0].Messages))
+	assert.Equal(t, 4, len(statuses))
+
+	for i, status := range statuses {
+		assert.Equal(t, msgs[i].ID(), status.ID())
+		assert.Equal(t, msgs[i].ID().String(), status.ExternalID())
+		assert.JSONEq(t, `{"bulk_id": "BULK1"}`, string(status.Metadata()))
+		assert.Equal(t, batches[0].Messages[i].CallbackData, status.CorrelationID())
+	}
+	assert.Equal(t, courier.MsgWired, statuses[0].Status())
+	assert.Equal(t, "Message Sent in Bulk of 4 [bulk BULK1]", statuses[0].Logs()[0].Description)
+	assert.Equal(t, courier.MsgFailed, statuses[1].Status())
+	assert.Equal(t, "Destination Blacklisted", statuses[1].Logs()[0].Description)
+	assert.Equal(t, courier.MsgErrored, statuses[2].Status())
+	assert.Equal(t, "received error status: '5'", statuses[2].Logs()[0].Error)
+	assert.Equal(t, courier.MsgWired, statuses[3].Status())
+
+	// large broadcasts are chunked, with messages on different channels submitted separately
+	batches = nil
+	msgs = append(newBulkMsgs(mb, channel, 100, 250), newBulkMsgs(mb, other, 1000, 3)...)
+	statuses = h.SendMsgs(context.Background(), msgs)
+	assert.Equal(t, 4, len(batches))
+	assert.Equal(t, 100, len(batches[0].Messages))
+	assert.Equal(t, 100, len(batches[1].Messages))
+	assert.Equal(t, 50, len(batches[2].Messages))
+	assert.Equal(t, 3, len(batches[3].Messages))
+	assert.Equal(t, "1000", batches[3].Messages[0].Destinations[0].MessageID)
+
+	for i, status := range statuses {
+		assert.Equal(t, msgs[i].ID(), status.ID())
+	}
+	assert.Equal(t, courier.MsgWired, statuses[0].Status())
+	assert.Equal(t, courier.MsgFailed, statuses[1].Status())
+	assert.Equal(t, courier.MsgWired, statuses[249].Status())
+	assert.Equal(t, courier.MsgWired, statuses[250].Status())
+	assert.Equal(t, "1000", statuses[250].ExternalID())
+	assert.JSONEq(t, `{"bulk_id": "BULK4"}`, string(statuses[250].Metadata()))
+}
+
+func TestSendMsgsErrors(t *testing.T) {
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/sms/1/text/advanced": MockResponse{Status: 500, Body: `{"error":"down"}`},
+	})
+	defer server.Close()
+
+	channel := newBulkChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", server.URL)
+	channel.SetConfig("max_segments", float64(1))
+
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// failed requests error all their messages, messages which can't be sent fail without being submitted
+	msgs := newBulkMsgs(mb, channel, 10, 2)
+	msgs = append(msgs, mb.NewOutgoingMsg(channel, courier.NewMsgID(12), urns.URN("tel:+250788383383"), strings.Repeat("a", 200), false, nil))
+	statuses := h.SendMsgs(context.Background(), msgs)
+	assert.Equal(t, 1, len(server.Requests()))
+	assert.Equal(t, courier.MsgErrored, statuses[0].Status())
+	assert.Equal(t, courier.MsgErrored, statuses[1].Status())
+	assert.Equal(t, "Message Send Error", statuses[1].Logs()[0].Description)
+	assert.Equal(t, courier.MsgFailed, statuses[2].Status())
+	assert.Equal(t, "Message Too Long", statuses[2].Logs()[0].Description)
+
+	// results missing from the response error their messages
+	server.SetResponse("/sms/1/text/advanced", MockResponse{Status: 200, Body: `{"bulkId":"BULK1","messages":[{"messageId":"10","status":{"groupId":1}}]}`})
+	statuses = h.SendMsgs(context.Background(), msgs[:2])
+	assert.Equal(t, courier.MsgWired, statuses[0].Status())
+	assert.Equal(t, courier.MsgErrored, statuses[1].Status())
+	assert.Equal(t, "no result for message in bulk response", statuses[1].Logs()[0].Error)
+
+	// service exceptions fail them all
+	server.SetResponse("/sms/1/text/advanced", MockResponse{Status: 200, Body: `{"requestError":{"serviceException":{"messageId":"BAD_REQUEST","text":"Bad request"}}}`})
+	statuses = h.SendMsgs(context.Background(), msgs[:2])
+	assert.Equal(t, courier.MsgFailed, statuses[0].Status())
+	assert.Equal(t, courier.MsgFailed, statuses[1].Status())
+	assert.Equal(t, "received service exception BAD_REQUEST: Bad request", statuses[1].Logs()[0].Error)
+}
+
+func TestSendMsgsReassignedIDs(t *testing.T) {
+	// the ids of our first two messages are reassigned, the third keeps its own but comes back first
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/sms/1/text/advanced": MockResponse{Status: 200, Body: `{"bulkId":"BULK1","messages":[` +
+			`{"messageId":"12","status":{"groupId":1}},` +
+			`{"messageId":"ib-b","status":{"groupId":1}},` +
+			`{"messageId":"ib-c","status":{"groupId":5}}]}`},
+	})
+	defer server.Close()
+
+	channel := newBulkChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", server.URL)
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// messages whose ids were reassigned are matched to the result in their position, unless it is another's
+	statuses := h.SendMsgs(context.Background(), newBulkMsgs(mb, channel, 10, 3))
+	assert.Equal(t, courier.MsgErrored, statuses[0].Status())
+	assert.Equal(t, "no result for message in bulk response", statuses[0].Logs()[0].Error)
+	assert.Equal(t, courier.MsgWired, statuses[1].Status())
+	assert.Equal(t, "ib-b", statuses[1].ExternalID())
+	assert.Equal(t, "Message Sent in Bulk of 3 [bulk BULK1] [message id 11 reassigned to ib-b]", statuses[1].Logs()[0].Description)
+	assert.Equal(t, courier.MsgWired, statuses[2].Status())
+	assert.Equal(t, "12", statuses[2].ExternalID())
+
+	// channels ignoring reassigned ids keep knowing their messages by ours
+	channel.SetConfig(configReassignedIDs, reassignedIDsIgnore)
+	statuses = h.SendMsgs(context.Background(), newBulkMsgs(mb, channel, 10, 3))
+	assert.Equal(t, "11", statuses[1].ExternalID())
+}
+
+func TestSendMsgsLogDescriptions(t *testing.T) {
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/sms/1/text/advanced": MockResponse{Status: 200, Body: `{"bulkId":"BULK1","messages":[` +
+			`{"messageId":"10","status":{"groupId":1}},` +
+			`{"messageId":"11","status":{"groupId":1}}]}`},
+	})
+	defer server.Close()
+
+	channel := newBulkChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", server.URL)
+	channel.SetConfig(configSenderPool, []interface{}{"2021"})
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// messages sent in bulk note their sender and priority in their logs as those sent by themselves do
+	msgs := newBulkMsgs(mb, channel, 10, 2)
+	msgs[1].WithPriority(courier.MsgPriorityHigh)
+	statuses := h.SendMsgs(context.Background(), msgs)
+	assert.Equal(t, "Message Sent in Bulk of 2 from 2021 [bulk BULK1]", statuses[0].Logs()[0].Description)
+	assert.Equal(t, "Message Sent in Bulk of 2 from 2021 (high priority) [bulk BULK1]", statuses[1].Logs()[0].Description)
+}
+
+func TestSendMsgsRecordsOnce(t *testing.T) {
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/sms/1/text/advanced": MockResponse{Status: 500, Body: `{"error":"down"}`},
+		"/omni/1/advanced":     MockResponse{Status: 500, Body: `{"error":"down"}`},
+	})
+	defer server.Close()
+
+	channel := newBulkChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", server.URL)
+	channel.SetConfig("circuit_breaker_threshold", 2)
+	whatsApp := newBulkChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", server.URL)
+	whatsApp.SetConfig("channel", "whatsapp")
+	whatsApp.SetConfig("scenario_key", "SCENARIO")
+	whatsApp.SetConfig("circuit_breaker_threshold", 2)
+
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	mb.AddChannel(whatsApp)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// a message sent by itself only counts once towards opening its channel's circuit
+	statuses := h.SendMsgs(context.Background(), newBulkMsgs(mb, whatsApp, 10, 1))
+	assert.Equal(t, courier.MsgErrored, statuses[0].Status())
+	assert.True(t, h.SendAllowed(whatsApp))
+
+	// as does each message submitted in bulk
+	statuses = h.SendMsgs(context.Background(), newBulkMsgs(mb, channel, 20, 1))
+	assert.Equal(t, courier.MsgErrored, statuses[0].Status())
+	assert.True(t, h.SendAllowed(channel))
+
+	statuses = h.SendMsgs(context.Background(), newBulkMsgs(mb, channel, 30, 1))
+	assert.Equal(t, courier.MsgErrored, statuses[0].Status())
+	assert.False(t, h.SendAllowed(channel))
+}
@@ -0,0 +1,111 @@
+package infobip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+)
+
+// Channels with url_options set have Infobip shorten and track the links in their messages, e.g.
+// {"shortenUrl": true, "trackClicks": true, "customDomain": "go.example.com"}. When clicks are tracked Infobip posts
+// them to our clicked route, which writes a link_clicked event for the contact who clicked.
+
+const configURLOptions = "url_options"
+
+// the format Infobip reports click times in
+const clickedAtFormat = "2006-01-02T15:04:05.999999999-0700"
+
+// urlOptionsForChannel returns the URL options to send with messages on the passed in channel, or nil if it has none
+func urlOptionsForChannel(channel courier.Channel, clickedURL string) *ibURLOptions {
+	config, _ := channel.ConfigForKey(configURLOptions, nil).(map[string]interface{})
+	if len(config) == 0 {
+		return nil
+	}
+
+	options := &ibURLOptions{}
+	options.ShortenURL, _ = config["shortenUrl"].(bool)
+	options.TrackClicks, _ = config["trackClicks"].(bool)
+	options.CustomDomain, _ = config["customDomain"].(string)
+	if options.TrackClicks {
+		options.TrackingURL = clickedURL
+	}
+	return options
+}
+
+// ClickEvent is our HTTP handler function for the link clicks Infobip tracks for us
+func (h *handler) ClickEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	clicks := &ibClickEnvelope{}
+	err := handlers.DecodeAndValidateJSON(clicks, r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	events := make([]courier.Event, 0, len(clicks.Results))
+	for _, click := range clicks.Results {
+		if click.To == "" {
+			continue
+		}
+
+		date := time.Now()
+		if click.ClickedAt != "" {
+			date, err = time.Parse(clickedAtFormat, click.ClickedAt)
+			if err != nil {
+				return nil, courier.WriteError(ctx, w, r, err)
+			}
+		}
+
+		urn := handlers.NewTelURNForChannel(click.To, channel)
+		event := h.Backend().NewChannelEvent(channel, courier.LinkClicked, urn).WithOccurredOn(date).WithExtra(map[string]interface{}{
+			"msg_id": click.MessageID,
+			"url":    click.URL,
+		})
+
+		err = h.Backend().WriteChannelEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return nil, h.WriteIgnored(ctx, w, r, "ignoring request, no clicks")
+	}
+
+	return events, courier.WriteChannelEventSuccess(ctx, w, r, events[0].(courier.ChannelEvent))
+}
+
+// clickedURL returns the URL Infobip should post the clicks on links in our messages to
+func clickedURL(callbackDomain string, channel courier.Channel) string {
+	return handlers.AddCallbackToken(fmt.Sprintf("https://%s/c/ib/%s/clicked", callbackDomain, channel.UUID()), channel)
+}
+
+// https://www.infobip.com/docs/api#channels/sms/send-sms-message
+type ibURLOptions struct {
+	ShortenURL   bool   `json:"shortenUrl,omitempty"`
+	TrackClicks  bool   `json:"trackClicks,omitempty"`
+	TrackingURL  string `json:"trackingUrl,omitempty"`
+	CustomDomain string `json:"customDomain,omitempty"`
+}
+
+// {
+// 	"results": [
+// 	  {
+// 		"messageId": "10",
+// 		"to": "250788383383",
+// 		"url": "https://example.com/campaign",
+// 		"clickedAt": "2018-01-01T12:00:00.000+0000"
+// 	  }
+// 	]
+// }
+type ibClickEnvelope struct {
+	Results []struct {
+		MessageID string `json:"messageId"`
+		To        string `json:"to"`
+		URL       string `json:"url"`
+		ClickedAt string `json:"clickedAt"`
+	} `validate:"required" json:"results"`
+}
@@ -0,0 +1,98 @@
+package infobip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+)
+
+var urlOptionsSendTestCases = []ChannelSendTestCase{
+	{Label: "URL Options Send",
+		Text: "Check out https://example.com/campaign", URN: "tel:+250788383383",
+		Status:       "W",
+		ResponseBody: `{"messages":[{"status":{"groupId": 1}}}`, ResponseStatus: 200,
+		RequestBody: `{"messages":[{"from":"2020","destinations":[{"to":"250788383383","messageId":"10"}],"text":"Check out https://example.com/campaign","notifyContentType":"application/json","intermediateReport":true,"notifyUrl":"https://localhost/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered","callbackData":"6a4d1e9c-2c5f-4b8e-9f1a-3d7b2e8c5a10","urlOptions":{"shortenUrl":true,"trackClicks":true,"trackingUrl":"https://localhost/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/clicked","customDomain":"go.example.com"}}]}`,
+		SendPrep:    setSendURL},
+}
+
+var shortenOnlySendTestCases = []ChannelSendTestCase{
+	{Label: "Shorten Only Send",
+		Text: "Check out https://example.com/campaign", URN: "tel:+250788383383",
+		Status:       "W",
+		ResponseBody: `{"messages":[{"status":{"groupId": 1}}}`, ResponseStatus: 200,
+		RequestBody: `{"messages":[{"from":"2020","destinations":[{"to":"250788383383","messageId":"10"}],"text":"Check out https://example.com/campaign","notifyContentType":"application/json","intermediateReport":true,"notifyUrl":"https://localhost/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered","callbackData":"6a4d1e9c-2c5f-4b8e-9f1a-3d7b2e8c5a10","urlOptions":{"shortenUrl":true}}]}`,
+		SendPrep:    setSendURL},
+}
+
+func TestURLOptionsSending(t *testing.T) {
+	var urlOptionsChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigPassword: "Password",
+			courier.ConfigUsername: "Username",
+			"url_options":          map[string]interface{}{"shortenUrl": true, "trackClicks": true, "customDomain": "go.example.com"},
+		})
+	var shortenOnlyChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigPassword: "Password",
+			courier.ConfigUsername: "Username",
+			"url_options":          map[string]interface{}{"shortenUrl": true},
+		})
+
+	RunChannelSendTestCases(t, urlOptionsChannel, NewHandler(), urlOptionsSendTestCases)
+	RunChannelSendTestCases(t, shortenOnlyChannel, NewHandler(), shortenOnlySendTestCases)
+}
+
+var clickURL = "/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/clicked/"
+
+var linkClicked = `{
+	"results": [
+		{
+			"messageId": "10",
+			"to": "250788383383",
+			"url": "https://example.com/campaign",
+			"clickedAt": "2018-01-01T12:00:00.000+0000"
+		}
+	]
+}`
+
+func TestClickEvent(t *testing.T) {
+	mb := courier.NewMockBackend()
+	mb.AddChannel(testChannels[0])
+	s := courier.NewServer(config.NewTest(), mb)
+	NewHandler().Initialize(s)
+
+	tcs := []struct {
+		body     string
+		status   int
+		response string
+	}{
+		{linkClicked, 200, "Event Accepted"},
+		{`{"results": []}`, 200, "ignoring request, no clicks"},
+		{`{"results": [{"to": "250788383383", "clickedAt": "yesterday"}]}`, 400, "cannot parse"},
+		{`{}`, 400, "Field validation for 'Results' failed"},
+	}
+
+	for _, tc := range tcs {
+		r := httptest.NewRequest(http.MethodPost, clickURL, strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		s.Router().ServeHTTP(rr, r)
+
+		assert.Equal(t, tc.status, rr.Code, "status mismatch for %s", tc.body)
+		assert.Contains(t, rr.Body.String(), tc.response)
+	}
+
+	// only our valid click was written
+	events := mb.WrittenChannelEvents()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, courier.LinkClicked, events[0].EventType())
+	assert.Equal(t, "tel:+250788383383", string(events[0].URN()))
+	assert.Equal(t, time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), events[0].OccurredOn().UTC())
+}
@@ -3,11 +3,20 @@ package infobip
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"hash/fnv"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -15,9 +24,152 @@ import (
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-var sendURL = "https://api.infobip.com/sms/1/text/advanced"
+// the URL of Infobip's API, channels with their own base URL use that instead
+const defaultAPIURL = "https://api.infobip.com"
+
+// checks whether the courier behind one of a channel's callback domains is up, overridden in tests
+var checkCallbackDomain = handlers.CheckCallbackDomain
+
+// generates the ids we send as callback data to tie our status reports back to our sends, overridden in tests
+var newCorrelationID = courier.NewCorrelationID
+
+// send responses are small, so anything bigger than this or slower than this once connected isn't Infobip
+const sendMaxBodyBytes = 64 * 1024
+const sendResponseTimeout = 20 * time.Second
+
+const configSenderPool = "sender_pool"
+const configBinary = "binary"
+const configChannel = "channel"
+const configStatusMapping = "status_mapping"
+const configScenarioKey = "scenario_key"
+const configWhatsAppTemplate = "whatsapp_template"
+const configWhatsAppLanguage = "whatsapp_language"
+const configNotifyContentType = "notify_content_type"
+const configApplicationID = "application_id"
+const configAuthType = "auth_type"
+const configEntityID = "entity_id"
+const configExtraParams = "extra_params"
+const configOptOutKeywords = "opt_out_keywords"
+const configForceGSM = "force_gsm"
+const configUseCleanText = "use_clean_text"
+const configDataCoding = "data_coding"
+const configFieldMapping = "field_mapping"
+const configPullPending = "pull_pending"
+const configSuccessGroupIDs = "success_group_ids"
+const configCampaignReference = "campaign_reference"
+const configLongSender = "long_sender"
+const configMessageIDPrefix = "message_id_prefix"
+const configEmptyText = "empty_text"
+const configTokenURL = "token_url"
+const configClientID = "client_id"
+const configClientSecret = "client_secret"
+const configCharReplacements = "char_replacements"
+const configMaxSegments = "max_segments"
+const configPollStatus = "poll_status"
+const configStoreIntermediate = "store_intermediate"
+const configReassignedIDs = "reassigned_ids"
+
+// the values for our channel config, which picks which Infobip channel we send over
+const channelSMS = "sms"
+const channelWhatsApp = "whatsapp"
+const channelViber = "viber"
+const channelOTP = "otp"
+
+// the values for our auth type config, API key auth uses an App authorization header instead of basic auth and OAuth
+// uses access tokens fetched from the channel's token endpoint
+const authTypeBasic = "basic"
+const authTypeAPIKey = "apikey"
+const authTypeOAuth = "oauth"
+
+// the content types Infobip can post delivery reports to us as
+const contentTypeJSON = "application/json"
+const contentTypeXML = "application/xml"
+
+// the data coding schemes we can send binary content with
+const dataCodingGSM7 = 0
+const dataCodingBinary = 4
+const dataCodingUCS2 = 8
+
+// the values for our data coding config (or a message's data_coding metadata), which map to the scheme above we send
+var dataCodings = map[string]int{
+	"gsm7": dataCodingGSM7,
+	"8bit": dataCodingBinary,
+	"ucs2": dataCodingUCS2,
+}
+
+// what we do with alphanumeric senders longer than carriers allow, by default we send them as they are
+const longSenderReject = "reject"
+const longSenderTruncate = "truncate"
+
+// what we do when Infobip reassigns the message id we sent with, by default we record theirs as the external id
+const reassignedIDsExternal = "external"
+const reassignedIDsIgnore = "ignore"
+
+// the config of our channels which is no more than a value with a default, typed and validated
+var (
+	flagChannel           = handlers.StringFlag{Key: configChannel, Default: channelSMS, Values: []string{channelSMS, channelWhatsApp, channelViber, channelOTP}}
+	flagLongSender        = handlers.StringFlag{Key: configLongSender, Values: []string{longSenderReject, longSenderTruncate}}
+	flagReassignedIDs     = handlers.StringFlag{Key: configReassignedIDs, Default: reassignedIDsExternal, Values: []string{reassignedIDsExternal, reassignedIDsIgnore}}
+	flagMaxSegments       = handlers.IntFlag{Key: configMaxSegments, Min: 1}
+	flagBinary            = handlers.BoolFlag{Key: configBinary}
+	flagForceGSM          = handlers.BoolFlag{Key: configForceGSM}
+	flagUseCleanText      = handlers.BoolFlag{Key: configUseCleanText}
+	flagPullPending       = handlers.BoolFlag{Key: configPullPending}
+	flagPollStatus        = handlers.BoolFlag{Key: configPollStatus}
+	flagStoreIntermediate = handlers.BoolFlag{Key: configStoreIntermediate, Default: true}
+	flagAuthType          = handlers.StringFlag{Key: configAuthType, Default: authTypeBasic, Values: []string{authTypeBasic, authTypeAPIKey, authTypeOAuth}}
+	flagNotifyContentType = handlers.StringFlag{Key: configNotifyContentType, Default: contentTypeJSON, Values: []string{contentTypeJSON, contentTypeXML}}
+	flagDataCoding        = handlers.StringFlag{Key: configDataCoding, Values: []string{"gsm7", "8bit", "ucs2"}}
+	flagUsername          = handlers.StringFlag{Key: courier.ConfigUsername}
+	flagPassword          = handlers.StringFlag{Key: courier.ConfigPassword}
+	flagAPIKey            = handlers.StringFlag{Key: courier.ConfigAPIKey}
+	flagTokenURL          = handlers.StringFlag{Key: configTokenURL}
+	flagClientID          = handlers.StringFlag{Key: configClientID}
+	flagClientSecret      = handlers.StringFlag{Key: configClientSecret}
+	flagBaseURL           = handlers.StringFlag{Key: courier.ConfigBaseURL}
+	flagMessageIDPrefix   = handlers.StringFlag{Key: configMessageIDPrefix}
+	flagEmptyText         = handlers.StringFlag{Key: configEmptyText}
+	flagScenarioKey       = handlers.StringFlag{Key: configScenarioKey}
+	flagApplicationID     = handlers.StringFlag{Key: configApplicationID}
+	flagEntityID          = handlers.StringFlag{Key: configEntityID}
+	flagCampaignReference = handlers.StringFlag{Key: configCampaignReference}
+	flagWhatsAppTemplate  = handlers.StringFlag{Key: configWhatsAppTemplate}
+	flagWhatsAppLanguage  = handlers.StringFlag{Key: configWhatsAppLanguage, Default: "en"}
+	flagOTPApplicationID  = handlers.StringFlag{Key: configOTPApplicationID}
+	flagOTPMessageID      = handlers.StringFlag{Key: configOTPMessageID}
+)
+
+// the longest alphanumeric sender carriers will deliver from
+const maxAlphanumericSender = 11
+
+// the acknowledgement Infobip expects for messages and delivery reports, anything else may be retried, delivery
+// reports have the ids and statuses we processed echoed back, which are Infobip's ids for statuses we wrote by them
+var ack = &courier.Ack{
+	ContentType:    "application/json",
+	Body:           `{"status":"ok"}`,
+	StatusTemplate: courier.NewAckStatusTemplate(`{"status":"ok","results":[{{range $i, $s := .}}{{if $i}},{{end}}{"messageId":{{if eq $s.ID "null"}}{{json $s.ExternalID}}{{else}}{{json $s.ID}}{{end}},"status":{{json $s.Status}}}{{end}}]}`),
+}
+
+// the status code we acknowledge requests we ignore with, Infobip retries pushes which get anything other than a 200
+const ignoredStatus = http.StatusOK
+
+// how long we wait for the missing parts of a concatenated incoming message before writing what we have, and how often
+// we check for messages we've given up waiting on
+const multipartTimeout = time.Minute * 5
+const multipartExpiryInterval = time.Minute
+
+// how many messages we ask for in each pull of pending messages, and the most pulls we'll make for a single push
+const pullLimit = 100
+const maxPendingPulls = 10
+
+// how long we trust a check of one of a channel's callback domains before checking it again
+const callbackCheckTTL = time.Minute
+
+// the format Infobip expects scheduled send times in, we always send these in UTC
+const sendAtFormat = "2006-01-02T15:04:05.000-0700"
 
 func init() {
 	courier.RegisterHandler(NewHandler())
@@ -25,121 +177,1120 @@ func init() {
 
 type handler struct {
 	handlers.BaseHandler
+	parts           *handlers.MultipartStore
+	callbackDomains *handlers.CallbackDomains
+	tokens          *tokenCache
+
+	// the API we make requests to for channels without their own base URL, and the client we make them with
+	apiURL string
+	client *http.Client
 }
 
 // NewHandler returns a new Infobip handler
 func NewHandler() courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(courier.ChannelType("IB"), "Infobip")}
+	h := &handler{
+		handlers.NewBaseHandler(courier.ChannelType("IB"), "Infobip"),
+		handlers.NewMultipartStore(multipartTimeout),
+		handlers.NewCallbackDomains(callbackCheckTTL, func(domain string) bool { return checkCallbackDomain(domain) }),
+		newTokenCache(),
+		defaultAPIURL,
+		utils.GetHTTPClient(),
+	}
+	h.SetAck(ack)
+	h.SetIgnoredStatus(ignoredStatus)
+	h.SetPhoneFormat(handlers.PhoneFormatE164NoPlus)
+	return h
 }
 
 // Initialize is called by the engine once everything is loaded
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
+
 	err := s.AddHandlerRoute(h, "POST", "receive", h.ReceiveMessage)
 	if err != nil {
 		return err
 	}
-	return s.AddHandlerRoute(h, "POST", "delivered", h.StatusMessage)
+	err = s.AddHandlerRoute(h, "GET", "receive", h.ReceiveQueryMessage)
+	if err != nil {
+		return err
+	}
+	err = s.AddHandlerRoute(h, "POST", "delivered", h.StatusMessage)
+	if err != nil {
+		return err
+	}
+	err = s.AddHandlerRoute(h, "POST", "clicked", h.ClickEvent)
+	if err != nil {
+		return err
+	}
+	err = s.AddHandlerRoute(h, "POST", "verify", h.VerifyPIN)
+	if err != nil {
+		return err
+	}
+	err = s.AddHandlerRoute(h, "POST", "test_send", h.TestSend)
+	if err != nil {
+		return err
+	}
+
+	h.startMultipartExpiry(s)
+	return nil
+}
+
+// startMultipartExpiry starts a goroutine which writes whatever we have of concatenated messages whose missing parts
+// never arrived, until the passed in server is stopped
+func (h *handler) startMultipartExpiry(s courier.Server) {
+	s.WaitGroup().Add(1)
+	go func() {
+		defer s.WaitGroup().Done()
+
+		ticker := time.NewTicker(multipartExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.StopChan():
+				return
+
+			case <-ticker.C:
+				h.writeExpiredParts(context.Background())
+			}
+		}
+	}()
+}
+
+// writeExpiredParts writes whatever we have of the concatenated messages we've given up waiting on the missing parts
+// of, returning the messages written
+func (h *handler) writeExpiredParts(ctx context.Context) []courier.Msg {
+	msgs := make([]courier.Msg, 0)
+	for _, multipart := range h.parts.Expire() {
+		part := multipart.Data.(*ibMsgPart)
+		msg := h.Backend().NewIncomingMsg(part.channel, part.urn, multipart.Text()).WithReceivedOn(part.date).WithExternalID(part.externalID)
+		err := h.Backend().WriteMsg(ctx, msg)
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", part.channel.UUID()).WithField("external_id", part.externalID).Error("error writing expired infobip message parts")
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// ValidateConfig checks that the passed in channel has everything it needs to send, optionally verifying its
+// credentials by fetching the account balance, which has no side effects, and for OTP channels that their 2FA
+// message template exists
+func (h *handler) ValidateConfig(ctx context.Context, channel courier.Channel, verify bool) error {
+	err := checkCredentials(channel)
+	if err != nil {
+		return err
+	}
+
+	if channel.Address() == "" {
+		return fmt.Errorf("no address set for IB channel")
+	}
+
+	err = handlers.ValidateFlags(channel, flagChannel, flagAuthType, flagNotifyContentType, flagDataCoding, flagLongSender,
+		flagReassignedIDs, flagMaxSegments, flagBinary, flagForceGSM, flagUseCleanText, flagPullPending, flagPollStatus,
+		flagStoreIntermediate)
+	if err != nil {
+		return err
+	}
+
+	isOTP := flagChannel.Get(channel) == channelOTP
+	if isOTP {
+		err = checkOTPConfig(channel)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = courier.ChannelAllowedIPs(channel)
+	if err != nil {
+		return err
+	}
+
+	_, err = charReplacer(channel)
+	if err != nil {
+		return err
+	}
+
+	baseURL := flagBaseURL.Get(channel)
+	if baseURL != "" {
+		parsed, err := url.Parse(baseURL)
+		if err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("invalid base_url set for IB channel: '%s'", baseURL)
+		}
+	}
+
+	if !verify {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, h.endpointURL(channel, "account", "1", "balance"), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	err = h.authorize(ctx, req, channel)
+	if err != nil {
+		return err
+	}
+
+	rr, err := utils.MakeHTTPRequestWithOptions(req, h.requestOptions())
+	if err != nil {
+		if rr != nil && rr.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("invalid credentials for IB channel")
+		}
+		return errors.Wrap(err, "unable to verify IB channel credentials")
+	}
+
+	if isOTP {
+		return h.verifyOTPTemplate(ctx, channel)
+	}
+	return nil
+}
+
+// checkCredentials returns an error if the passed in channel is missing the credentials its auth type needs
+func checkCredentials(channel courier.Channel) error {
+	switch flagAuthType.Get(channel) {
+	case authTypeAPIKey:
+		if flagAPIKey.Get(channel) == "" {
+			return fmt.Errorf("no API key set for IB channel")
+		}
+		return nil
+	case authTypeOAuth:
+		for _, flag := range []handlers.StringFlag{flagTokenURL, flagClientID, flagClientSecret} {
+			if flag.Get(channel) == "" {
+				return fmt.Errorf("no %s set for IB channel", flag.Key)
+			}
+		}
+		return nil
+	}
+
+	if flagUsername.Get(channel) == "" {
+		return fmt.Errorf("no username set for IB channel")
+	}
+	if flagPassword.Get(channel) == "" {
+		return fmt.Errorf("no password set for IB channel")
+	}
+	return nil
+}
+
+// setAuthorization authorizes the passed in request using the auth type configured on the passed in channel, also
+// adding any headers configured on the channel. Requests on OAuth channels need their access token added by authorize.
+func setAuthorization(req *http.Request, channel courier.Channel) {
+	handlers.SetChannelHeaders(req, channel)
+
+	switch flagAuthType.Get(channel) {
+	case authTypeAPIKey:
+		req.Header.Set("Authorization", fmt.Sprintf("App %s", flagAPIKey.Get(channel)))
+		return
+	case authTypeOAuth:
+		return
+	}
+	req.SetBasicAuth(flagUsername.Get(channel), flagPassword.Get(channel))
+}
+
+// charReplacer returns a replacer for the char_replacements configured on the passed in channel, if any. These map
+// strings to what they should be replaced with when sending, an empty replacement strips the string.
+func charReplacer(channel courier.Channel) (*strings.Replacer, error) {
+	var config map[string]interface{}
+	switch value := channel.ConfigForKey(configCharReplacements, nil).(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		config = value
+	default:
+		return nil, fmt.Errorf("invalid char_replacements set for IB channel: %v", value)
+	}
+
+	// longer strings are replaced first so they aren't broken up by replacements of their parts
+	olds := make([]string, 0, len(config))
+	for old, value := range config {
+		if _, isString := value.(string); !isString || old == "" {
+			return nil, fmt.Errorf("invalid char_replacements set for IB channel: %v", config)
+		}
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, j int) bool {
+		if len(olds[i]) != len(olds[j]) {
+			return len(olds[i]) > len(olds[j])
+		}
+		return olds[i] < olds[j]
+	})
+
+	pairs := make([]string, 0, len(olds)*2)
+	for _, old := range olds {
+		pairs = append(pairs, old, config[old].(string))
+	}
+	return strings.NewReplacer(pairs...), nil
 }
 
 // StatusMessage is our HTTP handler function for status updates
 func (h *handler) StatusMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	payload, err := handlers.ReadBody(r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	// delivery reports are posted in whatever notifyContentType we sent with, which may be XML
 	ibStatusEnvelope := &ibStatusEnvelope{}
-	err := handlers.DecodeAndValidateJSON(ibStatusEnvelope, r)
+	var invalid []error
+	if strings.Contains(r.Header.Get("Content-Type"), "xml") {
+		err = handlers.DecodeAndValidateXML(ibStatusEnvelope, r)
+		if err == nil {
+			invalid = handlers.ValidateItems(&ibStatusEnvelope.Results)
+		}
+	} else {
+		err = remapResultFields(channel, r, payload)
+		if err == nil {
+			invalid, err = handlers.DecodeAndValidateJSONItems(ibStatusEnvelope, &ibStatusEnvelope.Results, r)
+		}
+	}
 	if err != nil {
 		return nil, courier.WriteError(ctx, w, r, err)
 	}
 
-	msgStatus, found := infobipStatusMapping[ibStatusEnvelope.Results[0].Status.GroupName]
-	if !found {
-		return nil, courier.WriteError(ctx, w, r, fmt.Errorf("unknown status '%s', must be one of PENDING, DELIVERED, EXPIRED, REJECTED or UNDELIVERABLE", ibStatusEnvelope.Results[0].Status.GroupName))
+	// we update the status of each valid result, invalid ones are noted but don't stop us
+	for _, itemErr := range invalid {
+		h.Backend().WriteChannelError(ctx, courier.NewChannelError("Invalid Status", channel, r, string(payload), itemErr))
+	}
+	if len(ibStatusEnvelope.Results) == 0 {
+		err = fmt.Errorf("no valid results in status update")
+		if len(invalid) > 0 {
+			err = invalid[0]
+		}
+		return nil, courier.WriteError(ctx, w, r, err)
 	}
 
-	// write our status
-	status := h.Backend().NewMsgStatusForID(channel, courier.NewMsgID(ibStatusEnvelope.Results[0].MessageID), msgStatus)
-	err = h.Backend().WriteMsgStatus(ctx, status)
+	// results we can't update a status for are skipped, we only error if we couldn't update any
+	events := make([]courier.Event, 0, len(ibStatusEnvelope.Results))
+	statuses := make([]courier.MsgStatus, 0, len(ibStatusEnvelope.Results))
+	var skipped []error
+	ignored := ""
+	for _, result := range ibStatusEnvelope.Results {
+		ibErr := result.Error
+		msgStatus, found := statusForResult(channel, result.Status.GroupName, result.Status.Name, ibErr)
+		if !found {
+			err = fmt.Errorf("unknown status '%s', must be one of PENDING, DELIVERED, EXPIRED, REJECTED or UNDELIVERABLE", result.Status.GroupName)
+			h.Backend().WriteChannelError(ctx, courier.NewChannelError("Unknown Status", channel, r, string(payload), err))
+			skipped = append(skipped, err)
+			continue
+		}
+
+		// ids which aren't ours were reassigned by Infobip, and are the external ids of our messages unless our channel
+		// ignores them
+		msgID, err := msgIDForMessageID(channel, result.MessageID)
+		if err != nil && flagReassignedIDs.Get(channel) == reassignedIDsIgnore {
+			skipped = append(skipped, err)
+			continue
+		}
+
+		// channels with store_intermediate set to false only store final statuses, intermediate ones are acknowledged so
+		// that Infobip doesn't retry them, but not written
+		storeIntermediate := flagStoreIntermediate.Get(channel)
+		if !storeIntermediate && msgStatus != courier.MsgDelivered && msgStatus != courier.MsgFailed {
+			if ignored == "" {
+				ignored = fmt.Sprintf("ignoring intermediate status '%s'", msgStatus)
+			}
+			continue
+		}
+
+		// our callback data is the correlation id of our send
+		var status courier.MsgStatus
+		if msgID != courier.NilMsgID {
+			status = h.Backend().NewMsgStatusForID(channel, msgID, msgStatus)
+		} else {
+			status = h.Backend().NewMsgStatusForExternalID(channel, string(result.MessageID), msgStatus)
+		}
+		status.SetCorrelationID(result.CallbackData)
+
+		// record what Infobip charged us if they told us
+		if result.Price != nil && result.Price.Currency != "" {
+			status.SetPrice(&courier.MsgPrice{Amount: result.Price.PricePerMessage, Currency: result.Price.Currency})
+		}
+
+		// and the campaign we sent the message for so delivery can be attributed to it
+		status.SetCampaignReference(result.CampaignReferenceID)
+
+		// and the details of the report which are specific to Infobip
+		if result.Status.Name != "" {
+			status.SetMetadata("status_name", result.Status.Name)
+		}
+		if result.SmsCount > 0 {
+			status.SetMetadata("sms_count", result.SmsCount)
+		}
+		if result.MccMnc != "" {
+			status.SetMetadata("mcc_mnc", result.MccMnc)
+		}
+		if msgStatus == courier.MsgFailed && isBlacklisted(result.Status.ID, result.Status.Name) {
+			status.AddLog(courier.NewChannelLog("Destination Blacklisted", channel, status.ID(), r.Method, r.URL.String(), courier.NilStatusCode,
+				"", "", 0, errors.Errorf("destination rejected by Infobip as blacklisted: %s", result.Status.Name)).WithCorrelationID(status.CorrelationID()))
+		} else if ibErr.isError() {
+			status.AddLog(courier.NewChannelLog("Message Error", channel, status.ID(), r.Method, r.URL.String(), courier.NilStatusCode,
+				"", "", 0, ibErr.asError()).WithCorrelationID(status.CorrelationID()))
+		} else if result.Status.GroupName == groupExpired {
+			status.AddLog(courier.NewChannelLog("Message Expired", channel, status.ID(), r.Method, r.URL.String(), courier.NilStatusCode,
+				"", "", 0, errors.New("message expired before it could be delivered")).WithCorrelationID(status.CorrelationID()))
+		}
+
+		// write our status, reports for reassigned ids we don't know of aren't for us
+		err = h.Backend().WriteMsgStatus(ctx, status)
+		if err == courier.ErrMsgNotFound {
+			skipped = append(skipped, fmt.Errorf("invalid message id: %s", result.MessageID))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// once we have a final status there's no need to poll for one
+		if msgStatus == courier.MsgDelivered || msgStatus == courier.MsgFailed {
+			if msgID != courier.NilMsgID {
+				h.Server().PollScheduler().Cancel(msgID)
+			} else {
+				h.Server().PollScheduler().CancelExternalID(channel.UUID(), string(result.MessageID))
+			}
+		}
+
+		events = append(events, status)
+		statuses = append(statuses, status)
+	}
+
+	if len(statuses) == 0 {
+		if len(skipped) > 0 {
+			return nil, courier.WriteError(ctx, w, r, skipped[0])
+		}
+		return nil, h.WriteIgnored(ctx, w, r, ignored)
+	}
+
+	// when some results were updated, those we skipped are noted
+	for _, skipErr := range skipped {
+		h.Backend().WriteChannelError(ctx, courier.NewChannelError("Invalid Status", channel, r, string(payload), skipErr))
+	}
+
+	return events, h.WriteStatusSuccess(ctx, w, r, statuses)
+}
+
+// remapResultFields rewrites the results in the passed in JSON payload using the field_mapping configured on our
+// channel, a map from the field names we decode to those the channel's Infobip account uses instead, e.g.
+// {"messageId": "id", "from": "sender"}, and replaces the body of the passed in request with the rewritten payload.
+// Fields already present under our names are left alone, so the standard names always win.
+func remapResultFields(channel courier.Channel, r *http.Request, payload []byte) error {
+	mapping, _ := channel.ConfigForKey(configFieldMapping, nil).(map[string]interface{})
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	// decode numbers as such so that we don't lose the precision of large ids
+	envelope := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	err := decoder.Decode(&envelope)
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "unable to parse request JSON")
+	}
+
+	results, _ := envelope["results"].([]interface{})
+	for _, item := range results {
+		result, isObject := item.(map[string]interface{})
+		if !isObject {
+			continue
+		}
+		for ours, theirs := range mapping {
+			theirField, isStr := theirs.(string)
+			if !isStr {
+				continue
+			}
+			value, found := result[theirField]
+			if _, present := result[ours]; found && !present {
+				result[ours] = value
+			}
+		}
 	}
 
-	return []courier.Event{status}, courier.WriteStatusSuccess(ctx, w, r, []courier.MsgStatus{status})
+	remapped, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(remapped))
+	return nil
 }
 
+// statusForResult returns the status for the passed in group name, status name and error. A mapping for the status
+// name, e.g. PENDING_ENROUTE, takes precedence over one for its group, and an error, if it is set, is more precise
+// than either so we use whether it is permanent to decide if the msg failed
+func statusForResult(channel courier.Channel, groupName string, name string, ibErr *ibStatusError) (courier.MsgStatusValue, bool) {
+	mapping := statusMappingForChannel(channel)
+	msgStatus, found := mapping[name]
+	if name == "" || !found {
+		msgStatus, found = mapping[groupName]
+	}
+	if !found {
+		return "", false
+	}
+
+	if ibErr.isError() {
+		if ibErr.Permanent {
+			return courier.MsgFailed, true
+		}
+		return courier.MsgSent, true
+	}
+	return msgStatus, true
+}
+
+// the group names of statuses which are worth telling apart from the others they map to
+const groupPending = "PENDING"
+const groupExpired = "EXPIRED"
+
+// PENDING messages may still be delivered, but EXPIRED ones never will be as Infobip has given up trying
 var infobipStatusMapping = map[string]courier.MsgStatusValue{
-	"PENDING":       courier.MsgSent,
-	"EXPIRED":       courier.MsgSent,
+	groupPending:    courier.MsgSent,
+	groupExpired:    courier.MsgFailed,
 	"DELIVERED":     courier.MsgDelivered,
 	"REJECTED":      courier.MsgFailed,
 	"UNDELIVERABLE": courier.MsgFailed,
 }
 
+// statusMappingForChannel returns our default status mapping merged with any overrides in the channel's status_mapping
+// config, which maps group names or status names to status values, e.g. {"PENDING": "S", "PENDING_ENROUTE": "W"}
+func statusMappingForChannel(channel courier.Channel) map[string]courier.MsgStatusValue {
+	overrides, _ := channel.ConfigForKey(configStatusMapping, nil).(map[string]interface{})
+	if len(overrides) == 0 {
+		return infobipStatusMapping
+	}
+
+	mapping := make(map[string]courier.MsgStatusValue, len(infobipStatusMapping)+len(overrides))
+	for groupName, status := range infobipStatusMapping {
+		mapping[groupName] = status
+	}
+	for groupName, value := range overrides {
+		status, _ := value.(string)
+		if validMappedStatuses[courier.MsgStatusValue(status)] {
+			mapping[groupName] = courier.MsgStatusValue(status)
+		}
+	}
+	return mapping
+}
+
+// the statuses which a status_mapping config is allowed to map to
+var validMappedStatuses = map[courier.MsgStatusValue]bool{
+	courier.MsgSent:      true,
+	courier.MsgDelivered: true,
+	courier.MsgFailed:    true,
+	courier.MsgErrored:   true,
+	courier.MsgWired:     true,
+}
+
+// <reportResponse>
+// 	<results>
+// 	  <result>
+// 		<messageId>12345</messageId>
+// 		<status>
+// 		  <groupName>DELIVERED</groupName>
+// 		</status>
+// 	  </result>
+// 	</results>
+// </reportResponse>
 type ibStatusEnvelope struct {
-	Results []ibStatus `validate:"required" json:"results"`
+	XMLName xml.Name   `json:"-" xml:"reportResponse"`
+	Results []ibStatus `validate:"required" json:"results" xml:"results>result"`
 }
 type ibStatus struct {
-	MessageID int64 `validate:"required" json:"messageId"`
+	MessageID ibMessageID `validate:"required" json:"messageId" xml:"messageId"`
 	Status    struct {
-		GroupName string `validate:"required" json:"groupName"`
-	} `validate:"required" json:"status"`
+		GroupName string `validate:"required" json:"groupName" xml:"groupName"`
+		ID        int64  `json:"id" xml:"id"`
+		Name      string `json:"name" xml:"name"`
+	} `validate:"required" json:"status" xml:"status"`
+	Error               *ibStatusError `json:"error" xml:"error"`
+	Price               *ibPrice       `json:"price" xml:"price"`
+	CallbackData        string         `json:"callbackData" xml:"callbackData"`
+	CampaignReferenceID string         `json:"campaignReferenceId" xml:"campaignReferenceId"`
+	SmsCount            int            `json:"smsCount" xml:"smsCount"`
+	MccMnc              string         `json:"mccMnc" xml:"mccMnc"`
+}
+
+// ibMessageID is the id of the message a delivery report is for, which is the id we sent the message with. Infobip
+// echoes it back as the string we sent it as, but older reports have it as a number so we accept both.
+type ibMessageID string
+
+// UnmarshalJSON unmarshals a message id from either a JSON number or a string
+func (i *ibMessageID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if _, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		*i = ibMessageID(data)
+		return nil
+	}
+	var id string
+	if err := json.Unmarshal(data, &id); err != nil {
+		return fmt.Errorf("invalid message id: %s", data)
+	}
+	*i = ibMessageID(id)
+	return nil
+}
+
+// messageIDForMsg returns the message id we send the passed in message to Infobip with, which is our id with any
+// prefix the channel has to keep it from colliding with the ids of other instances sending on the same account.
+// Messages without an id, e.g. test messages, are sent without one and Infobip generates its own.
+func messageIDForMsg(msg courier.Msg) string {
+	if msg.ID() == courier.NilMsgID {
+		return ""
+	}
+	return flagMessageIDPrefix.Get(msg.Channel()) + msg.ID().String()
+}
+
+// msgIDForMessageID returns our id for the message Infobip knows by the passed in message id, stripping off the
+// channel's prefix if it has one
+func msgIDForMessageID(channel courier.Channel, messageID ibMessageID) (courier.MsgID, error) {
+	id := strings.TrimPrefix(string(messageID), flagMessageIDPrefix.Get(channel))
+	msgID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return courier.NilMsgID, fmt.Errorf("invalid message id: %s", messageID)
+	}
+	return courier.NewMsgID(msgID), nil
+}
+
+type ibPrice struct {
+	PricePerMessage float64 `json:"pricePerMessage" xml:"pricePerMessage"`
+	Currency        string  `json:"currency" xml:"currency"`
+}
+
+type ibStatusError struct {
+	GroupName   string `json:"groupName" xml:"groupName"`
+	Name        string `json:"name" xml:"name"`
+	Description string `json:"description" xml:"description"`
+	Permanent   bool   `json:"permanent" xml:"permanent"`
+}
+
+// isError returns whether this is an actual error, Infobip sends an OK group when there isn't one
+func (e *ibStatusError) isError() bool {
+	return e != nil && e.GroupName != "" && e.GroupName != "OK"
+}
+
+func (e *ibStatusError) asError() error {
+	return errors.Errorf("%s (%s): %s", e.Name, e.GroupName, e.Description)
+}
+
+// the modes we send messages in, each of which has its own endpoint
+const sendModeText = "text"
+const sendModeBinary = "binary"
+const sendModeOmni = "omni"
+
+// the paths of the endpoint for each send mode, relative to an account's base URL
+var sendModePaths = map[string][]string{
+	sendModeText:   []string{"sms", "1", "text", "advanced"},
+	sendModeBinary: []string{"sms", "1", "binary", "advanced"},
+	sendModeOmni:   []string{"omni", "1", "advanced"},
+}
+
+// sendURL returns the URL of the endpoint the passed in channel sends messages in the passed in mode to
+func (h *handler) sendURL(channel courier.Channel, mode string) string {
+	return h.endpointURL(channel, sendModePaths[mode]...)
+}
+
+// endpointURL returns the URL of the API endpoint at the passed in path for the passed in channel, which is under the
+// channel's base URL if it has one, otherwise under our API URL
+func (h *handler) endpointURL(channel courier.Channel, paths ...string) string {
+	baseURL := flagBaseURL.Get(channel)
+	if baseURL == "" {
+		baseURL = h.apiURL
+	}
+
+	endpoint, err := utils.AddURLPath(baseURL, paths...)
+	if err != nil {
+		endpoint, _ = utils.AddURLPath(h.apiURL, paths...)
+	}
+	return endpoint
+}
+
+// requestOptions returns the options we make requests to Infobip other than sends with
+func (h *handler) requestOptions() utils.HTTPRequestOptions {
+	options := utils.DefaultHTTPRequestOptions
+	options.Client = h.client
+	return options
+}
+
+// sendOptions returns the options we make send requests to Infobip with
+func (h *handler) sendOptions() utils.HTTPRequestOptions {
+	return utils.HTTPRequestOptions{MaxBodyBytes: sendMaxBodyBytes, ResponseTimeout: sendResponseTimeout, Client: h.client}
+}
+
+// how long after sending a message Infobip can take to send us its final delivery report
+const deliveryReportWindow = time.Hour * 6
+
+// DeliveryReportWindow returns how long after sending a message we wait for its final delivery report before failing it
+func (h *handler) DeliveryReportWindow() time.Duration { return deliveryReportWindow }
+
+// schedulePoll schedules polling for the status of the passed in message if it was sent and its channel wants it, so
+// channels with poll_status set have their statuses polled from the logs API without waiting for the server to notice
+// their reports are missing
+func (h *handler) schedulePoll(msg courier.Msg, status courier.MsgStatus) {
+	pollStatus := flagPollStatus.Get(msg.Channel())
+	if pollStatus && status.Status() == courier.MsgWired && msg.ID() != courier.NilMsgID {
+		if status.ExternalID() != "" {
+			msg = msg.WithExternalID(status.ExternalID())
+		}
+		h.Server().PollScheduler().Schedule(msg, h.PollStatus)
+	}
+}
+
+// PollStatus queries the Infobip logs API for the status of the passed in msg, returning nil if it is still pending
+func (h *handler) PollStatus(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
+	err := checkCredentials(msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	// we send our (prefixed) msg id as the Infobip message id, but if Infobip reassigned it we look up theirs, which is
+	// our external id
+	messageID := messageIDForMsg(msg)
+	if msg.ExternalID() != "" {
+		messageID = msg.ExternalID()
+	}
+	logsURL := h.endpointURL(msg.Channel(), "sms", "1", "logs")
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?messageId=%s", logsURL, url.QueryEscape(messageID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	err = h.authorize(ctx, req, msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	rr, err := utils.MakeHTTPRequestWithOptions(req, h.requestOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying IB logs")
+	}
+
+	logs := &ibLogsEnvelope{}
+	err = json.Unmarshal(rr.Body, logs)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse IB logs response")
+	}
+
+	// Infobip doesn't know about this msg or we have nothing new
+	if len(logs.Results) == 0 {
+		return nil, nil
+	}
+	result := logs.Results[0]
+	msgStatus, found := statusForResult(msg.Channel(), result.Status.GroupName, result.Status.Name, result.Error)
+	if !found || msgStatus == courier.MsgSent || msgStatus == courier.MsgWired {
+		return nil, nil
+	}
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), msgStatus)
+	log := courier.NewChannelLogFromRR("Status Polled", msg.Channel(), msg.ID(), rr)
+	if result.Error.isError() {
+		log.WithError("Status Polled", result.Error.asError())
+	}
+	status.AddLog(log)
+	return status, nil
+}
+
+// {
+// 	"results": [
+// 	  {
+// 		"bulkId": "bafdeb3d-719b-4cce-8762-54d47b40f3c5",
+// 		"messageId": "10",
+// 		"to": "250788383383",
+// 		"status": {
+// 		  "groupName": "DELIVERED",
+// 		  "name": "DELIVERED_TO_HANDSET"
+// 		}
+// 	  }
+// 	]
+// }
+type ibLogsEnvelope struct {
+	Results []struct {
+		Status struct {
+			GroupName string `json:"groupName"`
+			Name      string `json:"name"`
+		} `json:"status"`
+		Error *ibStatusError `json:"error"`
+	} `json:"results"`
 }
 
 // ReceiveMessage is our HTTP handler function for incoming messages
 func (h *handler) ReceiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	payload, err := handlers.ReadBody(r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	err = remapResultFields(channel, r, payload)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
 	ie := &infobipEnvelope{}
-	err := handlers.DecodeAndValidateJSON(ie, r)
+	invalid, err := handlers.DecodeAndValidateJSONItems(ie, &ie.Results, r)
 	if err != nil {
 		return nil, courier.WriteError(ctx, w, r, err)
 	}
 
+	// results we can't receive are noted but don't stop us receiving the rest
+	for _, itemErr := range invalid {
+		h.Backend().WriteChannelError(ctx, courier.NewChannelError("Invalid Message", channel, r, string(payload), itemErr))
+	}
+
 	if ie.MessageCount == 0 {
-		return nil, courier.WriteIgnored(ctx, w, r, "ignoring request, no message")
+		h.Backend().WriteChannelError(ctx, courier.NewChannelError("No Message", channel, r, string(payload), nil))
+		return nil, h.WriteIgnored(ctx, w, r, "ignoring request, no message")
 	}
 
+	// a count without any results is a malformed payload, there's nothing we can receive
+	if len(ie.Results) == 0 && len(invalid) == 0 {
+		err = fmt.Errorf("message count of %d but no results", ie.MessageCount)
+		h.Backend().WriteChannelError(ctx, courier.NewChannelError("No Results", channel, r, string(payload), err))
+		return nil, h.WriteIgnored(ctx, w, r, "ignoring request, no results")
+	}
+
+	// otherwise we receive what results we have, but note when they don't match the count
+	if ie.MessageCount != len(ie.Results)+len(invalid) {
+		logrus.WithField("channel_uuid", channel.UUID()).WithField("message_count", ie.MessageCount).WithField("results", len(ie.Results)).Warning("infobip message count doesn't match results")
+	}
+
+	return h.receive(ctx, channel, w, r, ie.Results, ie.PendingMessageCount, string(payload))
+}
+
+// ReceiveQueryMessage is our HTTP handler function for incoming messages pushed by legacy configurations as GET
+// requests, with the message in the query string rather than a JSON body
+func (h *handler) ReceiveQueryMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	query := &ibQueryMessage{}
+	err := handlers.DecodeAndValidateQueryParams(query, r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	results := []infobipMessage{{
+		MessageID:  query.MessageID,
+		From:       query.From,
+		To:         query.To,
+		Text:       query.Text,
+		ReceivedAt: query.ReceivedAt,
+	}}
+	return h.receive(ctx, channel, w, r, results, 0, r.URL.RawQuery)
+}
+
+// receive writes the messages in the passed in results and writes our response, payload is the raw request and is
+// only used for logging channel errors
+func (h *handler) receive(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, results []infobipMessage, pending int, payload string) ([]courier.Event, error) {
+	msgs, buffered, err := h.receiveResults(ctx, channel, results)
+	if err != nil {
+		return nil, err
+	}
+
+	// channels which pull their messages drain whatever else Infobip is holding for them
+	pullPending := flagPullPending.Get(channel)
+	if pullPending && pending > 0 {
+		pulled, pulledBuffered := h.pullPending(ctx, channel)
+		msgs = append(msgs, pulled...)
+		buffered += pulledBuffered
+	}
+
+	if len(msgs) == 0 && buffered > 0 {
+		return nil, h.WriteIgnored(ctx, w, r, "message parts buffered until all have arrived")
+	}
+
+	if len(msgs) == 0 {
+		h.Backend().WriteChannelError(ctx, courier.NewChannelError("No Message", channel, r, payload, nil))
+		return nil, h.WriteIgnored(ctx, w, r, "ignoring request, no message")
+	}
+
+	return []courier.Event{msgs[0]}, h.WriteMsgSuccess(ctx, w, r, msgs)
+}
+
+// receiveResults writes the messages in the passed in results, returning those written and the number of parts of
+// concatenated messages which were buffered rather than written
+func (h *handler) receiveResults(ctx context.Context, channel courier.Channel, results []infobipMessage) ([]courier.Msg, int, error) {
+	var err error
 	msgs := []courier.Msg{}
-	for _, infobipMessage := range ie.Results {
+	buffered := 0
+	for _, infobipMessage := range results {
 		messageID := infobipMessage.MessageID
 		text := infobipMessage.Text
 		dateString := infobipMessage.ReceivedAt
 
+		// MMS carry their caption, media and any shared location as parts of the message
+		var attachments []ibMMSPart
+		var locations []string
+		for _, part := range infobipMessage.Message {
+			if part.URL != "" {
+				attachments = append(attachments, part)
+			} else if part.Latitude != nil && part.Longitude != nil {
+				locations = append(locations, fmt.Sprintf("geo:%f,%f", *part.Latitude, *part.Longitude))
+			} else if text == "" && strings.HasPrefix(part.ContentType, "text/") {
+				text = part.Value
+			}
+		}
+
+		// only messages with nothing in them at all are ignored, others without text get the channel's placeholder
 		if text == "" {
-			continue
+			if len(attachments) == 0 && len(locations) == 0 {
+				continue
+			}
+			text = flagEmptyText.Get(channel)
 		}
 
 		date := time.Now()
 		if dateString != "" {
 			date, err = time.Parse("2006-01-02T15:04:05.999999999-0700", dateString)
 			if err != nil {
-				return nil, courier.WriteError(ctx, w, r, err)
+				return nil, 0, err
 			}
 		}
 
+		// Infobip's clock can be hours off ours, channels can have us log that and receive at our time instead
+		providerDate := date
+		date, clamped := handlers.CheckClockSkew(channel, date, time.Now())
+
 		// create our URN
-		urn := urns.NewTelURNForCountry(infobipMessage.From, channel.Country())
+		urn := handlers.NewTelURNForChannel(infobipMessage.From, channel)
+
+		// shared short codes and number pools may need this received on a different channel than the one in our URL
+		msgChannel, err := handlers.ResolveChannel(ctx, h.Backend(), channel, infobipMessage.To, urn)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// keep track of when this channel last heard from Infobip so that we can spot outages
+		err = h.Backend().MarkChannelReceived(ctx, msgChannel, time.Now())
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", msgChannel.UUID()).Error("error marking channel received")
+		}
+
+		// parts of concatenated messages are buffered until we have all of them
+		udh, _ := hex.DecodeString(infobipMessage.UDH)
+		ref, total, seq, isPart := handlers.ParseConcatUDH(udh)
+		if isPart && total > 1 {
+			key := fmt.Sprintf("%s:%s:%d", msgChannel.UUID(), urn, ref)
+			part := &ibMsgPart{channel: msgChannel, urn: urn, externalID: messageID, date: date}
+			multipart := h.parts.Add(key, total, seq, text, part)
+			if multipart == nil {
+				buffered++
+				continue
+			}
+			text = multipart.Text()
+		}
+
+		// keyword channels may want the text without the keyword, we keep the full text in case it's needed
+		fullText := ""
+		useCleanText := flagUseCleanText.Get(msgChannel)
+		if useCleanText && infobipMessage.CleanText != "" && !isPart {
+			fullText, text = text, infobipMessage.CleanText
+		}
 
 		// build our infobipMessage
-		msg := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date).WithExternalID(messageID)
+		msg := h.Backend().NewIncomingMsg(msgChannel, urn, text).WithReceivedOn(date).WithExternalID(messageID)
+		if fullText != "" {
+			msg.WithMetadata("full_text", fullText)
+		}
+		if clamped {
+			msg.WithMetadata("provider_received_on", providerDate.UTC().Format(time.RFC3339Nano))
+		}
+		if infobipMessage.Keyword != "" {
+			msg.WithMetadata("keyword", infobipMessage.Keyword)
+		}
+		if infobipMessage.SmsCount > 1 {
+			msg.WithMetadata("sms_count", infobipMessage.SmsCount)
+		}
+		if name := infobipMessage.contactName(); name != "" {
+			msg.WithContactName(name)
+		}
+		for _, attachment := range attachments {
+			msg.WithAttachment(h.receiveAttachment(ctx, msgChannel, attachment))
+		}
+		for _, location := range locations {
+			msg.WithAttachment(location)
+		}
 
 		// and write it
 		err = h.Backend().WriteMsg(ctx, msg)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		msgs = append(msgs, msg)
 
+		// contacts opting out are also stopped so that we don't send to them again
+		if isOptOut(msgChannel, text) {
+			event := h.Backend().NewChannelEvent(msgChannel, courier.StopContact, urn).WithOccurredOn(date)
+			err = h.Backend().WriteChannelEvent(ctx, event)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return msgs, buffered, nil
+}
+
+// pullPending pulls the messages Infobip is holding for the passed in channel, writing them as they arrive. We stop
+// once nothing is pending, after maxPendingPulls pulls so that a provider that always claims more can't keep us here
+// forever, or on any error, in which case what's left will be pulled after our next push.
+func (h *handler) pullPending(ctx context.Context, channel courier.Channel) ([]courier.Msg, int) {
+	log := logrus.WithField("channel_uuid", channel.UUID())
+	msgs := []courier.Msg{}
+	buffered := 0
+
+	pullURL := h.endpointURL(channel, "sms", "1", "inbox", "messages")
+	for i := 0; i < maxPendingPulls; i++ {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?limit=%d", pullURL, pullLimit), nil)
+		if err != nil {
+			log.WithError(err).Error("error building infobip pull request")
+			break
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", "application/json")
+		err = h.authorize(ctx, req, channel)
+		if err != nil {
+			log.WithError(err).Error("error authorizing infobip pull request")
+			break
+		}
+
+		rr, err := utils.MakeHTTPRequestWithOptions(req, h.requestOptions())
+		if err != nil {
+			log.WithError(err).Error("error pulling pending infobip messages")
+			break
+		}
+
+		ie := &infobipEnvelope{}
+		err = json.Unmarshal(rr.Body, ie)
+		if err != nil {
+			log.WithError(err).Error("unable to parse infobip pull response")
+			break
+		}
+
+		pulled, pulledBuffered, err := h.receiveResults(ctx, channel, ie.Results)
+		if err != nil {
+			log.WithError(err).Error("error receiving pulled infobip messages")
+			break
+		}
+		msgs = append(msgs, pulled...)
+		buffered += pulledBuffered
+
+		if ie.PendingMessageCount == 0 || len(ie.Results) == 0 {
+			break
+		}
+	}
+	return msgs, buffered
+}
+
+// receiveAttachment fetches the media of the passed in MMS part into our attachment store, returning an attachment for
+// it, if that fails we pass through Infobip's URL so the media isn't lost. Media hosted by our channel's Infobip API is
+// fetched with its credentials, which are never sent to other hosts.
+func (h *handler) receiveAttachment(ctx context.Context, channel courier.Channel, part ibMMSPart) string {
+	var authorize func(*http.Request) error
+	mediaURL, err := url.Parse(part.URL)
+	if err == nil {
+		apiURL, err := url.Parse(h.endpointURL(channel))
+		if err == nil && mediaURL.Host == apiURL.Host {
+			authorize = func(req *http.Request) error { return h.authorize(ctx, req, channel) }
+		}
+	}
+
+	attachment, err := handlers.FetchAttachment(ctx, h.Backend(), channel, part.URL, authorize)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("url", part.URL).Warning("unable to fetch infobip attachment")
+
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return fmt.Sprintf("%s:%s", contentType, part.URL)
+	}
+	return attachment
+}
+
+// the keywords carriers require us to treat as an opt out, channels can override these with opt_out_keywords
+var defaultOptOutKeywords = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT"}
+
+// isOptOut returns whether the passed in text is one of our channel's opt out keywords, ignoring case, surrounding
+// whitespace and trailing punctuation
+func isOptOut(channel courier.Channel, text string) bool {
+	keywords := defaultOptOutKeywords
+	switch configured := channel.ConfigForKey(configOptOutKeywords, nil).(type) {
+	case []string:
+		keywords = configured
+	case []interface{}:
+		keywords = make([]string, 0, len(configured))
+		for _, keyword := range configured {
+			if str, isStr := keyword.(string); isStr && str != "" {
+				keywords = append(keywords, str)
+			}
+		}
+	}
+
+	text = strings.TrimRight(strings.TrimSpace(text), ".!")
+	for _, keyword := range keywords {
+		if strings.EqualFold(text, strings.TrimSpace(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// infobipMessage is a single result of an incoming message callback. Results without a sender can't be attributed to
+// a contact so they fail validation, and are noted and skipped without rejecting the rest of the batch.
+type infobipMessage struct {
+	MessageID  string      `json:"messageId"`
+	From       string      `json:"from" validate:"required"`
+	To         string      `json:"to"`
+	Text       string      `json:"text"`
+	CleanText  string      `json:"cleanText"`
+	Keyword    string      `json:"keyword"`
+	ReceivedAt string      `json:"receivedAt"`
+	SmsCount   int         `json:"smsCount"`
+	UDH        string      `json:"udh"`
+	Message    []ibMMSPart `json:"message"`
+	SenderName string      `json:"senderName"`
+	Contact    struct {
+		Name string `json:"name"`
+	} `json:"contact"`
+}
+
+// contactName returns the display name of the sender of this message if Infobip gave us one, which messaging apps
+// include with the contact and some SMS accounts as the sender name
+func (m *infobipMessage) contactName() string {
+	if name := strings.TrimSpace(m.Contact.Name); name != "" {
+		return name
 	}
+	return strings.TrimSpace(m.SenderName)
+}
 
-	if len(msgs) == 0 {
-		return nil, courier.WriteIgnored(ctx, w, r, "ignoring request, no message")
-	}
+// ibQueryMessage is an incoming message delivered as GET parameters, e.g.
+//
+// /receive?from=385916242493&to=385921004026&text=Hello&messageId=817790313235066447&receivedAt=2016-10-06T09:28:39.220%2B0000
+type ibQueryMessage struct {
+	MessageID  string `name:"messageId"`
+	From       string `name:"from" validate:"required"`
+	To         string `name:"to"`
+	Text       string `name:"text"`
+	ReceivedAt string `name:"receivedAt"`
+}
 
-	return []courier.Event{msgs[0]}, courier.WriteMsgSuccess(ctx, w, r, msgs)
+// ibMMSPart is a part of an incoming MMS, either media at a URL, a location or text
+//
+// {
+// 	"contentType": "image/jpeg",
+// 	"url": "https://api.infobip.com/mms/1/content/ab3c5d"
+// }
+type ibMMSPart struct {
+	ContentType string   `json:"contentType"`
+	URL         string   `json:"url"`
+	Value       string   `json:"value"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
 }
 
-type infobipMessage struct {
-	MessageID  string `json:"messageId"`
-	From       string `json:"from" validate:"required"`
-	Text       string `json:"text"`
-	ReceivedAt string `json:"receivedAt"`
+// ibMsgPart is what we keep from a part of a concatenated message to write it once all parts have arrived
+type ibMsgPart struct {
+	channel    courier.Channel
+	urn        urns.URN
+	externalID string
+	date       time.Time
 }
 
 // {
@@ -171,62 +1322,264 @@ type infobipEnvelope struct {
 
 // SendMsg sends the passed in message, returning any error
 func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
+	status := h.checkSend(msg)
+	if status != nil {
+		return status, nil
+	}
 
-	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
-	if username == "" {
-		return nil, fmt.Errorf("no username set for IB channel")
-	}
-
-	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
-	if password == "" {
-		return nil, fmt.Errorf("no password set for IB channel")
-	}
-
-	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
-	statusURL := fmt.Sprintf("https://%s%s%s/delivered", callbackDomain, "/c/ib/", msg.Channel().UUID())
-
-	ibMsg := ibOutgoingEnvelope{
-		Messages: []ibOutgoingMessage{
-			ibOutgoingMessage{
-				From: msg.Channel().Address(),
-				Destinations: []ibDestination{
-					ibDestination{
-						To:        strings.TrimLeft(msg.URN().Path(), "+"),
-						MessageID: msg.ID().String(),
-					},
-				},
-				Text:               courier.GetTextAndAttachments(msg),
-				NotifyContentType:  "application/json",
-				IntermediateReport: true,
-				NotifyURL:          statusURL,
-			},
-		},
+	status, err := h.sendMsg(ctx, msg)
+	if status != nil {
+		h.RecordSend(msg.Channel(), status)
+		h.schedulePoll(msg, status)
+	}
+	return status, err
+}
+
+// checkSend returns the status of the passed in message if it shouldn't be sent, or nil if it should
+func (h *handler) checkSend(msg courier.Msg) courier.MsgStatus {
+	// messages which have waited too long to be sent are no longer worth sending
+	err := handlers.CheckExpired(msg, time.Now())
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+		status.AddLog(courier.NewChannelLog("Message Expired", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return status
+	}
+
+	// destinations our channel isn't allowed to send to fail without trying
+	err = handlers.CheckDestination(msg.Channel(), msg.URN())
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+		status.AddLog(courier.NewChannelLog("Destination Blocked", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return status
+	}
+
+	// channels which keep failing, e.g. because their credentials were revoked, are given a rest
+	if !h.SendAllowed(msg.Channel()) {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+		status.AddLog(courier.NewChannelLog("Circuit Open", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, errors.New("not sending, too many consecutive sends on this channel have failed")))
+		return status
+	}
+
+	// contacts we've already sent too many messages to recently aren't sent any more, e.g. if we're in a loop
+	err = h.CheckRecipientRate(msg.Channel(), msg.URN())
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+		status.AddLog(courier.NewChannelLog("Recipient Rate Limited", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return status
+	}
+
+	return nil
+}
+
+// sendMsg makes our actual send to Infobip
+func (h *handler) sendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
+	err := checkCredentials(msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	// OTP channels have Infobip generate and send their PINs
+	if flagChannel.Get(msg.Channel()) == channelOTP {
+		return h.sendOTP(ctx, msg)
+	}
+
+	// if our channel has a list of callback domains, have delivery reports sent to whichever is up
+	callbackDomain := h.callbackDomains.Select(msg.Channel(), h.Server().Config().Domain)
+	statusURL := deliveredURL(callbackDomain, msg.Channel())
+
+	text, err := outgoingText(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	from := msg.Channel().Address()
+	mode := sendModeText
+	correlationID := newCorrelationID()
+	campaignReference := ""
+	var payload interface{}
+
+	// WhatsApp and Viber go through the omnichannel API, everything else is an SMS
+	channelType := flagChannel.Get(msg.Channel())
+	if channelType == channelWhatsApp || channelType == channelViber {
+		scenarioKey := flagScenarioKey.Get(msg.Channel())
+		if scenarioKey == "" {
+			return nil, fmt.Errorf("no scenario key set for IB %s channel", channelType)
+		}
+
+		mode = sendModeOmni
+		envelope := newOmniEnvelope(msg, h.FormatPhone(msg), channelType, scenarioKey, text, statusURL)
+		envelope.CallbackData = correlationID
+		if envelope.WhatsApp != nil && envelope.WhatsApp.TemplateName == "" && len(msg.Attachments()) > 0 {
+			attachWhatsAppMedia(ctx, msg, envelope.WhatsApp)
+		}
+		payload = envelope
+	} else {
+		ibMsg, failed, err := h.newSMSMessage(msg, text, callbackDomain, correlationID)
+		if err != nil {
+			return nil, err
+		}
+		if failed != nil {
+			return failed, nil
+		}
+
+		from = ibMsg.From
+		if ibMsg.Binary != nil {
+			mode = sendModeBinary
+		}
+		campaignReference = ibMsg.CampaignReference
+		payload = ibOutgoingEnvelope{Messages: []ibOutgoingMessage{*ibMsg}}
+
+		// operators can pass through fields we don't model yet
+		extraParams, _ := msg.Channel().ConfigForKey(configExtraParams, nil).(map[string]interface{})
+		if len(extraParams) > 0 {
+			merged, err := mergeExtraParams(*ibMsg, extraParams)
+			if err != nil {
+				return nil, err
+			}
+			payload = map[string]interface{}{"messages": []interface{}{merged}}
+		}
+	}
+
+	// a channel messaging its own number can loop forever, so fail these without sending
+	err = handlers.CheckNotSelf(msg.Channel(), msg.URN(), from)
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+		status.AddLog(courier.NewChannelLog("Send To Self", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return status, nil
 	}
 
 	requestBody := &bytes.Buffer{}
-	err := json.NewEncoder(requestBody).Encode(ibMsg)
+	err = json.NewEncoder(requestBody).Encode(payload)
 	if err != nil {
 		return nil, err
 	}
 
-	// build our request
-	req, err := http.NewRequest(http.MethodPost, sendURL, requestBody)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	req.SetBasicAuth(username, password)
-	rr, err := utils.MakeHTTPRequest(req)
+	// channels using OAuth need a current access token, without one we can't send for now
+	authorization, err := h.authorization(ctx, msg.Channel())
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+		status.SetCorrelationID(correlationID)
+		status.AddLog(courier.NewChannelLog("Access Token Error", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err).WithCorrelationID(correlationID))
+		return status, nil
+	}
+
+	// build our request, channels may have us retry it
+	postURL := h.sendURL(msg.Channel(), mode)
+	rr, err := handlers.MakeSendRequest(ctx, msg.Channel(), h.sendOptions(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, postURL, bytes.NewReader(requestBody.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		setAuthorization(req, msg.Channel())
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return req, nil
+	})
+
+	h.DebugHTTP(msg.Channel(), rr, configClientSecret)
+
+	// a token Infobip no longer accepts, e.g. because it was revoked, is replaced on our next send
+	if authorization != "" && rr != nil && rr.StatusCode == http.StatusUnauthorized {
+		h.tokens.Forget(msg.Channel())
+	}
+
+	// without a response we couldn't even build our request, so there's nothing to log but our error
+	if rr == nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+		status.SetCorrelationID(correlationID)
+		status.AddLog(courier.NewChannelLog("Message Send Error", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err).WithCorrelationID(correlationID))
+		return status, nil
+	}
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
-	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr)
+	status.SetCorrelationID(correlationID)
+	status.SetCampaignReference(campaignReference)
+
+	// any attempts we retried get their own logs, tied to the rest of this message's lifecycle
+	for _, attempt := range rr.Retried {
+		status.AddLog(courier.NewChannelLogFromRR("Message Send Retried", msg.Channel(), msg.ID(), attempt).
+			WithCorrelationID(correlationID).WithError("Message Send Retried", attemptError(attempt)))
+	}
+
+	log := courier.NewChannelLogFromRR(sentDescription(msg, from, 0, ""), msg.Channel(), msg.ID(), rr).WithCorrelationID(correlationID)
 	status.AddLog(log)
 	if err != nil {
+		// gateways in front of Infobip can answer with HTML error pages, which tell us more than our status code alone
+		if nonJSONErr := nonJSONResponse(rr); nonJSONErr != nil && err != handlers.ErrSendDeadlineExceeded {
+			err = nonJSONErr
+		}
 		log.WithError("Message Send Error", err)
+
+		// timeouts and connection failures may be mapped to a different status by our channel, but sends which ran out
+		// of time across all their retries are always errored
+		if err == handlers.ErrSendDeadlineExceeded {
+			status.SetStatus(courier.MsgErrored)
+		} else {
+			status.SetStatus(handlers.StatusForRequestError(msg.Channel(), rr))
+		}
+
+		// if we were rate limited, let our backend know when to try again
+		if rr.RetryAfter > 0 {
+			status.SetRetryAfter(rr.RetryAfter)
+		}
+		return status, nil
+	}
+
+	// a successful status with a body we can't parse didn't come from Infobip, so we can't know if it was sent
+	if nonJSONErr := nonJSONResponse(rr); nonJSONErr != nil {
+		log.WithError("Message Send Error", nonJSONErr)
+		return status, nil
+	}
+
+	// likewise one which is too big for us to have read it all
+	if rr.BodyTruncated {
+		log.WithError("Message Send Error", errors.Errorf("response body longer than %d bytes", sendMaxBodyBytes))
+		return status, nil
+	}
+
+	// Infobip support correlate sends by their bulk id so record it when we are given one, as it is shared by every
+	// message in a request it goes in our metadata, our external id being the id Infobip has for this message
+	bulkID, _ := jsonparser.GetString([]byte(rr.Body), "bulkId")
+	if bulkID != "" {
+		status.SetMetadata("bulk_id", bulkID)
+		log.Description = sentDescription(msg, from, 0, bulkID)
+	}
+	echoedID, _, _, _ := jsonparser.Get([]byte(rr.Body), "messages", "[0]", "messageId")
+	externalID := externalIDForMessage(msg, messageIDForMsg(msg), string(echoedID), log)
+	if externalID != "" {
+		status.SetExternalID(externalID)
+	}
+
+	// Infobip can report request errors with a 200, these won't succeed on retry so fail the message
+	exceptionID, exceptionText, found := serviceException([]byte(rr.Body))
+	if found {
+		log.WithError("Message Send Error", errors.Errorf("received service exception %s: %s", exceptionID, exceptionText))
+		status.SetStatus(courier.MsgFailed)
+		return status, nil
+	}
+
+	// destinations on Infobip's blacklist or a do not disturb register will never be delivered to, so we don't retry them
+	statusID, _ := jsonparser.GetInt([]byte(rr.Body), "messages", "[0]", "status", "id")
+	statusName, _ := jsonparser.GetString([]byte(rr.Body), "messages", "[0]", "status", "name")
+	if isBlacklisted(statusID, statusName) {
+		log.WithError("Destination Blacklisted", errors.Errorf("destination rejected by Infobip as blacklisted: %s", statusName))
+		status.SetStatus(courier.MsgFailed)
 		return status, nil
 	}
 
 	groupID, err := jsonparser.GetInt([]byte(rr.Body), "messages", "[0]", "status", "groupId")
-	if err != nil || (groupID != 1 && groupID != 3) {
+	if err != nil || !successGroupIDs(msg.Channel())[groupID] {
 		log.WithError("Message Send Error", errors.Errorf("received error status: '%d'", groupID))
 		return status, nil
 	}
@@ -235,6 +1588,264 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	return status, nil
 }
 
+// sentDescription returns the description of the log of sending the passed in msg from the passed in sender, in a bulk
+// request of the passed in size, 0 if it was sent on its own, which Infobip gave the passed in bulk id, if any
+func sentDescription(msg courier.Msg, from string, bulkSize int, bulkID string) string {
+	description := "Message Sent"
+	if bulkSize > 0 {
+		description = fmt.Sprintf("Message Sent in Bulk of %d", bulkSize)
+	}
+	if from != msg.Channel().Address() {
+		description = fmt.Sprintf("%s from %s", description, from)
+	}
+	if msg.Priority() != courier.MsgPriorityNormal {
+		description = fmt.Sprintf("%s (%s priority)", description, msg.Priority())
+	}
+	if bulkID != "" {
+		description = fmt.Sprintf("%s [bulk %s]", description, bulkID)
+	}
+	return description
+}
+
+// externalIDForMessage returns the external id we record for the passed in message, which we sent with the passed in
+// message id and Infobip echoed back as the other. Some accounts have Infobip reassign the ids we send with, which their
+// delivery reports then use, so we note when that happens on the passed in log and record theirs unless our channel
+// ignores them, in which case we keep ours.
+func externalIDForMessage(msg courier.Msg, sentID string, echoedID string, log *courier.ChannelLog) string {
+	if sentID == "" || echoedID == "" || echoedID == sentID {
+		return echoedID
+	}
+
+	log.Description = fmt.Sprintf("%s [message id %s reassigned to %s]", log.Description, sentID, echoedID)
+	logrus.WithField("channel_uuid", msg.Channel().UUID()).WithField("msg_id", msg.ID().String()).
+		WithField("message_id", sentID).WithField("reassigned_id", echoedID).Warning("infobip reassigned message id")
+
+	if flagReassignedIDs.Get(msg.Channel()) == reassignedIDsIgnore {
+		return sentID
+	}
+	return echoedID
+}
+
+// outgoingText returns the text we send for the passed in message, with its attachments, templates and any of the
+// replacements or transliteration its channel wants
+func outgoingText(msg courier.Msg) (string, error) {
+	text, err := handlers.ApplyTextTemplates(msg, courier.GetTextAndAttachments(msg))
+	if err != nil {
+		return "", err
+	}
+
+	// some characters make Infobip split or reject messages, channels can have us replace or strip them
+	replacer, err := charReplacer(msg.Channel())
+	if err != nil {
+		return "", err
+	}
+	if replacer != nil {
+		text = replacer.Replace(text)
+	}
+
+	// some channels transliterate here rather than leave it to Infobip so they know they'll be billed for GSM7 segments
+	forceGSM := flagForceGSM.Get(msg.Channel())
+	if forceGSM {
+		text = handlers.TransliterateToGSM7(text)
+	}
+	return text, nil
+}
+
+// newSMSMessage builds the SMS we send to Infobip for the passed in message, which has binary content if its channel
+// needs it. If the message can't be sent, e.g. because it is too long, a failed status is returned instead.
+func (h *handler) newSMSMessage(msg courier.Msg, text string, callbackDomain string, correlationID string) (*ibOutgoingMessage, courier.MsgStatus, error) {
+	from := senderForMsg(msg)
+
+	// carriers silently drop messages from alphanumeric senders which are too long, channels can have us fail
+	// these rather than send them, or send them from the truncated sender
+	if isAlphanumericSender(from) && utf8.RuneCountInString(from) > maxAlphanumericSender {
+		switch flagLongSender.Get(msg.Channel()) {
+		case longSenderReject:
+			err := fmt.Errorf("alphanumeric sender '%s' is longer than %d characters", from, maxAlphanumericSender)
+			status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+			status.AddLog(courier.NewChannelLog("Sender Rejected", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+				"", "", 0, err))
+			return nil, status, nil
+		case longSenderTruncate:
+			truncated := string([]rune(from)[:maxAlphanumericSender])
+			logrus.WithField("channel_uuid", msg.Channel().UUID()).WithField("sender", from).WithField("truncated", truncated).Warning("truncating long alphanumeric sender")
+			from = truncated
+		}
+	}
+
+	encoding, segments := handlers.CountSegments(text)
+	logrus.WithField("channel_uuid", msg.Channel().UUID()).WithField("msg_id", msg.ID().String()).WithField("encoding", encoding).WithField("segments", segments).Debug("sending infobip message")
+
+	// channels can limit how many segments their messages are sent as to control costs, longer ones fail
+	limit := flagMaxSegments.Get(msg.Channel())
+	if limit > 0 && segments > limit {
+		err := fmt.Errorf("message is %d %s segments, more than the %d allowed", segments, encoding, limit)
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgFailed)
+		status.AddLog(courier.NewChannelLog("Message Too Long", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return nil, status, nil
+	}
+
+	ibMsg := &ibOutgoingMessage{
+		From: from,
+		Destinations: []ibDestination{
+			ibDestination{
+				To:        h.FormatPhone(msg),
+				MessageID: messageIDForMsg(msg),
+			},
+		},
+		Text:               text,
+		NotifyContentType:  notifyContentType(msg.Channel()),
+		IntermediateReport: true,
+		NotifyURL:          deliveredURL(callbackDomain, msg.Channel()),
+		CallbackData:       correlationID,
+	}
+
+	// binary channels send our payload as hex to the binary endpoint instead of as text
+	binary := flagBinary.Get(msg.Channel())
+	if binary {
+		ibMsg.Text = ""
+		ibMsg.Binary = &ibBinary{
+			Hex:        hex.EncodeToString([]byte(text)),
+			DataCoding: dataCodingBinary,
+		}
+	}
+
+	// some carriers need an explicit data coding scheme, which we can only set by sending binary content
+	dataCoding, err := dataCodingForMsg(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dataCoding != "" {
+		ibMsg.Text = ""
+		ibMsg.Binary = encodeBinary(text, dataCoding)
+	}
+
+	// if this message is scheduled for the future, have Infobip hold it until then
+	sendAt := msg.SendAt()
+	if sendAt != nil && sendAt.After(time.Now()) {
+		ibMsg.SendAt = sendAt.UTC().Format(sendAtFormat)
+	}
+
+	// some regulators (e.g. India's DLT) require messages to be sent against a registered application and entity
+	ibMsg.ApplicationID = flagApplicationID.Get(msg.Channel())
+	ibMsg.EntityID = flagEntityID.Get(msg.Channel())
+
+	// campaign channels can have Infobip shorten and track the links in their messages
+	ibMsg.URLOptions = urlOptionsForChannel(msg.Channel(), clickedURL(callbackDomain, msg.Channel()))
+
+	// tag our send with its campaign so Infobip can echo it back on delivery reports
+	ibMsg.CampaignReference = campaignReferenceForMsg(msg)
+
+	return ibMsg, nil, nil
+}
+
+// the id of Infobip's REJECTED_DND status, for destinations on a do not disturb register
+const statusIDRejectedDND = 10
+
+// isBlacklisted returns whether the passed in Infobip status id and name are for a rejection because the destination
+// is blacklisted, either on Infobip's own blacklist or a do not disturb register
+func isBlacklisted(id int64, name string) bool {
+	return id == statusIDRejectedDND || strings.Contains(name, "BLACKLIST") || strings.HasSuffix(name, "_DND")
+}
+
+// the status group ids which mean Infobip has accepted a send, PENDING and DELIVERED
+var defaultSuccessGroupIDs = map[int64]bool{1: true, 3: true}
+
+// successGroupIDs returns the status group ids which mean a send on the passed in channel was accepted, accounts with
+// different acknowledgement semantics can configure their own
+func successGroupIDs(channel courier.Channel) map[int64]bool {
+	groupIDs := map[int64]bool{}
+	switch ids := channel.ConfigForKey(configSuccessGroupIDs, nil).(type) {
+	case []int:
+		for _, id := range ids {
+			groupIDs[int64(id)] = true
+		}
+	case []interface{}:
+		for _, id := range ids {
+			if num, isNum := id.(float64); isNum {
+				groupIDs[int64(num)] = true
+			}
+		}
+	}
+
+	if len(groupIDs) == 0 {
+		return defaultSuccessGroupIDs
+	}
+	return groupIDs
+}
+
+// attemptError returns why the passed in send attempt was retried
+func attemptError(attempt *utils.RequestResponse) error {
+	if attempt.StatusCode == 0 {
+		return errors.Errorf("no response received: %s", attempt.Body)
+	}
+	return errors.Errorf("received non 200 status: %d", attempt.StatusCode)
+}
+
+// how much of a response we can't parse we include in our error
+const maxBodySnippet = 200
+
+// nonJSONResponse returns an error describing the passed in response if Infobip's API answered it with a body which
+// isn't JSON, e.g. an HTML error page from a gateway, or nil if it didn't
+func nonJSONResponse(rr *utils.RequestResponse) error {
+	if rr == nil || rr.StatusCode == 0 {
+		return nil
+	}
+
+	// we parse responses leniently, so only treat bodies which don't even start like JSON as not being JSON
+	body := bytes.TrimSpace(rr.Body)
+	if len(body) == 0 || body[0] == '{' || body[0] == '[' {
+		return nil
+	}
+
+	snippet := []rune(string(body))
+	if len(snippet) > maxBodySnippet {
+		snippet = append(snippet[:maxBodySnippet], []rune("...")...)
+	}
+	return errors.Errorf("received non-JSON response with status %d: %s", rr.StatusCode, string(snippet))
+}
+
+// deliveredURL returns the URL Infobip should post the delivery reports for messages sent on the passed in channel to,
+// each message we send carries its own so reports always come back to the channel which sent it
+func deliveredURL(callbackDomain string, channel courier.Channel) string {
+	return handlers.AddCallbackToken(fmt.Sprintf("https://%s/c/ib/%s/delivered", callbackDomain, channel.UUID()), channel)
+}
+
+// isAlphanumericSender returns whether the passed in sender is alphanumeric rather than a number
+func isAlphanumericSender(sender string) bool {
+	for _, r := range strings.TrimPrefix(sender, "+") {
+		if r < '0' || r > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// senderForMsg returns the sender we should use for the passed in message. If the channel has a pool of senders
+// configured we pick one by hashing the destination so a given contact always sees the same sender.
+func senderForMsg(msg courier.Msg) string {
+	pool := []string{}
+	switch senders := msg.Channel().ConfigForKey(configSenderPool, nil).(type) {
+	case []string:
+		pool = senders
+	case []interface{}:
+		for _, sender := range senders {
+			if str, isStr := sender.(string); isStr && str != "" {
+				pool = append(pool, str)
+			}
+		}
+	}
+
+	if len(pool) == 0 {
+		return msg.Channel().Address()
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(msg.URN().Path()))
+	return pool[hash.Sum32()%uint32(len(pool))]
+}
+
 // {
 // 	"bulkId":"BULK-ID-123-xyz",
 // 	"messages":[
@@ -259,12 +1870,14 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 // 		"notifyUrl":"http://www.example.com/sms/advanced",
 // 		"notifyContentType":"application/json",
 // 		"callbackData":"DLR callback data",
-// 		"validityPeriod": 720
+// 		"validityPeriod": 720,
+// 		"sendAt": "2015-07-07T17:00:00.000+0000"
 // 	  }
 // 	]
 // }
 //
-// API docs from https://dev.infobip.com/docs/fully-featured-textual-message
+// API docs from https://dev.infobip.com/docs/fully-featured-textual-message and
+// https://dev.infobip.com/docs/fully-featured-binary-message for binary channels
 
 type ibOutgoingEnvelope struct {
 	Messages []ibOutgoingMessage `json:"messages"`
@@ -273,13 +1886,244 @@ type ibOutgoingEnvelope struct {
 type ibOutgoingMessage struct {
 	From               string          `json:"from"`
 	Destinations       []ibDestination `json:"destinations"`
-	Text               string          `json:"text"`
+	Text               string          `json:"text,omitempty"`
+	Binary             *ibBinary       `json:"binary,omitempty"`
 	NotifyContentType  string          `json:"notifyContentType"`
 	IntermediateReport bool            `json:"intermediateReport"`
 	NotifyURL          string          `json:"notifyUrl"`
+	SendAt             string          `json:"sendAt,omitempty"`
+	ApplicationID      string          `json:"applicationId,omitempty"`
+	EntityID           string          `json:"entityId,omitempty"`
+	CallbackData       string          `json:"callbackData,omitempty"`
+	URLOptions         *ibURLOptions   `json:"urlOptions,omitempty"`
+	CampaignReference  string          `json:"campaignReferenceId,omitempty"`
+}
+
+// mergeExtraParams merges the passed in extra params into the JSON of our outgoing message, fields we set ourselves
+// are never overwritten
+func mergeExtraParams(ibMsg ibOutgoingMessage, extraParams map[string]interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(ibMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]interface{})
+	err = json.Unmarshal(encoded, &merged)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range extraParams {
+		if _, found := merged[key]; !found && !requiredOutgoingFields[key] {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// fields of our outgoing message which extra params can't set, even when we leave them out
+var requiredOutgoingFields = map[string]bool{
+	"from":         true,
+	"destinations": true,
+	"text":         true,
+	"binary":       true,
+}
+
+// serviceException returns the message id and text of any service exception in the passed in response body,
+// which Infobip may return at the top level or inside a requestError
+//
+// {
+// 	"requestError": {
+// 	  "serviceException": {
+// 		"messageId": "UNAUTHORIZED",
+// 		"text": "Invalid login details"
+// 	  }
+// 	}
+// }
+func serviceException(body []byte) (string, string, bool) {
+	for _, path := range [][]string{{"requestError", "serviceException"}, {"serviceException"}} {
+		exception, dataType, _, err := jsonparser.Get(body, path...)
+		if err != nil || dataType != jsonparser.Object {
+			continue
+		}
+
+		messageID, _ := jsonparser.GetString(exception, "messageId")
+		text, _ := jsonparser.GetString(exception, "text")
+		return messageID, text, true
+	}
+	return "", "", false
+}
+
+// dataCodingForMsg returns the data coding the passed in message should be sent with, if any, a data_coding in the
+// message's metadata takes precedence over the one configured on its channel
+func dataCodingForMsg(msg courier.Msg) (string, error) {
+	dataCoding, _ := jsonparser.GetString(msg.Metadata(), configDataCoding)
+	if dataCoding == "" {
+		dataCoding = flagDataCoding.Get(msg.Channel())
+	}
+	if _, found := dataCodings[dataCoding]; dataCoding != "" && !found {
+		return "", fmt.Errorf("unknown data coding '%s' for IB message", dataCoding)
+	}
+	return dataCoding, nil
+}
+
+// campaignReferenceForMsg returns the campaign reference the passed in message should be tagged with, if any, a
+// campaign_reference in the message's metadata takes precedence over the one configured on its channel
+func campaignReferenceForMsg(msg courier.Msg) string {
+	campaignReference, _ := jsonparser.GetString(msg.Metadata(), configCampaignReference)
+	if campaignReference == "" {
+		campaignReference = flagCampaignReference.Get(msg.Channel())
+	}
+	return campaignReference
+}
+
+// encodeBinary encodes the passed in text as the hex binary content of the passed in data coding
+func encodeBinary(text string, dataCoding string) *ibBinary {
+	var content []byte
+	switch dataCodings[dataCoding] {
+	case dataCodingGSM7:
+		content = handlers.EncodeGSM7(text)
+	case dataCodingUCS2:
+		for _, unit := range utf16.Encode([]rune(text)) {
+			content = append(content, byte(unit>>8), byte(unit))
+		}
+	default:
+		content = []byte(text)
+	}
+	return &ibBinary{Hex: hex.EncodeToString(content), DataCoding: dataCodings[dataCoding]}
+}
+
+type ibBinary struct {
+	Hex        string `json:"hex"`
+	DataCoding int    `json:"dataCoding"`
 }
 
 type ibDestination struct {
 	To        string `json:"to"`
-	MessageID string `json:"messageId"`
+	MessageID string `json:"messageId,omitempty"`
+}
+
+// notifyContentType returns the content type we ask Infobip to post delivery reports to us as
+func notifyContentType(channel courier.Channel) string {
+	if flagNotifyContentType.Get(channel) == contentTypeXML {
+		return contentTypeXML
+	}
+	return contentTypeJSON
+}
+
+// newOmniEnvelope builds the omnichannel API payload for sending the passed in message over WhatsApp or Viber. If
+// the channel has a WhatsApp template configured we send a template message with our text as its only parameter.
+func newOmniEnvelope(msg courier.Msg, to string, channelType string, scenarioKey string, text string, statusURL string) *ibOmniEnvelope {
+	envelope := &ibOmniEnvelope{
+		ScenarioKey: scenarioKey,
+		Destinations: []ibOmniDestination{
+			ibOmniDestination{
+				MessageID: messageIDForMsg(msg),
+				To:        ibOmniTo{PhoneNumber: to},
+			},
+		},
+		NotifyContentType:  notifyContentType(msg.Channel()),
+		IntermediateReport: true,
+		NotifyURL:          statusURL,
+	}
+
+	if channelType == channelViber {
+		envelope.Viber = &ibViberMessage{Text: text}
+		return envelope
+	}
+
+	template := flagWhatsAppTemplate.Get(msg.Channel())
+	if template != "" {
+		envelope.WhatsApp = &ibWhatsAppMessage{
+			TemplateName: template,
+			TemplateData: []string{text},
+			Language:     flagWhatsAppLanguage.Get(msg.Channel()),
+		}
+	} else {
+		envelope.WhatsApp = &ibWhatsAppMessage{Text: text}
+	}
+	return envelope
+}
+
+// {
+// 	"scenarioKey": "CD0C4E8E3E4C5B0FB8F6B1C10D0A7E85",
+// 	"destinations": [
+// 	  {
+// 		"messageId": "MESSAGE-ID-123-xyz",
+// 		"to": {
+// 		  "phoneNumber": "41793026727"
+// 		}
+// 	  }
+// 	],
+// 	"whatsApp": {
+// 	  "templateName": "account_balance",
+// 	  "templateData": ["100"],
+// 	  "language": "en"
+// 	},
+// 	"notifyUrl": "http://www.example.com/omni/advanced",
+// 	"notifyContentType": "application/json",
+// 	"intermediateReport": true
+// }
+//
+// API docs from https://dev.infobip.com/docs/omnichannel-advanced
+
+type ibOmniEnvelope struct {
+	ScenarioKey        string              `json:"scenarioKey"`
+	Destinations       []ibOmniDestination `json:"destinations"`
+	WhatsApp           *ibWhatsAppMessage  `json:"whatsApp,omitempty"`
+	Viber              *ibViberMessage     `json:"viber,omitempty"`
+	NotifyContentType  string              `json:"notifyContentType"`
+	IntermediateReport bool                `json:"intermediateReport"`
+	NotifyURL          string              `json:"notifyUrl"`
+	CallbackData       string              `json:"callbackData,omitempty"`
+}
+
+type ibOmniDestination struct {
+	MessageID string   `json:"messageId,omitempty"`
+	To        ibOmniTo `json:"to"`
+}
+
+type ibOmniTo struct {
+	PhoneNumber string `json:"phoneNumber"`
+}
+
+type ibWhatsAppMessage struct {
+	Text         string   `json:"text,omitempty"`
+	ImageURL     string   `json:"imageUrl,omitempty"`
+	AudioURL     string   `json:"audioUrl,omitempty"`
+	VideoURL     string   `json:"videoUrl,omitempty"`
+	FileURL      string   `json:"fileUrl,omitempty"`
+	TemplateName string   `json:"templateName,omitempty"`
+	TemplateData []string `json:"templateData,omitempty"`
+	Language     string   `json:"language,omitempty"`
+}
+
+// attachWhatsAppMedia sends the first attachment of the passed in message as media in the passed in WhatsApp message,
+// rather than as a link in its text. Attachment URLs don't reliably tell us what they are, so we sniff the content type
+// to pick the kind of media. Attachments we can't identify are left as links.
+func attachWhatsAppMedia(ctx context.Context, msg courier.Msg, whatsApp *ibWhatsAppMessage) {
+	_, url := courier.SplitAttachment(msg.Attachments()[0])
+	contentType, err := handlers.SniffContentType(ctx, url)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID()).WithField("url", url).Warning("unable to determine infobip attachment type, sending as link")
+		return
+	}
+
+	switch strings.Split(contentType, "/")[0] {
+	case "image":
+		whatsApp.ImageURL = url
+	case "audio":
+		whatsApp.AudioURL = url
+	case "video":
+		whatsApp.VideoURL = url
+	default:
+		whatsApp.FileURL = url
+	}
+
+	// our text no longer needs the link
+	whatsApp.Text = strings.TrimSpace(strings.Replace("\n"+whatsApp.Text, "\n"+url, "", 1))
+}
+
+type ibViberMessage struct {
+	Text string `json:"text"`
 }
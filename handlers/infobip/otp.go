@@ -0,0 +1,233 @@
+package infobip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// OTP channels send through Infobip's 2FA API, which generates the PIN itself and sends it using a message template
+// set up on a 2FA application. The PIN id Infobip gives us is the external id of our msg and is what callers pass to
+// our verify route along with the PIN the contact entered.
+
+var otpPinURL = "https://api.infobip.com/2fa/2/pin"
+var otpVerifyURL = "https://api.infobip.com/2fa/2/pin/%s/verify"
+var otpApplicationsURL = "https://api.infobip.com/2fa/2/applications"
+
+const configOTPApplicationID = "otp_application_id"
+const configOTPMessageID = "otp_message_id"
+
+// the SMS status Infobip gives us when our PIN was sent
+const otpMessageSent = "MESSAGE_SENT"
+
+// checkOTPConfig returns an error if the passed in OTP channel is missing its 2FA application or message template
+func checkOTPConfig(channel courier.Channel) error {
+	if flagOTPApplicationID.Get(channel) == "" {
+		return fmt.Errorf("no OTP application id set for IB otp channel")
+	}
+	if flagOTPMessageID.Get(channel) == "" {
+		return fmt.Errorf("no OTP message id set for IB otp channel")
+	}
+	return nil
+}
+
+// verifyOTPTemplate checks that the message template configured on the passed in OTP channel exists on its application
+func (h *handler) verifyOTPTemplate(ctx context.Context, channel courier.Channel) error {
+	templateURL := fmt.Sprintf("%s/%s/messages/%s", otpApplicationsURL,
+		flagOTPApplicationID.Get(channel), flagOTPMessageID.Get(channel))
+
+	req, err := http.NewRequest(http.MethodGet, templateURL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	err = h.authorize(ctx, req, channel)
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.MakeHTTPRequest(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to find OTP message template for IB channel")
+	}
+	return nil
+}
+
+// sendOTP asks Infobip to generate and send a PIN to the contact of the passed in msg, our text is ignored as the
+// message template decides what is sent
+func (h *handler) sendOTP(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
+	err := checkOTPConfig(msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	pin := &ibOTPPin{
+		ApplicationID: flagOTPApplicationID.Get(msg.Channel()),
+		MessageID:     flagOTPMessageID.Get(msg.Channel()),
+		From:          msg.Channel().Address(),
+		To:            h.FormatPhone(msg),
+	}
+
+	requestBody := &bytes.Buffer{}
+	err = json.NewEncoder(requestBody).Encode(pin)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, otpPinURL, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	err = h.authorize(ctx, req, msg.Channel())
+	if err != nil {
+		status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+		status.AddLog(courier.NewChannelLog("Access Token Error", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
+			"", "", 0, err))
+		return status, nil
+	}
+	rr, err := utils.MakeHTTPRequest(req)
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("OTP Sent", msg.Channel(), msg.ID(), rr)
+	status.AddLog(log)
+	if err != nil {
+		log.WithError("OTP Send Error", err)
+		status.SetStatus(handlers.StatusForRequestError(msg.Channel(), rr))
+		return status, nil
+	}
+
+	response := &ibOTPPinResponse{}
+	err = json.Unmarshal(rr.Body, response)
+	if err != nil {
+		log.WithError("OTP Send Error", errors.Wrap(err, "unable to parse response"))
+		return status, nil
+	}
+
+	if response.PinID == "" || response.SMSStatus != otpMessageSent {
+		log.WithError("OTP Send Error", errors.Errorf("received sms status: '%s'", response.SMSStatus))
+		return status, nil
+	}
+
+	status.SetExternalID(response.PinID)
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// VerifyPIN checks a PIN entered by a contact against the PIN Infobip sent them, returning whether it matched
+//
+// {
+//   "pin_id": "9C817C6F8AF3D48F9FE553282AFA2B67",
+//   "pin": "1598"
+// }
+func (h *handler) VerifyPIN(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	verify := &otpVerifyRequest{}
+	err := handlers.DecodeAndValidateJSON(verify, r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	err = checkCredentials(channel)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	requestBody := &bytes.Buffer{}
+	err = json.NewEncoder(requestBody).Encode(&ibOTPVerify{PIN: verify.PIN})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(otpVerifyURL, verify.PinID), requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	err = h.authorize(ctx, req, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error verifying PIN with IB")
+	}
+
+	response := &ibOTPVerifyResponse{}
+	err = json.Unmarshal(rr.Body, response)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse IB verify response")
+	}
+
+	message := "PIN Not Verified"
+	if response.Verified {
+		message = "PIN Verified"
+	}
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, message, &otpVerifyData{
+		PinID:             verify.PinID,
+		Verified:          response.Verified,
+		AttemptsRemaining: response.AttemptsRemaining,
+	})
+}
+
+type otpVerifyRequest struct {
+	PinID string `json:"pin_id" validate:"required"`
+	PIN   string `json:"pin"    validate:"required"`
+}
+
+type otpVerifyData struct {
+	PinID             string `json:"pin_id"`
+	Verified          bool   `json:"verified"`
+	AttemptsRemaining int    `json:"attempts_remaining"`
+}
+
+// {
+//   "applicationId": "HJ675435E3A6EA43432G5F37A635KJ8B",
+//   "messageId": "0130269F44AFD07AEBC2FEFEB30398A0",
+//   "from": "InfoSMS",
+//   "to": "41793026727"
+// }
+type ibOTPPin struct {
+	ApplicationID string `json:"applicationId"`
+	MessageID     string `json:"messageId"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+}
+
+// {
+//   "pinId": "9C817C6F8AF3D48F9FE553282AFA2B67",
+//   "to": "41793026727",
+//   "ncStatus": "NC_DESTINATION_REACHABLE",
+//   "smsStatus": "MESSAGE_SENT"
+// }
+type ibOTPPinResponse struct {
+	PinID     string `json:"pinId"`
+	SMSStatus string `json:"smsStatus"`
+}
+
+type ibOTPVerify struct {
+	PIN string `json:"pin"`
+}
+
+// {
+//   "pinId": "9C817C6F8AF3D48F9FE553282AFA2B67",
+//   "msisdn": "41793026727",
+//   "verified": true,
+//   "attemptsRemaining": 0
+// }
+type ibOTPVerifyResponse struct {
+	Verified          bool `json:"verified"`
+	AttemptsRemaining int  `json:"attemptsRemaining"`
+}
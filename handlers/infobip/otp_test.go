@@ -0,0 +1,133 @@
+package infobip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+var otpChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "InfoSMS", "US",
+	map[string]interface{}{
+		courier.ConfigPassword: "Password",
+		courier.ConfigUsername: "Username",
+		"channel":              "otp",
+		"otp_application_id":   "HJ675435E3A6EA43432G5F37A635KJ8B",
+		"otp_message_id":       "0130269F44AFD07AEBC2FEFEB30398A0",
+	})
+
+// setOTPURLs points our 2FA API URLs at the passed in server
+func setOTPURLs(server *TestProviderServer) {
+	otpPinURL = server.URL + "/2fa/2/pin"
+	otpVerifyURL = server.URL + "/2fa/2/pin/%s/verify"
+	otpApplicationsURL = server.URL + "/2fa/2/applications"
+}
+
+func TestOTPSend(t *testing.T) {
+	mb := courier.NewMockBackend()
+	mb.AddChannel(otpChannel)
+	handler := NewHandler()
+	handler.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/2fa/2/pin": MockResponse{Status: 200, Body: `{"pinId":"9C817C6F8AF3D48F9FE553282AFA2B67","to":"250788383383","ncStatus":"NC_DESTINATION_REACHABLE","smsStatus":"MESSAGE_SENT"}`},
+	})
+	defer server.Close()
+	setOTPURLs(server)
+
+	msg := mb.NewOutgoingMsg(otpChannel, courier.NewMsgID(10), urns.URN("tel:+250788383383"), "Your code is", true, nil)
+	status, err := handler.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, status.Status())
+	assert.Equal(t, "9C817C6F8AF3D48F9FE553282AFA2B67", status.ExternalID())
+	assert.Equal(t, "OTP Sent", status.Logs()[0].Description)
+
+	request := server.LastRequest()
+	assert.Equal(t, "POST", request.Method)
+	assert.Equal(t, "Basic VXNlcm5hbWU6UGFzc3dvcmQ=", request.Headers.Get("Authorization"))
+
+	pin := &ibOTPPin{}
+	assert.NoError(t, json.Unmarshal([]byte(request.Body), pin))
+	assert.Equal(t, &ibOTPPin{
+		ApplicationID: "HJ675435E3A6EA43432G5F37A635KJ8B",
+		MessageID:     "0130269F44AFD07AEBC2FEFEB30398A0",
+		From:          "InfoSMS",
+		To:            "250788383383",
+	}, pin)
+
+	// Infobip accepting our request but not sending the PIN is an error
+	server.SetResponse("/2fa/2/pin", MockResponse{Status: 200, Body: `{"pinId":"9C817C6F8AF3D48F9FE553282AFA2B67","to":"250788383383","ncStatus":"NC_NOT_CONFIGURED","smsStatus":"MESSAGE_NOT_SENT"}`})
+	status, err = handler.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgErrored, status.Status())
+	assert.Equal(t, "received sms status: 'MESSAGE_NOT_SENT'", status.Logs()[0].Error)
+
+	server.SetResponse("/2fa/2/pin", MockResponse{Status: 400, Body: `{"requestError":{"serviceException":{"messageId":"BAD_REQUEST","text":"Bad request"}}}`})
+	status, err = handler.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgErrored, status.Status())
+
+	// channels missing their template can't send at all
+	noTemplateChannel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "InfoSMS", "US",
+		map[string]interface{}{
+			courier.ConfigPassword: "Password",
+			courier.ConfigUsername: "Username",
+			"channel":              "otp",
+			"otp_application_id":   "HJ675435E3A6EA43432G5F37A635KJ8B",
+		})
+	msg = mb.NewOutgoingMsg(noTemplateChannel, courier.NewMsgID(11), urns.URN("tel:+250788383383"), "Your code is", true, nil)
+	_, err = handler.SendMsg(context.Background(), msg)
+	assert.EqualError(t, err, "no OTP message id set for IB otp channel")
+}
+
+func TestVerifyPIN(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/2fa/2/pin/9C817C6F8AF3D48F9FE553282AFA2B67/verify": MockResponse{Status: 200, Body: `{"pinId":"9C817C6F8AF3D48F9FE553282AFA2B67","msisdn":"250788383383","verified":true,"attemptsRemaining":0}`},
+	})
+	defer server.Close()
+	setOTPURLs(server)
+
+	verify := func(body string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodPost, "/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/verify/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		_, err := h.VerifyPIN(context.Background(), otpChannel, w, r)
+		return w, err
+	}
+
+	w, err := verify(`{"pin_id":"9C817C6F8AF3D48F9FE553282AFA2B67","pin":"1598"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"message":"PIN Verified"`)
+	assert.Contains(t, w.Body.String(), `"verified":true`)
+	assert.Equal(t, `{"pin":"1598"}`, strings.TrimSpace(server.LastRequest().Body))
+
+	server.SetResponse("/2fa/2/pin/9C817C6F8AF3D48F9FE553282AFA2B67/verify", MockResponse{Status: 200, Body: `{"pinId":"9C817C6F8AF3D48F9FE553282AFA2B67","msisdn":"250788383383","verified":false,"attemptsRemaining":2}`})
+	w, err = verify(`{"pin_id":"9C817C6F8AF3D48F9FE553282AFA2B67","pin":"0000"}`)
+	assert.NoError(t, err)
+	assert.Contains(t, w.Body.String(), `"message":"PIN Not Verified"`)
+	assert.Contains(t, w.Body.String(), `"attempts_remaining":2`)
+
+	// missing fields are rejected without asking Infobip
+	requests := len(server.Requests())
+	w, err = verify(`{"pin_id":"9C817C6F8AF3D48F9FE553282AFA2B67"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, requests, len(server.Requests()))
+
+	// errors from Infobip, e.g. for unknown PIN ids, are returned
+	_, err = verify(`{"pin_id":"UNKNOWN","pin":"1598"}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package infobip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+)
+
+// Our test_send route lets whoever is on call send a fixed message from a channel to a number of their choosing,
+// through the same send path as queued messages, and see the resulting status and logs straight away. It needs our
+// admin token and the message is never written to our backend, so nothing is recorded against any contact.
+
+// the text of the messages we send for our test_send route
+const testMessageText = "This is a test message from courier."
+
+// TestSend is our HTTP handler function for sending a test message from a channel
+func (h *handler) TestSend(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if !courier.IsAdminRequest(h.Server().Config(), r) {
+		return nil, courier.WriteUnauthorized(ctx, w, r, "invalid or missing admin token")
+	}
+
+	creator, canCreate := h.Backend().(courier.TestMsgCreator)
+	if !canCreate {
+		return nil, courier.WriteError(ctx, w, r, fmt.Errorf("backend doesn't support test messages"))
+	}
+
+	testSend := &testSendRequest{}
+	err := handlers.DecodeAndValidateJSON(testSend, r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	// we still wait our turn if the channel limits its concurrent sends
+	release, err := h.AcquireSend(ctx, channel)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+	defer release()
+
+	msg := creator.NewTestMsg(channel, handlers.NewTelURNForChannel(testSend.To, channel), testMessageText)
+	status, err := h.SendMsg(ctx, msg)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	data := &testSendData{
+		URN:        msg.URN().String(),
+		Status:     string(status.Status()),
+		ExternalID: status.ExternalID(),
+		Logs:       make([]testSendLog, 0, len(status.Logs())),
+	}
+	for _, log := range status.Logs() {
+		data.Logs = append(data.Logs, testSendLog{
+			Description: log.Description,
+			Method:      log.Method,
+			URL:         log.URL,
+			StatusCode:  log.StatusCode,
+			Request:     log.Request,
+			Response:    log.Response,
+			Error:       log.Error,
+			ElapsedMS:   float64(log.Elapsed) / float64(time.Millisecond),
+		})
+	}
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, "Test Message Sent", data)
+}
+
+type testSendRequest struct {
+	To string `json:"to" validate:"required"`
+}
+
+type testSendData struct {
+	URN        string        `json:"urn"`
+	Status     string        `json:"status"`
+	ExternalID string        `json:"external_id,omitempty"`
+	Logs       []testSendLog `json:"logs"`
+}
+
+type testSendLog struct {
+	Description string  `json:"description"`
+	Method      string  `json:"method"`
+	URL         string  `json:"url"`
+	StatusCode  int     `json:"status_code"`
+	Request     string  `json:"request"`
+	Response    string  `json:"response"`
+	Error       string  `json:"error,omitempty"`
+	ElapsedMS   float64 `json:"elapsed_ms"`
+}
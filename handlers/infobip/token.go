@@ -0,0 +1,146 @@
+package infobip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// Channels with an auth type of oauth don't store Infobip credentials. Instead we fetch short-lived access tokens from
+// their token_url using the client credentials grant, authorizing our requests with whichever token is current. Each
+// token is cached until shortly before it expires, so credentials can be rotated behind the token endpoint without
+// touching the channel.
+
+// how long before a token expires we stop using it and fetch another
+const tokenExpiryMargin = time.Minute
+
+// the lifetime we assume for tokens whose response doesn't include one
+const defaultTokenLifetime = time.Hour
+
+type accessToken struct {
+	value     string
+	expiresOn time.Time
+}
+
+// tokenCache holds the current access token for each channel which authorizes with OAuth
+type tokenCache struct {
+	mutex  sync.Mutex
+	tokens map[string]*accessToken
+	now    func() time.Time
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: make(map[string]*accessToken), now: time.Now}
+}
+
+// Get returns a current access token for the passed in channel, fetching a new one if we don't have one or ours is
+// about to expire. Fetches are made holding our lock so that concurrent sends don't each fetch their own token.
+func (c *tokenCache) Get(ctx context.Context, channel courier.Channel, options utils.HTTPRequestOptions) (string, error) {
+	key := tokenKey(channel)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	token, found := c.tokens[key]
+	if found && c.now().Add(tokenExpiryMargin).Before(token.expiresOn) {
+		return token.value, nil
+	}
+
+	token, err := fetchToken(ctx, channel, options, c.now())
+	if err != nil {
+		delete(c.tokens, key)
+		return "", err
+	}
+	c.tokens[key] = token
+	return token.value, nil
+}
+
+// Forget drops any token we have for the passed in channel, e.g. because Infobip no longer accepts it
+func (c *tokenCache) Forget(channel courier.Channel) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.tokens, tokenKey(channel))
+}
+
+// tokenKey returns the key we cache the passed in channel's token under, which changes with its client credentials
+func tokenKey(channel courier.Channel) string {
+	return fmt.Sprintf("%s:%s:%s", channel.UUID(), flagTokenURL.Get(channel), flagClientID.Get(channel))
+}
+
+// fetchToken requests a new access token for the passed in channel from its token endpoint
+func fetchToken(ctx context.Context, channel courier.Channel, options utils.HTTPRequestOptions, now time.Time) (*accessToken, error) {
+	form := url.Values{"grant_type": []string{"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, flagTokenURL.Get(channel), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(flagClientID.Get(channel), flagClientSecret.Get(channel))
+
+	rr, err := utils.MakeHTTPRequestWithOptions(req, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch access token for IB channel")
+	}
+
+	response := &ibTokenResponse{}
+	err = json.Unmarshal(rr.Body, response)
+	if err != nil || response.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in token response for IB channel")
+	}
+
+	lifetime := defaultTokenLifetime
+	if response.ExpiresIn > 0 {
+		lifetime = time.Duration(response.ExpiresIn) * time.Second
+	}
+	return &accessToken{value: response.AccessToken, expiresOn: now.Add(lifetime)}, nil
+}
+
+// authorization returns the value of the Authorization header for requests on the passed in channel if it uses OAuth,
+// fetching an access token if needed, or an empty string for channels which authorize with their own credentials
+func (h *handler) authorization(ctx context.Context, channel courier.Channel) (string, error) {
+	if flagAuthType.Get(channel) != authTypeOAuth {
+		return "", nil
+	}
+
+	token, err := h.tokens.Get(ctx, channel, h.requestOptions())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Bearer %s", token), nil
+}
+
+// authorize authorizes the passed in request on the passed in channel, whatever its auth type
+func (h *handler) authorize(ctx context.Context, req *http.Request, channel courier.Channel) error {
+	setAuthorization(req, channel)
+
+	authorization, err := h.authorization(ctx, channel)
+	if err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return nil
+}
+
+// {
+//   "access_token": "eyJhbGciOiJIUzI1NiJ9",
+//   "token_type": "Bearer",
+//   "expires_in": 3600
+// }
+type ibTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
@@ -0,0 +1,156 @@
+package infobip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+// newOAuthChannel returns a channel which fetches its access tokens from the passed in server
+func newOAuthChannel(server *TestProviderServer) courier.Channel {
+	return courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US",
+		map[string]interface{}{
+			configAuthType:        authTypeOAuth,
+			configTokenURL:        server.URL + "/oauth/token",
+			configClientID:        "client1",
+			configClientSecret:    "secret1",
+			courier.ConfigBaseURL: server.URL,
+		})
+}
+
+// tokenRequests returns the number of requests made to the token endpoint of the passed in server
+func tokenRequests(server *TestProviderServer) int {
+	count := 0
+	for _, request := range server.Requests() {
+		if request.Path == "/oauth/token" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestTokenCache(t *testing.T) {
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/oauth/token": MockResponse{Status: 200, Body: `{"access_token":"token1","token_type":"Bearer","expires_in":3600}`},
+	})
+	defer server.Close()
+	channel := newOAuthChannel(server)
+
+	now := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	cache := newTokenCache()
+	cache.now = func() time.Time { return now }
+
+	token, err := cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, "token1", token)
+
+	request := server.LastRequest()
+	assert.Equal(t, "POST", request.Method)
+	assert.Equal(t, "grant_type=client_credentials", request.Body)
+	assert.Equal(t, "application/x-www-form-urlencoded", request.Headers.Get("Content-Type"))
+	assert.Equal(t, "Basic Y2xpZW50MTpzZWNyZXQx", request.Headers.Get("Authorization"))
+
+	// our token is reused until shortly before it expires
+	now = now.Add(time.Minute * 58)
+	token, err = cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, "token1", token)
+	assert.Equal(t, 1, tokenRequests(server))
+
+	// then refreshed
+	server.SetResponse("/oauth/token", MockResponse{Status: 200, Body: `{"access_token":"token2","expires_in":600}`})
+	now = now.Add(time.Second * 61)
+	token, err = cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, "token2", token)
+	assert.Equal(t, 2, tokenRequests(server))
+
+	// tokens we forget are fetched again
+	cache.Forget(channel)
+	_, err = cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, tokenRequests(server))
+
+	// as are those for changed client credentials
+	channel.(*courier.MockChannel).SetConfig(configClientID, "client2")
+	_, err = cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, tokenRequests(server))
+
+	// responses without a token are errors, and we don't keep using our old token
+	server.SetResponse("/oauth/token", MockResponse{Status: 200, Body: `{"error":"invalid_client"}`})
+	cache.Forget(channel)
+	_, err = cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.EqualError(t, err, "no access token in token response for IB channel")
+
+	server.SetResponse("/oauth/token", MockResponse{Status: 401, Body: `{"error":"invalid_client"}`})
+	_, err = cache.Get(context.Background(), channel, utils.DefaultHTTPRequestOptions)
+	assert.Error(t, err)
+}
+
+func TestOAuthSend(t *testing.T) {
+	server := NewTestProviderServer(map[string]MockResponse{
+		"/oauth/token":         MockResponse{Status: 200, Body: `{"access_token":"token1","expires_in":3600}`},
+		"/sms/1/text/advanced": MockResponse{Status: 200, Body: `{"messages":[{"status":{"groupId": 1}}]}`},
+	})
+	defer server.Close()
+	channel := newOAuthChannel(server)
+
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// we send with our access token, fetching it only once
+	for i := 0; i < 2; i++ {
+		msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(int64(10+i)), urns.URN("tel:+250788383383"), "Simple Message", false, nil)
+		status, err := h.SendMsg(context.Background(), msg)
+		assert.NoError(t, err)
+		assert.Equal(t, courier.MsgWired, status.Status())
+		assert.Equal(t, "Bearer token1", server.LastRequest().Headers.Get("Authorization"))
+	}
+	assert.Equal(t, 1, tokenRequests(server))
+
+	// if Infobip stops accepting our token we fetch a new one for our next send
+	server.SetResponse("/sms/1/text/advanced", MockResponse{Status: 401, Body: `{"requestError":{"serviceException":{"messageId":"UNAUTHORIZED","text":"Invalid login details"}}}`})
+	msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(12), urns.URN("tel:+250788383383"), "Simple Message", false, nil)
+	status, err := h.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.NotEqual(t, courier.MsgWired, status.Status())
+
+	server.SetResponse("/oauth/token", MockResponse{Status: 200, Body: `{"access_token":"token2","expires_in":3600}`})
+	server.SetResponse("/sms/1/text/advanced", MockResponse{Status: 200, Body: `{"messages":[{"status":{"groupId": 1}}]}`})
+	msg = mb.NewOutgoingMsg(channel, courier.NewMsgID(13), urns.URN("tel:+250788383383"), "Simple Message", false, nil)
+	status, err = h.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, status.Status())
+	assert.Equal(t, "Bearer token2", server.LastRequest().Headers.Get("Authorization"))
+	assert.Equal(t, 2, tokenRequests(server))
+
+	// and if we can't get a token we error without trying to send
+	server.SetResponse("/oauth/token", MockResponse{Status: 500, Body: `{"error":"unavailable"}`})
+	h.tokens.Forget(channel)
+	requests := len(server.Requests())
+	msg = mb.NewOutgoingMsg(channel, courier.NewMsgID(14), urns.URN("tel:+250788383383"), "Simple Message", false, nil)
+	status, err = h.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgErrored, status.Status())
+	assert.Equal(t, "Access Token Error", status.Logs()[0].Description)
+	assert.Equal(t, requests+1, len(server.Requests()))
+}
+
+func TestOAuthCredentials(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US",
+		map[string]interface{}{configAuthType: authTypeOAuth, configTokenURL: "https://auth.example.com/token", configClientID: "client1"})
+	assert.EqualError(t, checkCredentials(channel), "no client_secret set for IB channel")
+
+	channel.(*courier.MockChannel).SetConfig(configClientSecret, "secret1")
+	assert.NoError(t, checkCredentials(channel))
+}
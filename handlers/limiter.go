@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nyaruka/courier"
+)
+
+// our limit, channels without one aren't limited
+var flagMaxConcurrentSends = IntFlag{Key: courier.ConfigMaxConcurrentSends}
+
+// SendLimiter limits the number of sends that can be in flight at once for each channel, as configured by the
+// max_concurrent_sends config value on the channel. Channels without that value set are not limited.
+type SendLimiter struct {
+	mutex      sync.Mutex
+	semaphores map[courier.ChannelUUID]chan bool
+}
+
+// NewSendLimiter creates a new SendLimiter with no sends in flight
+func NewSendLimiter() *SendLimiter {
+	return &SendLimiter{semaphores: make(map[courier.ChannelUUID]chan bool)}
+}
+
+// Acquire blocks until a send slot is available for the passed in channel, returning a function which must be
+// called to release that slot once the send is complete. If the context is done before a slot is available its
+// error is returned instead.
+func (l *SendLimiter) Acquire(ctx context.Context, channel courier.Channel) (func(), error) {
+	max := flagMaxConcurrentSends.Get(channel)
+	if max <= 0 {
+		return func() {}, nil
+	}
+
+	semaphore := l.semaphore(channel.UUID(), max)
+	select {
+	case semaphore <- true:
+		return func() { <-semaphore }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// semaphore returns the semaphore for the passed in channel, creating a new one if our limit has changed
+func (l *SendLimiter) semaphore(uuid courier.ChannelUUID, max int) chan bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	semaphore, found := l.semaphores[uuid]
+	if !found || cap(semaphore) != max {
+		semaphore = make(chan bool, max)
+		l.semaphores[uuid] = semaphore
+	}
+	return semaphore
+}
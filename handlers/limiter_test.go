@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	unlimited := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{})
+	limited := courier.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "IB", "2021", "US",
+		map[string]interface{}{courier.ConfigMaxConcurrentSends: float64(1)})
+
+	limiter := NewSendLimiter()
+
+	// unlimited channels never block
+	for i := 0; i < 5; i++ {
+		_, err := limiter.Acquire(context.Background(), unlimited)
+		assert.NoError(err)
+	}
+
+	release, err := limiter.Acquire(context.Background(), limited)
+	assert.NoError(err)
+
+	// our second send blocks until our context times out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	_, err = limiter.Acquire(ctx, limited)
+	cancel()
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// once released, we can send again
+	release()
+	release, err = limiter.Acquire(context.Background(), limited)
+	assert.NoError(err)
+	release()
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/nyaruka/courier/utils"
+)
+
+// ErrUnknownContentType is returned by SniffContentType when neither the headers nor the content of an attachment
+// tell us what type of media it is
+var ErrUnknownContentType = errors.New("unable to determine attachment content type")
+
+// the number of bytes http.DetectContentType looks at
+const sniffLength = 512
+
+// SniffContentType returns the media type of the attachment at the passed in URL for outgoing media sends, rather than
+// trusting its extension. We use the Content-Type of a HEAD request if the server gives us a useful one, otherwise we
+// fetch the first bytes of the attachment and sniff them. Callers should either skip attachments which return
+// ErrUnknownContentType or send them with a default type their provider accepts.
+func SniffContentType(ctx context.Context, url string) (string, error) {
+	resp, err := attachmentRequest(ctx, http.MethodHead, url)
+	if err == nil {
+		resp.Body.Close()
+		contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if contentType != "" && contentType != "application/octet-stream" {
+			return contentType, nil
+		}
+	}
+
+	// some servers don't support HEAD or don't set a type, so look at the content itself
+	resp, err = attachmentRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	head, err := ioutil.ReadAll(io.LimitReader(resp.Body, sniffLength))
+	if err != nil {
+		return "", err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if contentType == "" || contentType == "application/octet-stream" {
+		return "", ErrUnknownContentType
+	}
+	return contentType, nil
+}
+
+// attachmentRequest makes a request with the passed in method for the attachment at the passed in URL, returning an
+// error if it doesn't succeed
+func attachmentRequest(ctx context.Context, method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", utils.HTTPUserAgent)
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-511")
+	}
+
+	resp, err := utils.GetHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("received non 200 status fetching attachment: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
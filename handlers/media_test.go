@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniffContentType(t *testing.T) {
+	gets := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets++
+		}
+
+		switch r.URL.Path {
+		case "/photo.txt":
+			w.Header().Set("Content-Type", "image/jpeg; charset=binary")
+			w.Write([]byte("jpegbytes"))
+		case "/image.jpg":
+			// really a PNG, served without a useful type
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("\x89PNG\r\n\x1a\nrest of image"))
+		case "/nohead.gif":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write([]byte("GIF89a rest of image"))
+		case "/unknown":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte{0x00, 0x01, 0x02, 0x03})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	// a type in the headers is used without fetching the content
+	contentType, err := SniffContentType(ctx, server.URL+"/photo.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.Equal(t, 0, gets)
+
+	contentType, err = SniffContentType(ctx, server.URL+"/image.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+
+	contentType, err = SniffContentType(ctx, server.URL+"/nohead.gif")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/gif", contentType)
+
+	_, err = SniffContentType(ctx, server.URL+"/unknown")
+	assert.Equal(t, ErrUnknownContentType, err)
+
+	_, err = SniffContentType(ctx, server.URL+"/missing")
+	assert.EqualError(t, err, "received non 200 status fetching attachment: 404")
+}
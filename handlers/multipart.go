@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// MultipartMsg is an incoming concatenated message which is being reassembled from its parts
+type MultipartMsg struct {
+	Key  string
+	Data interface{}
+
+	parts    []string
+	arrived  []bool
+	received int
+	created  time.Time
+}
+
+// Complete returns whether all the parts of this message have arrived
+func (m *MultipartMsg) Complete() bool { return m.received == len(m.parts) }
+
+// Text returns the text of the parts of this message that have arrived, in order
+func (m *MultipartMsg) Text() string { return strings.Join(m.parts, "") }
+
+// MultipartStore buffers the parts of incoming concatenated messages until all of them have arrived. Parts are kept
+// in memory, so providers must deliver every part of a message to the same courier instance.
+type MultipartStore struct {
+	mutex   sync.Mutex
+	msgs    map[string]*MultipartMsg
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// NewMultipartStore creates a new MultipartStore which gives up waiting for missing parts after the passed in timeout
+func NewMultipartStore(timeout time.Duration) *MultipartStore {
+	return &MultipartStore{
+		msgs:    make(map[string]*MultipartMsg),
+		timeout: timeout,
+		now:     time.Now,
+	}
+}
+
+// Add adds the part with the passed in sequence number (starting at 1) to the message with the passed in key, which
+// should identify the sender and concatenation reference. Once all parts have arrived the complete message is
+// returned and removed from the store, until then nil is returned. The data of the first part added is kept on the
+// message, handlers can use it to hold what they need to write the message, e.g. its URN and channel.
+func (s *MultipartStore) Add(key string, total int, seq int, text string, data interface{}) *MultipartMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg, found := s.msgs[key]
+	if !found || len(msg.parts) != total {
+		msg = &MultipartMsg{Key: key, Data: data, parts: make([]string, total), arrived: make([]bool, total), created: s.now()}
+		s.msgs[key] = msg
+	}
+
+	// ignore parts we can't place and repeats of parts we already have
+	if seq < 1 || seq > total || msg.arrived[seq-1] {
+		return nil
+	}
+
+	msg.parts[seq-1] = text
+	msg.arrived[seq-1] = true
+	msg.received++
+
+	if !msg.Complete() {
+		return nil
+	}
+
+	delete(s.msgs, key)
+	return msg
+}
+
+// Expire removes and returns all the messages which have been waiting longer than our timeout for their missing
+// parts, handlers should write these with whatever text has arrived rather than lose them
+func (s *MultipartStore) Expire() []*MultipartMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expired := make([]*MultipartMsg, 0)
+	for key, msg := range s.msgs {
+		if s.now().Sub(msg.created) > s.timeout {
+			expired = append(expired, msg)
+			delete(s.msgs, key)
+		}
+	}
+	return expired
+}
+
+// ParseConcatUDH parses the passed in user data header, returning the reference, total number of parts and sequence
+// number of the part if it contains a concatenation element (either 8 or 16 bit reference)
+func ParseConcatUDH(udh []byte) (int, int, int, bool) {
+	if len(udh) < 1 || int(udh[0]) != len(udh)-1 {
+		return 0, 0, 0, false
+	}
+
+	// walk our information elements looking for a concatenation one
+	for i := 1; i+1 < len(udh); {
+		id, length := udh[i], int(udh[i+1])
+		data := udh[i+2:]
+		if length > len(data) {
+			return 0, 0, 0, false
+		}
+		data = data[:length]
+
+		switch {
+		case id == 0x00 && length == 3:
+			return int(data[0]), int(data[1]), int(data[2]), data[1] > 0
+		case id == 0x08 && length == 4:
+			return int(data[0])<<8 | int(data[1]), int(data[2]), int(data[3]), data[2] > 0
+		}
+		i += 2 + length
+	}
+	return 0, 0, 0, false
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipartStore(t *testing.T) {
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMultipartStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	// in order
+	assert.Nil(t, store.Add("A", 3, 1, "Hello ", "a"))
+	assert.Nil(t, store.Add("A", 3, 2, "there ", "b"))
+	msg := store.Add("A", 3, 3, "world", "c")
+	assert.NotNil(t, msg)
+	assert.True(t, msg.Complete())
+	assert.Equal(t, "Hello there world", msg.Text())
+	assert.Equal(t, "a", msg.Data)
+
+	// out of order, with a repeated part and one we can't place
+	assert.Nil(t, store.Add("B", 2, 2, "world", nil))
+	assert.Nil(t, store.Add("B", 2, 2, "again", nil))
+	assert.Nil(t, store.Add("B", 2, 3, "bogus", nil))
+	msg = store.Add("B", 2, 1, "Hello ", nil)
+	assert.NotNil(t, msg)
+	assert.Equal(t, "Hello world", msg.Text())
+
+	// a message whose parts never all arrive is expired after our timeout
+	assert.Nil(t, store.Add("C", 2, 1, "Hello ", nil))
+	assert.Equal(t, 0, len(store.Expire()))
+
+	now = now.Add(2 * time.Minute)
+	expired := store.Expire()
+	assert.Equal(t, 1, len(expired))
+	assert.False(t, expired[0].Complete())
+	assert.Equal(t, "Hello ", expired[0].Text())
+	assert.Equal(t, 0, len(store.Expire()))
+}
+
+func TestParseConcatUDH(t *testing.T) {
+	tcs := []struct {
+		udh   []byte
+		ref   int
+		total int
+		seq   int
+		found bool
+	}{
+		{[]byte{0x05, 0x00, 0x03, 0x2a, 0x03, 0x01}, 42, 3, 1, true},
+		{[]byte{0x06, 0x08, 0x04, 0x01, 0x02, 0x02, 0x02}, 258, 2, 2, true},
+		{[]byte{0x08, 0x01, 0x01, 0x00, 0x00, 0x03, 0x2a, 0x02, 0x01}, 42, 2, 1, true},
+		{[]byte{0x04, 0x01, 0x02, 0x00, 0x00}, 0, 0, 0, false},
+		{[]byte{0x05, 0x00, 0x03}, 0, 0, 0, false},
+		{[]byte{}, 0, 0, 0, false},
+	}
+
+	for _, tc := range tcs {
+		ref, total, seq, found := ParseConcatUDH(tc.udh)
+		assert.Equal(t, tc.found, found, "found mismatch for %x", tc.udh)
+		assert.Equal(t, tc.ref, ref, "ref mismatch for %x", tc.udh)
+		assert.Equal(t, tc.total, total, "total mismatch for %x", tc.udh)
+		assert.Equal(t, tc.seq, seq, "seq mismatch for %x", tc.udh)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// the number of seconds our recipient rate limit applies over if a channel doesn't configure a window
+const defaultRecipientRateWindow = 3600
+
+// our limit, channels without one are never stopped, and the window in seconds it applies over
+var (
+	flagRecipientRateLimit  = IntFlag{Key: courier.ConfigRecipientRateLimit}
+	flagRecipientRateWindow = IntFlag{Key: courier.ConfigRecipientRateWindow, Default: defaultRecipientRateWindow, Min: 1}
+)
+
+// how often we forget recipients we haven't sent to within their window
+const recipientPruneInterval = time.Minute
+
+// RecipientLimiter stops channels sending too many messages to the same destination, e.g. because of a loop with an
+// auto-responder, as configured by the recipient_rate_limit and recipient_rate_window config values on the channel.
+// Sends within the window are counted for each channel and destination and once the limit is reached further sends
+// are refused until the oldest of them falls out of the window. Channels without a limit set are never stopped.
+type RecipientLimiter struct {
+	mutex    sync.Mutex
+	sends    map[string]*recipientSends
+	prunedOn time.Time
+	now      func() time.Time
+}
+
+type recipientSends struct {
+	window time.Duration
+	times  []time.Time
+}
+
+// NewRecipientLimiter creates a new RecipientLimiter with no sends counted
+func NewRecipientLimiter() *RecipientLimiter {
+	return &RecipientLimiter{sends: make(map[string]*recipientSends), now: time.Now}
+}
+
+// Allow returns an error if the passed in channel has reached its limit of sends to the passed in URN, otherwise the
+// send is counted and nil returned
+func (l *RecipientLimiter) Allow(channel courier.Channel, urn urns.URN) error {
+	limit := flagRecipientRateLimit.Get(channel)
+	if limit <= 0 {
+		return nil
+	}
+	window := time.Duration(flagRecipientRateWindow.Get(channel)) * time.Second
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	key := fmt.Sprintf("%s:%s", channel.UUID(), urn.Identity())
+	sends, found := l.sends[key]
+	if !found {
+		sends = &recipientSends{}
+		l.sends[key] = sends
+	}
+	sends.window = window
+	sends.times = sendsSince(sends.times, now.Add(-window))
+
+	if len(sends.times) >= limit {
+		return fmt.Errorf("not sending, %d messages already sent to %s within %s", len(sends.times), urn.Path(), window)
+	}
+	sends.times = append(sends.times, now)
+	return nil
+}
+
+// prune forgets recipients with no sends left in their window, we only do this every so often as it visits every one
+func (l *RecipientLimiter) prune(now time.Time) {
+	if now.Sub(l.prunedOn) < recipientPruneInterval {
+		return
+	}
+	for key, sends := range l.sends {
+		sends.times = sendsSince(sends.times, now.Add(-sends.window))
+		if len(sends.times) == 0 {
+			delete(l.sends, key)
+		}
+	}
+	l.prunedOn = now
+}
+
+// sendsSince returns the passed in send times which are after since, these are always in order
+func sendsSince(times []time.Time, since time.Time) []time.Time {
+	for i, t := range times {
+		if t.After(since) {
+			return times[i:]
+		}
+	}
+	return nil
+}
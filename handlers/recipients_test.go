@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	unlimited := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", map[string]interface{}{})
+	limited := courier.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "IB", "2021", "US",
+		map[string]interface{}{
+			courier.ConfigRecipientRateLimit:  float64(3),
+			courier.ConfigRecipientRateWindow: float64(60),
+		})
+	bob := urns.URN("tel:+250788383383")
+	jim := urns.URN("tel:+250788383384")
+
+	now := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRecipientLimiter()
+	limiter.now = func() time.Time { return now }
+
+	// channels without a limit are never stopped
+	for i := 0; i < 5; i++ {
+		assert.NoError(limiter.Allow(unlimited, bob))
+	}
+
+	// others can send up to their limit within their window
+	for i := 0; i < 3; i++ {
+		assert.NoError(limiter.Allow(limited, bob))
+		now = now.Add(time.Second * 10)
+	}
+	err := limiter.Allow(limited, bob)
+	assert.EqualError(err, "not sending, 3 messages already sent to +250788383383 within 1m0s")
+
+	// which doesn't affect other destinations
+	assert.NoError(limiter.Allow(limited, jim))
+
+	// once our first send falls out of the window we can send again
+	now = now.Add(time.Second * 31)
+	assert.NoError(limiter.Allow(limited, bob))
+	assert.Error(limiter.Allow(limited, bob))
+
+	// recipients with nothing left in their window are eventually forgotten
+	now = now.Add(time.Minute * 2)
+	assert.NoError(limiter.Allow(limited, bob))
+	assert.Equal(1, len(limiter.sends))
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+)
+
+// ErrSendDeadlineExceeded is returned by MakeSendRequest when a channel's send deadline passed before we got a response
+var ErrSendDeadlineExceeded = errors.New("deadline exceeded, send took longer than the channel's send deadline")
+
+// how many times we retry sends, and how many seconds they have to succeed within, neither being limited if unset
+var (
+	flagSendRetries  = IntFlag{Key: courier.ConfigSendRetries}
+	flagSendDeadline = IntFlag{Key: courier.ConfigSendDeadline}
+)
+
+// how long we wait before each retry if the provider doesn't tell us, multiplied by the number of the retry
+var retryBackoff = time.Second
+
+// MakeSendRequest makes the request built by the passed in function, retrying it as many times as the send_retries
+// configured on the passed in channel if it fails to get a response or gets a 5xx or 429. All attempts, and the waits
+// between them, must fit within the send_deadline configured on the channel, if it passes we give up and return
+// ErrSendDeadlineExceeded along with the last response we got. The request is rebuilt for every attempt so that its
+// body can be read again, and each attempt is made with the passed in options. The attempts before the last are
+// returned as the Retried of the last so they can be logged too. If the request can't be built for the first attempt
+// its error is returned without any response, so callers must check for a nil response.
+func MakeSendRequest(ctx context.Context, channel courier.Channel, options utils.HTTPRequestOptions, newRequest func() (*http.Request, error)) (*utils.RequestResponse, error) {
+	retries := flagSendRetries.Get(channel)
+	deadline := time.Duration(flagSendDeadline.Get(channel)) * time.Second
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	var rr *utils.RequestResponse
+	var err error
+	attempts := make([]*utils.RequestResponse, 0, 1)
+	defer func() {
+		if len(attempts) > 1 {
+			attempts[len(attempts)-1].Retried = attempts[:len(attempts)-1]
+		}
+	}()
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			wait := retryBackoff * time.Duration(attempt)
+			if rr != nil && rr.RetryAfter > wait {
+				wait = rr.RetryAfter
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return rr, sendContextError(ctx, deadline, err)
+			}
+		}
+
+		req, reqErr := newRequest()
+		if reqErr != nil {
+			return rr, reqErr
+		}
+
+		rr, err = utils.MakeHTTPRequestWithOptions(req.WithContext(ctx), options)
+		attempts = append(attempts, rr)
+		if err == nil || !isRetryable(rr) {
+			break
+		}
+		if ctx.Err() != nil {
+			return rr, sendContextError(ctx, deadline, err)
+		}
+	}
+	return rr, err
+}
+
+// sendContextError returns the error a send whose context is done should return, which is ErrSendDeadlineExceeded if
+// it was our deadline which passed, otherwise the last error we got
+func sendContextError(ctx context.Context, deadline time.Duration, err error) error {
+	if deadline > 0 && ctx.Err() == context.DeadlineExceeded {
+		return ErrSendDeadlineExceeded
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// isRetryable returns whether a request which got the passed in response is worth trying again
+func isRetryable(rr *utils.RequestResponse) bool {
+	return rr == nil || rr.StatusCode == 0 || rr.StatusCode == http.StatusTooManyRequests || rr.StatusCode/100 == 5
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeSendRequest(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	var requests, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case "/slow":
+			time.Sleep(time.Millisecond * 1500)
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	newChannel := func(config map[string]interface{}) courier.Channel {
+		return courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", config)
+	}
+	newRequest := func(path string) func() (*http.Request, error) {
+		return func() (*http.Request, error) { return http.NewRequest(http.MethodGet, server.URL+path, nil) }
+	}
+
+	tcs := []struct {
+		config   map[string]interface{}
+		path     string
+		failures int32
+		requests int32
+		err      string
+	}{
+		{map[string]interface{}{}, "/flaky", 1, 1, "received non 200 status: 503"},
+		{map[string]interface{}{courier.ConfigSendRetries: 2}, "/flaky", 2, 3, ""},
+		{map[string]interface{}{courier.ConfigSendRetries: 2.0}, "/flaky", 5, 3, "received non 200 status: 503"},
+		{map[string]interface{}{courier.ConfigSendRetries: 2}, "/invalid", 0, 1, "received non 200 status: 400"},
+		{map[string]interface{}{courier.ConfigSendRetries: 2, courier.ConfigSendDeadline: 1}, "/slow", 0, 1, ErrSendDeadlineExceeded.Error()},
+	}
+
+	for _, tc := range tcs {
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, tc.failures)
+
+		rr, err := MakeSendRequest(context.Background(), newChannel(tc.config), utils.DefaultHTTPRequestOptions, newRequest(tc.path))
+		assert.NotNil(t, rr)
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for %s", tc.path)
+		} else if assert.Error(t, err, "expected error for %s", tc.path) {
+			assert.Equal(t, tc.err, err.Error())
+		}
+		assert.Equal(t, tc.requests, atomic.LoadInt32(&requests), "request count mismatch for %s", tc.path)
+		assert.Equal(t, int(tc.requests)-1, len(rr.Retried), "retried count mismatch for %s", tc.path)
+	}
+
+	// requests which can't be built have no response
+	rr, err := MakeSendRequest(context.Background(), newChannel(nil), utils.DefaultHTTPRequestOptions, func() (*http.Request, error) {
+		return nil, errors.New("unable to build request")
+	})
+	assert.Nil(t, rr)
+	assert.EqualError(t, err, "unable to build request")
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
+)
+
+// the skew in seconds we allow, channels without one aren't checked, and whether we clamp times skewed further
+var (
+	flagMaxClockSkew   = IntFlag{Key: courier.ConfigMaxClockSkew}
+	flagClampClockSkew = BoolFlag{Key: courier.ConfigClampClockSkew}
+)
+
+// CheckClockSkew compares the time a provider says it received a message with our clock, logging a warning when they
+// are further apart than the max_clock_skew configured on the channel. It returns the time the message should be
+// received on, which is now if the channel also clamps skewed times, and whether it was clamped. Handlers which clamp
+// should keep the provider's time in the message's metadata as provider_received_on. Channels without a max clock
+// skew aren't checked.
+func CheckClockSkew(channel courier.Channel, receivedOn time.Time, now time.Time) (time.Time, bool) {
+	maxSkew := time.Duration(flagMaxClockSkew.Get(channel)) * time.Second
+	if maxSkew <= 0 {
+		return receivedOn, false
+	}
+
+	skew := receivedOn.Sub(now)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= maxSkew {
+		return receivedOn, false
+	}
+
+	clamp := flagClampClockSkew.Get(channel)
+	logrus.WithField("channel_uuid", channel.UUID()).WithField("received_on", receivedOn).WithField("skew", skew).WithField("clamped", clamp).Warning("provider received time is skewed from our clock")
+
+	if clamp {
+		return now, true
+	}
+	return receivedOn, false
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tcs := []struct {
+		maxSkew    interface{}
+		clamp      bool
+		receivedOn time.Time
+		expected   time.Time
+		clamped    bool
+	}{
+		{nil, true, now.Add(-time.Hour * 5), now.Add(-time.Hour * 5), false},
+		{300, false, now.Add(-time.Minute * 2), now.Add(-time.Minute * 2), false},
+		{300, true, now.Add(time.Minute * 5), now.Add(time.Minute * 5), false},
+		{300.0, false, now.Add(-time.Hour * 3), now.Add(-time.Hour * 3), false},
+		{300, true, now.Add(-time.Hour * 3), now, true},
+		{300, true, now.Add(time.Hour * 3), now, true},
+	}
+
+	for _, tc := range tcs {
+		config := map[string]interface{}{courier.ConfigClampClockSkew: tc.clamp}
+		if tc.maxSkew != nil {
+			config[courier.ConfigMaxClockSkew] = tc.maxSkew
+		}
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", config)
+
+		receivedOn, clamped := CheckClockSkew(channel, tc.receivedOn, now)
+		assert.Equal(t, tc.expected, receivedOn, "received on mismatch for max skew %v and %s", tc.maxSkew, tc.receivedOn)
+		assert.Equal(t, tc.clamped, clamped, "clamped mismatch for max skew %v and %s", tc.maxSkew, tc.receivedOn)
+	}
+}
@@ -1,12 +1,13 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -32,6 +33,9 @@ type ChannelHandleTestCase struct {
 	Status   int
 	Response string
 
+	// when set, the response must be exactly this JSON (ignoring formatting) with a JSON content type
+	ResponseJSON string
+
 	Name         *string
 	Text         *string
 	URN          *string
@@ -40,8 +44,10 @@ type ChannelHandleTestCase struct {
 	Date         *time.Time
 	ChannelEvent *string
 
-	ExternalID *string
-	ID         int64
+	ExternalID  *string
+	ID          int64
+	ChannelUUID *string
+	MsgStatus   *string
 
 	PrepRequest RequestPrepFunc
 }
@@ -58,6 +64,7 @@ type ChannelSendTestCase struct {
 	Attachments  []string
 	QuickReplies []string
 	HighPriority bool
+	SendAt       *time.Time
 
 	ResponseStatus int
 	ResponseBody   string
@@ -77,6 +84,105 @@ type ChannelSendTestCase struct {
 	SendPrep SendPrepFunc
 }
 
+// MockResponse is a canned response returned by a TestProviderServer
+type MockResponse struct {
+	Status  int
+	Body    string
+	Headers map[string]string
+}
+
+// RecordedRequest is a request received by a TestProviderServer
+type RecordedRequest struct {
+	Method  string
+	URL     string
+	Path    string
+	Headers http.Header
+	Body    string
+}
+
+// HTTPRequest returns a new http.Request for this recorded request, useful for parsing form bodies
+func (r *RecordedRequest) HTTPRequest() *http.Request {
+	req := httptest.NewRequest(r.Method, r.URL, strings.NewReader(r.Body))
+	req.Header = r.Headers
+	return req
+}
+
+// TestProviderServer is a fake provider for handler tests, it records every request it receives and returns
+// canned responses keyed by request path. The response keyed by the empty path is used for unknown paths,
+// without one unknown paths return a 404.
+type TestProviderServer struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	responses map[string]MockResponse
+	requests  []*RecordedRequest
+}
+
+// NewTestProviderServer creates and starts a new TestProviderServer with the passed in responses
+func NewTestProviderServer(responses map[string]MockResponse) *TestProviderServer {
+	s := &TestProviderServer{responses: make(map[string]MockResponse)}
+	for path, response := range responses {
+		s.responses[path] = response
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mutex.Lock()
+		s.requests = append(s.requests, &RecordedRequest{
+			Method:  r.Method,
+			URL:     r.URL.String(),
+			Path:    r.URL.Path,
+			Headers: r.Header,
+			Body:    string(body),
+		})
+		response, found := s.responses[r.URL.Path]
+		if !found {
+			response, found = s.responses[""]
+		}
+		s.mutex.Unlock()
+
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		for k, v := range response.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(response.Status)
+		w.Write([]byte(response.Body))
+	}))
+	return s
+}
+
+// SetResponse sets the canned response for the passed in path
+func (s *TestProviderServer) SetResponse(path string, response MockResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.responses[path] = response
+}
+
+// Requests returns all the requests received so far
+func (s *TestProviderServer) Requests() []*RecordedRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests
+}
+
+// LastRequest returns the last request received, or nil if there hasn't been one
+func (s *TestProviderServer) LastRequest() *RecordedRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
 // Sp is a utility method to get the pointer to the passed in string
 func Sp(str string) *string { return &str }
 
@@ -95,7 +201,29 @@ func ensureTestServerUp(host string) {
 }
 
 // utility method to make a request to a handler URL
-func testHandlerRequest(tb testing.TB, s courier.Server, path string, data string, expectedStatus int, expectedBody *string, requestPrepFunc RequestPrepFunc) string {
+func testHandlerRequest(tb testing.TB, s courier.Server, path string, data string, expectedStatus int, expectedBody *string, requestPrepFunc RequestPrepFunc) *httptest.ResponseRecorder {
+	rr := recordHandlerRequest(tb, s, path, data, requestPrepFunc)
+	body := rr.Body.String()
+
+	require.Equal(tb, expectedStatus, rr.Code, fmt.Sprintf("incorrect status code with response: %s", body))
+
+	if expectedBody != nil {
+		require.Contains(tb, body, *expectedBody)
+	}
+
+	return rr
+}
+
+// RequireJSONResponse requires that the passed in recorded response has the passed in status code, a JSON content
+// type and a body which is the same JSON as the passed in body, e.g. the exact acknowledgement a provider expects
+func RequireJSONResponse(tb testing.TB, rr *httptest.ResponseRecorder, expectedStatus int, expectedJSON string) {
+	require.Equal(tb, expectedStatus, rr.Code, fmt.Sprintf("incorrect status code with response: %s", rr.Body.String()))
+	require.Equal(tb, "application/json", rr.Header().Get("Content-Type"))
+	require.JSONEq(tb, expectedJSON, rr.Body.String())
+}
+
+// recordHandlerRequest makes a request to the passed in path of our server, returning the recorded response
+func recordHandlerRequest(tb testing.TB, s courier.Server, path string, data string, requestPrepFunc RequestPrepFunc) *httptest.ResponseRecorder {
 	var req *http.Request
 	var err error
 	url := fmt.Sprintf("https://%s%s", s.Config().Domain, path)
@@ -123,16 +251,7 @@ func testHandlerRequest(tb testing.TB, s courier.Server, path string, data strin
 
 	rr := httptest.NewRecorder()
 	s.Router().ServeHTTP(rr, req)
-
-	body := rr.Body.String()
-
-	require.Equal(tb, expectedStatus, rr.Code, fmt.Sprintf("incorrect status code with response: %s", body))
-
-	if expectedBody != nil {
-		require.Contains(tb, body, *expectedBody)
-	}
-
-	return body
+	return rr
 }
 
 func newServer(backend courier.Backend) courier.Server {
@@ -159,26 +278,29 @@ func RunChannelSendTestCases(t *testing.T, channel courier.Channel, handler cour
 			for _, a := range testCase.Attachments {
 				msg.WithAttachment(a)
 			}
+			if testCase.SendAt != nil {
+				msg.WithSendAt(*testCase.SendAt)
+			}
 
-			var testRequest *http.Request
-			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				body, _ := ioutil.ReadAll(r.Body)
-				testRequest = httptest.NewRequest(r.Method, r.URL.String(), bytes.NewBuffer(body))
-				testRequest.Header = r.Header
-				w.WriteHeader(testCase.ResponseStatus)
-				w.Write([]byte(testCase.ResponseBody))
-			}))
+			server := NewTestProviderServer(map[string]MockResponse{
+				"": MockResponse{Status: testCase.ResponseStatus, Body: testCase.ResponseBody},
+			})
 			defer server.Close()
 
 			// call our prep function if we have one
 			if testCase.SendPrep != nil {
-				testCase.SendPrep(server, channel, msg)
+				testCase.SendPrep(server.Server, channel, msg)
 			}
 
 			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
 			status, err := handler.SendMsg(ctx, msg)
 			cancel()
 
+			var testRequest *http.Request
+			if recorded := server.LastRequest(); recorded != nil {
+				testRequest = recorded.HTTPRequest()
+			}
+
 			if testCase.Error != "" {
 				if err == nil {
 					t.Errorf("expected error: %s", testCase.Error)
@@ -240,6 +362,38 @@ func RunChannelSendTestCases(t *testing.T, channel courier.Channel, handler cour
 
 }
 
+// routeRecorder is a server which records the routes handlers add to it as "METHOD action", e.g. "POST receive"
+type routeRecorder struct {
+	courier.Server
+	routes []string
+}
+
+func (s *routeRecorder) AddHandlerRoute(handler courier.ChannelHandler, method string, action string, handlerFunc courier.ChannelHandleFunc) error {
+	s.routes = append(s.routes, fmt.Sprintf("%s %s", strings.ToUpper(method), action))
+	return s.Server.AddHandlerRoute(handler, method, action, handlerFunc)
+}
+
+// HandlerRoutes initializes the passed in handler and returns the routes it adds, as "METHOD action", e.g.
+// "POST receive", in the order they were added
+func HandlerRoutes(tb testing.TB, handler courier.ChannelHandler) []string {
+	s := &routeRecorder{Server: newServer(courier.NewMockBackend())}
+	require.NoError(tb, handler.Initialize(s))
+	return s.routes
+}
+
+// RequireHandlerRoutes requires that initializing the passed in handler adds exactly the passed in routes, in any
+// order, given as "METHOD action", e.g. "POST receive"
+func RequireHandlerRoutes(tb testing.TB, handler courier.ChannelHandler, expected ...string) {
+	routes := HandlerRoutes(tb, handler)
+	sort.Strings(routes)
+
+	sorted := make([]string, len(expected))
+	copy(sorted, expected)
+	sort.Strings(sorted)
+
+	require.Equal(tb, sorted, routes, "unexpected routes for handler %s", handler.ChannelName())
+}
+
 // RunChannelTestCases runs all the passed in tests cases for the passed in channel configurations
 func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler courier.ChannelHandler, testCases []ChannelHandleTestCase) {
 	mb := courier.NewMockBackend()
@@ -256,7 +410,10 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 
 			mb.ClearQueueMsgs()
 
-			testHandlerRequest(t, s, testCase.URL, testCase.Data, testCase.Status, &testCase.Response, testCase.PrepRequest)
+			rr := testHandlerRequest(t, s, testCase.URL, testCase.Data, testCase.Status, &testCase.Response, testCase.PrepRequest)
+			if testCase.ResponseJSON != "" {
+				RequireJSONResponse(t, rr, testCase.Status, testCase.ResponseJSON)
+			}
 
 			// pop our message off and test against it
 			contactName := mb.GetLastContactName()
@@ -299,6 +456,20 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 						require.Equal(testCase.ID, -1)
 					}
 				}
+				if testCase.MsgStatus != nil {
+					if status != nil {
+						require.Equal(*testCase.MsgStatus, string(status.Status()))
+					} else {
+						require.Equal(*testCase.MsgStatus, "")
+					}
+				}
+				if testCase.ChannelUUID != nil {
+					if msg != nil {
+						require.Equal(*testCase.ChannelUUID, msg.Channel().UUID().String())
+					} else {
+						require.Equal(*testCase.ChannelUUID, "")
+					}
+				}
 				if testCase.Attachment != nil {
 					require.Equal([]string{*testCase.Attachment}, msg.Attachments())
 				}
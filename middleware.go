@@ -0,0 +1,139 @@
+package courier
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/nyaruka/courier/librato"
+	"github.com/sirupsen/logrus"
+)
+
+// HandlerMiddleware wraps the HTTP handler for a route registered by a ChannelHandler. The channel handler which
+// registered the route is passed in so that middleware can choose to only act on routes for some channel types.
+type HandlerMiddleware func(handler ChannelHandler, next http.Handler) http.Handler
+
+// chainMiddleware wraps the passed in http handler in the passed in middlewares, the first middleware being outermost
+func chainMiddleware(handler ChannelHandler, final http.Handler, middlewares []HandlerMiddleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		final = middlewares[i](handler, final)
+	}
+	return final
+}
+
+// LogRequestsMiddleware logs every request made to a channel handler route along with its response status
+func LogRequestsMiddleware(handler ChannelHandler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		logrus.WithFields(logrus.Fields{
+			"comp":         "server",
+			"handler_type": handler.ChannelType(),
+			"method":       r.Method,
+			"url":          r.URL.String(),
+			"remote_addr":  r.RemoteAddr,
+			"resp_status":  ww.Status(),
+			"elapsed_ms":   float64(time.Now().Sub(start)) / float64(time.Millisecond),
+		}).Info("request handled")
+	})
+}
+
+// TimeRequestsMiddleware reports the time taken by every request to a channel handler route to librato
+func TimeRequestsMiddleware(handler ChannelHandler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		duration := float64(time.Now().Sub(start)) / float64(time.Second)
+		librato.Default.AddGauge(fmt.Sprintf("courier.route_%s", handler.ChannelType()), duration)
+	})
+}
+
+// namedMiddleware is the middleware which can be configured to wrap channel handler routes by name
+var namedMiddleware = map[string]HandlerMiddleware{
+	"log":  LogRequestsMiddleware,
+	"time": TimeRequestsMiddleware,
+}
+
+// NewAllowIPsMiddleware returns middleware that only allows requests to channel routes from IP addresses in their
+// allowed CIDR ranges, all other requests are rejected with a 403. A route's ranges are the allowed_ips configured on
+// its channel, e.g. its provider's published ranges, or if it has none those for its channel type in the passed in map.
+// Routes without any are open to everyone. The address of a request is that of the client behind any of our trusted
+// proxies.
+func NewAllowIPsMiddleware(backend Backend, allowed map[ChannelType][]string) (HandlerMiddleware, error) {
+	allowedNetworks := make(map[ChannelType][]*net.IPNet, len(allowed))
+	for channelType, cidrs := range allowed {
+		networks, err := ParseCIDRs(cidrs)
+		if err != nil {
+			return nil, err
+		}
+		allowedNetworks[channelType] = networks
+	}
+
+	return func(handler ChannelHandler, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			networks := allowedNetworks[handler.ChannelType()]
+
+			// requests for channels we can't find are left for our route to reject
+			channel := routeChannel(r, handler, backend)
+			if channel != nil {
+				channelNetworks, err := ChannelAllowedIPs(channel)
+				if err != nil {
+					WriteForbidden(r.Context(), w, r, err.Error())
+					return
+				}
+				if len(channelNetworks) > 0 {
+					networks = channelNetworks
+				}
+			}
+
+			if len(networks) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := RequestClientIP(r)
+			if ipInNetworks(ip, networks) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logrus.WithField("handler_type", handler.ChannelType()).WithField("remote_addr", r.RemoteAddr).Warning("request from disallowed IP")
+			WriteForbidden(r.Context(), w, r, fmt.Sprintf("requests not allowed from: %s", ip))
+		})
+	}, nil
+}
+
+// routeChannel returns the channel the passed in request to a route of the passed in handler is for, or nil if there
+// isn't one
+func routeChannel(r *http.Request, handler ChannelHandler, backend Backend) Channel {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		return nil
+	}
+	channel, err := backend.GetChannel(r.Context(), handler.ChannelType(), uuid)
+	if err != nil {
+		return nil
+	}
+	return channel
+}
+
+// parseAllowedIPs parses the passed in allowed IP ranges, each a channel type and CIDR range, e.g. IB:62.140.31.0/24,
+// into the ranges for each channel type
+func parseAllowedIPs(allowedIPs []string) (map[ChannelType][]string, error) {
+	allowed := make(map[ChannelType][]string)
+	for _, allowedIP := range allowedIPs {
+		parts := strings.SplitN(strings.TrimSpace(allowedIP), ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid allowed IPs '%s', must be a channel type and CIDR range, e.g. IB:62.140.31.0/24", allowedIP)
+		}
+		channelType := ChannelType(strings.ToUpper(parts[0]))
+		allowed[channelType] = append(allowed[channelType], parts[1])
+	}
+	return allowed, nil
+}
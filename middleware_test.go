@@ -0,0 +1,230 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", map[string]interface{}{}))
+	config := config.NewTest()
+	config.TrustedProxies = []string{"172.16.0.0/12"}
+	s := NewServerWithLogger(config, mb, logrus.New())
+
+	handler := NewHandler()
+	s.AddHandlerRoute(handler, "POST", "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		if r.URL.Query().Get("panic") != "" {
+			panic("boom")
+		}
+		return nil, WriteIgnored(ctx, w, r, "ignored")
+	})
+
+	allowIPs, err := NewAllowIPsMiddleware(mb, map[ChannelType][]string{"DM": {"10.0.0.0/8", "192.168.1.1/32"}})
+	assert.NoError(err)
+
+	_, err = NewAllowIPsMiddleware(mb, map[ChannelType][]string{"DM": {"not a cidr"}})
+	assert.Error(err)
+
+	// middleware can be added after routes are registered
+	s.AddHandlerMiddleware(LogRequestsMiddleware)
+	s.AddHandlerMiddleware(TimeRequestsMiddleware)
+	s.AddHandlerMiddleware(allowIPs)
+
+	tcs := []struct {
+		url        string
+		remoteAddr string
+		forwarded  string
+		status     int
+		response   string
+	}{
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "10.1.2.3:1234", "", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "192.168.1.1:1234", "", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "192.168.1.2:1234", "", 403, "requests not allowed from: 192.168.1.2"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive?panic=1", "10.1.2.3:1234", "", 500, "internal server error"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "172.16.0.5:1234", "10.1.2.3", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "172.16.0.5:1234", "8.8.8.8", 403, "requests not allowed from: 8.8.8.8"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "8.8.8.8:1234", "10.1.2.3", 403, "requests not allowed from: 8.8.8.8"},
+	}
+
+	for _, tc := range tcs {
+		req := httptest.NewRequest("POST", tc.url, nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		rr := httptest.NewRecorder()
+		s.Router().ServeHTTP(rr, req)
+
+		assert.Equal(tc.status, rr.Code, "status mismatch for %s from %s", tc.url, tc.remoteAddr)
+		assert.Contains(rr.Body.String(), tc.response)
+	}
+}
+
+func TestConfigureMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", map[string]interface{}{}))
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{ConfigAllowedIPs: []interface{}{"8.8.8.0/24"}}))
+	config := config.NewTest()
+	config.HandlerMiddleware = []string{"log", "time"}
+	config.AllowedIPs = []string{"DM:10.0.0.0/8", "dm:192.168.1.1/32"}
+	s := NewServerWithLogger(config, mb, logrus.New()).(*server)
+
+	s.AddHandlerRoute(NewHandler(), "POST", "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		if r.URL.Query().Get("panic") != "" {
+			panic("boom")
+		}
+		return nil, WriteIgnored(ctx, w, r, "ignored")
+	})
+
+	// our chain is built from our config, which allows IPs by channel type, unless a channel has its own
+	assert.NoError(s.configureMiddleware())
+	assert.Equal(3, len(s.middlewares))
+
+	tcs := []struct {
+		url        string
+		remoteAddr string
+		status     int
+		response   string
+	}{
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "10.1.2.3:1234", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "192.168.1.1:1234", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "8.8.8.8:1234", 403, "requests not allowed from: 8.8.8.8"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive?panic=1", "10.1.2.3:1234", 500, "internal server error"},
+		{"/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", "8.8.8.8:1234", 200, "ignored"},
+		{"/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", "10.1.2.3:1234", 403, "requests not allowed from: 10.1.2.3"},
+	}
+
+	for _, tc := range tcs {
+		req := httptest.NewRequest("POST", tc.url, nil)
+		req.RemoteAddr = tc.remoteAddr
+		rr := httptest.NewRecorder()
+		s.Router().ServeHTTP(rr, req)
+
+		assert.Equal(tc.status, rr.Code, "status mismatch for %s from %s", tc.url, tc.remoteAddr)
+		assert.Contains(rr.Body.String(), tc.response)
+	}
+
+	// invalid config is an error
+	config.HandlerMiddleware = []string{"compress"}
+	assert.EqualError(s.configureMiddleware(), "unknown handler middleware: 'compress'")
+
+	config.HandlerMiddleware = nil
+	config.AllowedIPs = []string{"10.0.0.0/8"}
+	assert.EqualError(s.configureMiddleware(), "invalid allowed IPs '10.0.0.0/8', must be a channel type and CIDR range, e.g. IB:62.140.31.0/24")
+
+	config.AllowedIPs = []string{"DM:10.0.0.0"}
+	assert.EqualError(s.configureMiddleware(), "invalid allowed IPs: invalid CIDR '10.0.0.0': invalid CIDR address: 10.0.0.0")
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", map[string]interface{}{}))
+	config := config.NewTest()
+	config.MaxRequestBytes = 10
+	s := NewServerWithLogger(config, mb, logrus.New())
+
+	s.AddHandlerRoute(NewHandler(), "POST", "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, WriteIgnored(ctx, w, r, "ignored")
+	})
+
+	tcs := []struct {
+		body          string
+		contentLength int64
+		status        int
+		response      string
+	}{
+		{"small", 5, 200, "ignored"},
+		{"much too large", 14, 413, "request body larger than 10 bytes"},
+		{"much too large", -1, 413, "request body larger than 10 bytes"},
+	}
+
+	for _, tc := range tcs {
+		req := httptest.NewRequest("POST", "/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", strings.NewReader(tc.body))
+		req.ContentLength = tc.contentLength
+		rr := httptest.NewRecorder()
+		s.Router().ServeHTTP(rr, req)
+
+		assert.Equal(tc.status, rr.Code, "status mismatch for %s", tc.body)
+		assert.Contains(rr.Body.String(), tc.response)
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", map[string]interface{}{}))
+	s := NewServerWithLogger(config.NewTest(), mb, logrus.New())
+
+	s.AddHandlerRoute(NewHandler(), "POST", "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		var results []string
+		return nil, WriteIgnored(ctx, w, r, results[0])
+	})
+
+	req := httptest.NewRequest("POST", "/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", strings.NewReader(`{"results":[]}`))
+	rr := httptest.NewRecorder()
+	s.Router().ServeHTTP(rr, req)
+
+	assert.Equal(500, rr.Code)
+	assert.Contains(rr.Body.String(), "internal server error")
+
+	// the panic is logged against the channel along with the request that caused it
+	log, err := mb.GetChannelLog(context.Background(), 1)
+	assert.NoError(err)
+	assert.Equal("Channel Error", log.Description)
+	assert.Contains(log.Request, `{"results":[]}`)
+	assert.Contains(log.Error, "index out of range")
+}
+
+func TestCallbackToken(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", map[string]interface{}{ConfigCallbackToken: "sesame"}))
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{}))
+	s := NewServerWithLogger(config.NewTest(), mb, logrus.New())
+
+	s.AddHandlerRoute(NewHandler(), "POST", "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, WriteIgnored(ctx, w, r, "ignored")
+	})
+
+	tcs := []struct {
+		url      string
+		header   string
+		status   int
+		response string
+	}{
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive?token=sesame", "", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "sesame", 200, "ignored"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "", 401, "missing or invalid callback token"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive?token=open", "", 401, "missing or invalid callback token"},
+		{"/c/dm/53e5aafa-8155-449d-9009-fcb30d54bd26/receive", "open", 401, "missing or invalid callback token"},
+		{"/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", "", 200, "ignored"},
+	}
+
+	for _, tc := range tcs {
+		req := httptest.NewRequest("POST", tc.url, nil)
+		if tc.header != "" {
+			req.Header.Set(CallbackTokenHeader, tc.header)
+		}
+		rr := httptest.NewRecorder()
+		s.Router().ServeHTTP(rr, req)
+
+		assert.Equal(tc.status, rr.Code, "status mismatch for %s with header '%s'", tc.url, tc.header)
+		assert.Contains(rr.Body.String(), tc.response)
+	}
+}
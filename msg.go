@@ -2,6 +2,7 @@ package courier
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"strconv"
 	"strings"
@@ -56,6 +57,22 @@ func NewMsgUUIDFromString(uuidString string) MsgUUID {
 	return MsgUUID{uuid}
 }
 
+//-----------------------------------------------------------------------------
+// MsgPriority type
+//-----------------------------------------------------------------------------
+
+// MsgPriority is the priority of an outgoing message. The backend queues high priority messages so they are sent
+// before others, but bulk messages are queued the same as normal ones. Handlers can consume it to pick a faster or
+// cheaper route with their provider, e.g. by checking msg.Priority() == courier.MsgPriorityBulk in SendMsg.
+type MsgPriority string
+
+// Possible values for MsgPriority
+const (
+	MsgPriorityHigh   = MsgPriority("high")
+	MsgPriorityNormal = MsgPriority("normal")
+	MsgPriorityBulk   = MsgPriority("bulk")
+)
+
 //-----------------------------------------------------------------------------
 // Msg interface
 //-----------------------------------------------------------------------------
@@ -74,8 +91,12 @@ type Msg interface {
 
 	ReceivedOn() *time.Time
 	SentOn() *time.Time
+	SendAt() *time.Time
+	CreatedOn() time.Time
 
 	HighPriority() bool
+	Priority() MsgPriority
+	Metadata() json.RawMessage
 
 	WithContactName(name string) Msg
 	WithReceivedOn(date time.Time) Msg
@@ -83,6 +104,11 @@ type Msg interface {
 	WithID(id MsgID) Msg
 	WithUUID(uuid MsgUUID) Msg
 	WithAttachment(url string) Msg
+	WithSendAt(date time.Time) Msg
+	WithCreatedOn(date time.Time) Msg
+	WithPriority(priority MsgPriority) Msg
+	WithMetadata(key string, value interface{}) Msg
+	WithChannel(channel Channel) Msg
 
 	EventID() int64
 }
@@ -0,0 +1,257 @@
+package courier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the most msgs missing statuses we look up for each channel type each time we look
+const statusPollBatchSize = 100
+
+// how far back we look for msgs which never got a status report, which is also how long we keep polling for them
+const statusPollLookback = time.Hour * 24
+
+// polled msgs are first polled this long after being scheduled, backing off to at most every statusPollMaxDelay
+const statusPollInitialDelay = time.Minute * 5
+const statusPollMaxDelay = time.Hour
+
+// how often we check for statuses which are due to be polled
+const statusPollInterval = time.Second * 30
+
+// PollStatuses schedules polling for the status of msgs which have been waiting longer than our configured window for a
+// status report, for each handler that can poll its provider. Msgs already being polled, e.g. because their handler
+// scheduled them when they were sent, are left as they are.
+func (s *server) PollStatuses(ctx context.Context) error {
+	lister, isLister := s.backend.(UnconfirmedMsgLister)
+	if !isLister || s.config.StatusPollWindow <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	sentBefore := now.Add(-time.Duration(s.config.StatusPollWindow) * time.Minute)
+	sentAfter := now.Add(-statusPollLookback)
+
+	for channelType, handler := range activeHandlers {
+		poller, isPoller := handler.(StatusPollingHandler)
+		if !isPoller {
+			continue
+		}
+
+		msgs, err := lister.GetUnconfirmedMsgs(ctx, channelType, sentAfter, sentBefore, statusPollBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			if !s.polls.Scheduled(msg.ID()) {
+				s.polls.Schedule(msg, poller.PollStatus)
+			}
+		}
+	}
+
+	return nil
+}
+
+// PollScheduler returns the scheduler which polls for the statuses of msgs whose reports may never arrive
+func (s *server) PollScheduler() *PollScheduler { return s.polls }
+
+// startStatusPoller starts a goroutine which schedules polling for msgs missing statuses every minute until our server
+// is stopped
+func startStatusPoller(s *server) {
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+
+		log := logrus.WithField("comp", "poller")
+		log.WithField("state", "started").Info("status poller started")
+
+		for {
+			select {
+
+			// our server is shutting down, exit
+			case <-s.stopChan:
+				log.WithField("state", "stopped").Info("status poller stopped")
+				return
+
+			// every minute we look for any msgs which need polling
+			case <-time.After(time.Minute):
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+				err := s.PollStatuses(ctx)
+				cancel()
+				if err != nil {
+					log.WithError(err).Error("error polling statuses")
+				}
+			}
+		}
+	}()
+}
+
+// StatusQuery queries a provider for the current status of the passed in msg, returning a nil status if it is still
+// pending with the provider
+type StatusQuery func(context.Context, Msg) (MsgStatus, error)
+
+// PollScheduler reconciles the statuses of sent msgs whose delivery reports may never arrive by polling their provider.
+// Each scheduled msg is first polled after our initial delay, which then doubles after each poll up to our max delay,
+// until the msg has a final status or its deadline passes. Statuses which come back are written to our backend.
+type PollScheduler struct {
+	backend  Backend
+	initial  time.Duration
+	max      time.Duration
+	deadline time.Duration
+
+	mutex sync.Mutex
+	polls map[MsgID]*scheduledPoll
+	now   func() time.Time
+}
+
+type scheduledPoll struct {
+	msg      Msg
+	query    StatusQuery
+	delay    time.Duration
+	next     time.Time
+	deadline time.Time
+}
+
+// NewPollScheduler creates a new PollScheduler which writes statuses to the passed in backend
+func NewPollScheduler(backend Backend, initial time.Duration, max time.Duration, deadline time.Duration) *PollScheduler {
+	return &PollScheduler{
+		backend:  backend,
+		initial:  initial,
+		max:      max,
+		deadline: deadline,
+		polls:    make(map[MsgID]*scheduledPoll),
+		now:      time.Now,
+	}
+}
+
+// Schedule schedules polling for the status of the passed in msg using the passed in query, replacing any polling
+// already scheduled for it
+func (s *PollScheduler) Schedule(msg Msg, query StatusQuery) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.polls[msg.ID()] = &scheduledPoll{
+		msg:      msg,
+		query:    query,
+		delay:    s.initial,
+		next:     now.Add(s.initial),
+		deadline: now.Add(s.deadline),
+	}
+}
+
+// Cancel stops polling for the status of the msg with the passed in id, e.g. because its delivery report arrived
+func (s *PollScheduler) Cancel(id MsgID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.polls, id)
+}
+
+// CancelExternalID stops polling for the status of the msg on the passed in channel with the passed in external id, e.g.
+// because its delivery report arrived for that id
+func (s *PollScheduler) CancelExternalID(channel ChannelUUID, externalID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, poll := range s.polls {
+		if poll.msg.Channel().UUID() == channel && poll.msg.ExternalID() == externalID {
+			delete(s.polls, id)
+		}
+	}
+}
+
+// Scheduled returns whether we are polling for the status of the msg with the passed in id
+func (s *PollScheduler) Scheduled(id MsgID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, found := s.polls[id]
+	return found
+}
+
+// Pending returns the number of msgs we are polling the statuses of
+func (s *PollScheduler) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.polls)
+}
+
+// Poll polls for the status of every msg which is due, writing any statuses that come back. Msgs which get a final
+// status, or whose deadline has passed, are no longer polled, the rest are polled again after backing off.
+func (s *PollScheduler) Poll(ctx context.Context) {
+	s.mutex.Lock()
+	now := s.now()
+	due := make([]*scheduledPoll, 0)
+	for _, poll := range s.polls {
+		if !poll.next.After(now) {
+			due = append(due, poll)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, poll := range due {
+		final := s.poll(ctx, poll)
+
+		s.mutex.Lock()
+		if s.polls[poll.msg.ID()] == poll {
+			poll.delay *= 2
+			if poll.delay > s.max {
+				poll.delay = s.max
+			}
+			poll.next = s.now().Add(poll.delay)
+			if final || poll.next.After(poll.deadline) {
+				delete(s.polls, poll.msg.ID())
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// poll queries for the status of the passed in poll's msg, returning whether it now has a final status
+func (s *PollScheduler) poll(ctx context.Context, poll *scheduledPoll) bool {
+	log := logrus.WithField("comp", "poll_scheduler").WithField("msg_id", poll.msg.ID().String()).WithField("channel_uuid", poll.msg.Channel().UUID())
+
+	status, err := poll.query(ctx, poll.msg)
+	if err != nil {
+		log.WithError(err).Error("error polling msg status")
+		return false
+	}
+	if status == nil {
+		return false
+	}
+
+	err = s.backend.WriteMsgStatus(ctx, status)
+	if err != nil {
+		log.WithError(err).Error("error writing polled msg status")
+		return false
+	}
+	s.backend.WriteChannelLogs(ctx, status.Logs())
+
+	return status.Status() == MsgDelivered || status.Status() == MsgFailed
+}
+
+// Start starts a goroutine which polls for any statuses which are due every interval until the passed in stop channel
+// is closed
+func (s *PollScheduler) Start(interval time.Duration, stopChan chan bool, waitGroup *sync.WaitGroup) {
+	waitGroup.Add(1)
+
+	go func() {
+		defer waitGroup.Done()
+
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(interval):
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				s.Poll(ctx)
+				cancel()
+			}
+		}
+	}()
+}
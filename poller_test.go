@@ -0,0 +1,140 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/config"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollStatuses(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "DM", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	pending := mb.NewOutgoingMsg(channel, NewMsgID(10), urns.URN("tel:+250788383383"), "pending", false, nil).WithExternalID("pending")
+	delivered := mb.NewOutgoingMsg(channel, NewMsgID(11), urns.URN("tel:+250788383383"), "delivered", false, nil).WithExternalID("delivered")
+	mb.AddUnconfirmedMsg(pending)
+	mb.AddUnconfirmedMsg(delivered)
+
+	// looking for msgs missing statuses is disabled by default
+	server := NewServer(config.NewTest(), mb).(*server)
+	server.initializeChannelHandlers()
+	assert.NoError(t, server.PollStatuses(context.Background()))
+	assert.Equal(t, 0, server.polls.Pending())
+
+	// enable it, both our msgs are scheduled for polling, msgs already being polled aren't scheduled again
+	now := time.Now()
+	server.polls.now = func() time.Time { return now }
+	server.polls.Schedule(pending, func(ctx context.Context, msg Msg) (MsgStatus, error) { return nil, nil })
+	now = now.Add(statusPollInitialDelay / 2)
+
+	server.config.StatusPollWindow = 30
+	assert.NoError(t, server.PollStatuses(context.Background()))
+	assert.Equal(t, 2, server.polls.Pending())
+
+	// once they are due, only our delivered msg gets a status
+	now = now.Add(statusPollInitialDelay)
+	server.polls.Poll(context.Background())
+	assert.Equal(t, 1, server.polls.Pending())
+
+	status, err := mb.GetLastMsgStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, NewMsgID(11), status.ID())
+	assert.Equal(t, MsgDelivered, status.Status())
+	assert.Equal(t, 1, len(mb.msgStatuses))
+}
+
+func TestPollScheduler(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "DM", "2020", "US", nil)
+	msg1 := mb.NewOutgoingMsg(channel, NewMsgID(1), urns.URN("tel:+250788383383"), "Hi", false, nil)
+	msg2 := mb.NewOutgoingMsg(channel, NewMsgID(2), urns.URN("tel:+250788383384"), "Hi", false, nil)
+
+	now := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	scheduler := NewPollScheduler(mb, time.Minute, time.Minute*4, time.Minute*10)
+	scheduler.now = func() time.Time { return now }
+
+	// our query records when it was called and returns whatever status we want
+	var polledAt []time.Time
+	var result MsgStatusValue
+	var queryErr error
+	query := func(ctx context.Context, msg Msg) (MsgStatus, error) {
+		polledAt = append(polledAt, now)
+		if queryErr != nil || result == NilMsgStatus {
+			return nil, queryErr
+		}
+		return mb.NewMsgStatusForID(msg.Channel(), msg.ID(), result), nil
+	}
+
+	// run advances our clock a minute at a time, polling each time
+	run := func(minutes int) {
+		for i := 0; i < minutes; i++ {
+			now = now.Add(time.Minute)
+			scheduler.Poll(ctx)
+		}
+	}
+
+	// pending msgs are polled with exponential backoff up to our max delay, until their deadline passes
+	start := now
+	scheduler.Schedule(msg1, query)
+	assert.Equal(1, scheduler.Pending())
+	run(12)
+	assert.Equal([]time.Time{
+		start.Add(time.Minute),
+		start.Add(time.Minute * 3),
+		start.Add(time.Minute * 7),
+	}, polledAt)
+	assert.Equal(0, scheduler.Pending())
+
+	// errors are backed off from the same way
+	polledAt = nil
+	queryErr = errors.New("boom")
+	start = now
+	scheduler.Schedule(msg1, query)
+	run(3)
+	assert.Equal([]time.Time{start.Add(time.Minute), start.Add(time.Minute * 3)}, polledAt)
+	assert.Equal(1, scheduler.Pending())
+
+	// scheduling a msg again starts its polling over, intermediate statuses are written but polling continues
+	queryErr = nil
+	result = MsgSent
+	scheduler.Schedule(msg1, query)
+	run(1)
+	assert.Equal(1, scheduler.Pending())
+	status, _ := mb.GetLastMsgStatus()
+	assert.Equal(MsgSent, status.Status())
+
+	// final statuses are written and stop polling
+	result = MsgDelivered
+	run(2)
+	assert.Equal(0, scheduler.Pending())
+	status, _ = mb.GetLastMsgStatus()
+	assert.Equal(MsgDelivered, status.Status())
+	assert.Equal(NewMsgID(1), status.ID())
+
+	// cancelled msgs aren't polled
+	polledAt = nil
+	scheduler.Schedule(msg1, query)
+	scheduler.Schedule(msg2, query)
+	assert.True(scheduler.Scheduled(msg1.ID()))
+	scheduler.Cancel(msg1.ID())
+	assert.False(scheduler.Scheduled(msg1.ID()))
+	run(1)
+	assert.Equal(1, len(polledAt))
+	assert.Equal(0, scheduler.Pending())
+
+	// as are those cancelled by their external id on their channel
+	scheduler.Schedule(msg1.WithExternalID("ext1"), query)
+	scheduler.CancelExternalID(NilChannelUUID, "ext1")
+	assert.True(scheduler.Scheduled(msg1.ID()))
+	scheduler.CancelExternalID(channel.UUID(), "ext1")
+	assert.False(scheduler.Scheduled(msg1.ID()))
+}
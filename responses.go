@@ -1,14 +1,17 @@
 package courier
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
 	validator "gopkg.in/go-playground/validator.v9"
 )
 
@@ -26,10 +29,26 @@ func WriteError(ctx context.Context, w http.ResponseWriter, r *http.Request, err
 	return writeJSONResponse(ctx, w, http.StatusBadRequest, &errorResponse{errors})
 }
 
+// WriteUnauthorized writes a JSON response rejecting a request which didn't authenticate itself
+func WriteUnauthorized(ctx context.Context, w http.ResponseWriter, r *http.Request, details string) error {
+	return writeJSONResponse(ctx, w, http.StatusUnauthorized, &errorResponse{[]string{details}})
+}
+
+// WriteForbidden writes a JSON response with a 403 status for a request which isn't allowed from its client
+func WriteForbidden(ctx context.Context, w http.ResponseWriter, r *http.Request, details string) error {
+	return writeJSONResponse(ctx, w, http.StatusForbidden, &errorResponse{[]string{details}})
+}
+
 // WriteIgnored writes a JSON response for the passed in message
 func WriteIgnored(ctx context.Context, w http.ResponseWriter, r *http.Request, details string) error {
+	return WriteIgnoredWithStatus(ctx, w, r, http.StatusOK, details)
+}
+
+// WriteIgnoredWithStatus writes a JSON response for the passed in message with the passed in status code, for
+// providers which only stop retrying a request on a specific code
+func WriteIgnoredWithStatus(ctx context.Context, w http.ResponseWriter, r *http.Request, statusCode int, details string) error {
 	LogRequestIgnored(r, details)
-	return writeData(ctx, w, http.StatusOK, details, struct{}{})
+	return writeData(ctx, w, statusCode, details, struct{}{})
 }
 
 // WriteChannelEventSuccess writes a JSON response for the passed in event indicating we handled it
@@ -84,6 +103,77 @@ func WriteStatusSuccess(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	return writeData(ctx, w, http.StatusOK, "Status Update Accepted", statusesData{data})
 }
 
+// Ack is a fixed acknowledgement written in place of our default JSON responses when a handler's provider expects a
+// specific body before it considers a request handled, e.g. an empty body or {"status":"ok"}
+type Ack struct {
+	ContentType string
+	Body        string
+
+	// StatusTemplate, if set, is used for the body of acks for status updates instead of Body, it is executed with the
+	// AckStatus of each status update so that providers which expect their ids echoed back can have them
+	StatusTemplate *template.Template
+}
+
+// AckStatus is what an Ack's status template is executed with for each status update being acknowledged
+type AckStatus struct {
+	ID         string
+	ExternalID string
+	Status     MsgStatusValue
+}
+
+// NewAckStatusTemplate parses the passed in status template for an Ack, panicking if it is invalid. Templates use
+// text/template syntax and can use json to encode values, e.g. {{range .}}{{json .ID}}{{end}}
+func NewAckStatusTemplate(text string) *template.Template {
+	return template.Must(template.New("ack").Funcs(ackFuncs).Parse(text))
+}
+
+var ackFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// WriteMsgAck writes the passed in ack in response to a request which created the passed in msgs
+func WriteMsgAck(ctx context.Context, w http.ResponseWriter, r *http.Request, msgs []Msg, ack *Ack) error {
+	for _, msg := range msgs {
+		LogMsgReceived(r, msg)
+	}
+	return writeAck(w, ack)
+}
+
+// WriteStatusAck writes the passed in ack in response to a request which created the passed in status updates
+func WriteStatusAck(ctx context.Context, w http.ResponseWriter, r *http.Request, statuses []MsgStatus, ack *Ack) error {
+	for _, status := range statuses {
+		LogMsgStatusReceived(r, status)
+	}
+
+	if ack.StatusTemplate != nil {
+		data := make([]AckStatus, 0, len(statuses))
+		for _, status := range statuses {
+			data = append(data, AckStatus{ID: status.ID().String(), ExternalID: status.ExternalID(), Status: status.Status()})
+		}
+
+		// if our template can't be executed we still acknowledge with our fixed body, the updates were accepted
+		body := &bytes.Buffer{}
+		err := ack.StatusTemplate.Execute(body, data)
+		if err == nil {
+			return writeAck(w, &Ack{ContentType: ack.ContentType, Body: body.String()})
+		}
+		logrus.WithError(err).Error("error executing status ack template")
+	}
+	return writeAck(w, ack)
+}
+
+func writeAck(w http.ResponseWriter, ack *Ack) error {
+	if ack.ContentType != "" {
+		w.Header().Set("Content-Type", ack.ContentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(ack.Body))
+	return err
+}
+
 type errorResponse struct {
 	Errors []string `json:"errors"`
 }
@@ -125,6 +215,17 @@ type statusesData struct {
 	Statuses []statusData `json:"statuses"`
 }
 
+// WriteDataResponse writes a JSON response with the passed in message and data, for handlers whose routes respond
+// with something other than the msgs, statuses or events they received
+func WriteDataResponse(ctx context.Context, w http.ResponseWriter, statusCode int, message string, data interface{}) error {
+	return writeData(ctx, w, statusCode, message, data)
+}
+
+// writeRequestTooLarge writes a JSON response rejecting a request whose body is larger than the passed in limit
+func writeRequestTooLarge(ctx context.Context, w http.ResponseWriter, maxBytes int64) error {
+	return writeJSONResponse(ctx, w, http.StatusRequestEntityTooLarge, &errorResponse{[]string{fmt.Sprintf("request body larger than %d bytes", maxBytes)}})
+}
+
 func writeJSONResponse(ctx context.Context, w http.ResponseWriter, statusCode int, response interface{}) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
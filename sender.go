@@ -14,6 +14,7 @@ type Foreman struct {
 	server           Server
 	senders          []*Sender
 	availableSenders chan *Sender
+	logSampler       *ChannelLogSampler
 	quit             chan bool
 }
 
@@ -23,6 +24,7 @@ func NewForeman(server Server, maxSenders int) *Foreman {
 		server:           server,
 		senders:          make([]*Sender, maxSenders),
 		availableSenders: make(chan *Sender, maxSenders),
+		logSampler:       NewChannelLogSampler(server.Config().ChannelLogSampleRate),
 		quit:             make(chan bool),
 	}
 
@@ -80,8 +82,8 @@ func (f *Foreman) Assign() {
 			cancel()
 
 			if err == nil && msg != nil {
-				// if so, assign it to our sender
-				sender.job <- msg
+				// if so, assign it to our sender, along with any others waiting to be sent in bulk with it
+				sender.job <- f.popBatch(msg)
 				lastSleep = false
 			} else {
 				// we received an error getting the next message, log it
@@ -101,11 +103,43 @@ func (f *Foreman) Assign() {
 	}
 }
 
+// popBatch returns the passed in msg along with the next msgs waiting on the same channel if its handler can send in
+// bulk. We stop at the first msg on another channel, which is just sent after the rest.
+func (f *Foreman) popBatch(msg Msg) []Msg {
+	msgs := []Msg{msg}
+	if _, isBulk := activeHandlers[msg.Channel().ChannelType()].(BulkSendingHandler); !isBulk {
+		return msgs
+	}
+
+	for len(msgs) < bulkBatchSize {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		next, err := f.server.Backend().PopNextOutgoingMsg(ctx)
+		cancel()
+
+		if err != nil {
+			logrus.WithField("comp", "foreman").WithError(err).Error("error popping outgoing msg")
+			break
+		}
+		if next == nil {
+			break
+		}
+
+		msgs = append(msgs, next)
+		if next.Channel().UUID() != msg.Channel().UUID() {
+			break
+		}
+	}
+	return msgs
+}
+
+// the most msgs we pop to send in bulk at once
+const bulkBatchSize = 100
+
 // Sender is our type for a single goroutine that is sending messages
 type Sender struct {
 	id      int
 	foreman *Foreman
-	job     chan Msg
+	job     chan []Msg
 	log     *logrus.Entry
 }
 
@@ -114,7 +148,7 @@ func NewSender(foreman *Foreman, id int) *Sender {
 	sender := &Sender{
 		id:      id,
 		foreman: foreman,
-		job:     make(chan Msg, 1),
+		job:     make(chan []Msg, 1),
 	}
 	return sender
 }
@@ -133,15 +167,15 @@ func (w *Sender) Start() {
 			w.foreman.availableSenders <- w
 
 			// grab our next piece of work
-			msg := <-w.job
+			msgs := <-w.job
 
 			// exit if we were stopped
-			if msg == nil {
+			if msgs == nil {
 				log.Debug("stopped")
 				return
 			}
 
-			w.sendMessage(msg)
+			w.sendMessages(msgs)
 		}
 	}()
 }
@@ -151,73 +185,159 @@ func (w *Sender) Stop() {
 	close(w.job)
 }
 
-func (w *Sender) sendMessage(msg Msg) {
-	log := logrus.WithField("comp", "sender").WithField("sender_id", w.id)
-
-	var status MsgStatus
-	server := w.foreman.server
-	backend := server.Backend()
+// sendMessages sends the passed in msgs, those on the same channel being sent together in bulk
+func (w *Sender) sendMessages(msgs []Msg) {
+	statuses := make([]MsgStatus, len(msgs))
+	batches := make(map[ChannelUUID][]Msg)
+	indexes := make(map[ChannelUUID][]int)
+	channels := make([]ChannelUUID, 0, 1)
+
+	for i, msg := range msgs {
+		statuses[i] = w.prepareMessage(msg)
+		if statuses[i] != nil {
+			continue
+		}
 
-	// we don't want any individual send taking more than 35s
-	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
-	defer cancel()
+		uuid := msg.Channel().UUID()
+		if _, found := batches[uuid]; !found {
+			channels = append(channels, uuid)
+		}
+		batches[uuid] = append(batches[uuid], msg)
+		indexes[uuid] = append(indexes[uuid], i)
+	}
 
-	msgLog := log.WithField("msg_id", msg.ID().String()).WithField("msg_text", msg.Text()).WithField("msg_urn", msg.URN().Identity())
-	if len(msg.Attachments()) > 0 {
-		msgLog = msgLog.WithField("attachments", msg.Attachments())
+	for _, uuid := range channels {
+		var sent []MsgStatus
+		if len(batches[uuid]) == 1 {
+			sent = []MsgStatus{w.sendMessage(batches[uuid][0])}
+		} else {
+			sent = w.sendBulk(batches[uuid])
+		}
+		for i, status := range sent {
+			statuses[indexes[uuid][i]] = status
+		}
 	}
-	if len(msg.QuickReplies()) > 0 {
-		msgLog = msgLog.WithField("quick_replies", msg.QuickReplies())
+
+	for i, msg := range msgs {
+		w.writeStatus(msg, statuses[i])
 	}
+}
 
-	start := time.Now()
+// prepareMessage gets the passed in msg ready to send, returning a wired status for it if it was already sent
+func (w *Sender) prepareMessage(msg Msg) MsgStatus {
+	backend := w.foreman.server.Backend()
+	msgLog := w.msgLog(msg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
 
 	// was this msg already sent? (from a double queue?)
-	sent, err := backend.WasMsgSent(sendCTX, msg)
+	sent, err := backend.WasMsgSent(ctx, msg)
 
 	// failing on a lookup isn't a halting problem but we should log it
 	if err != nil {
 		msgLog.WithError(err).Warning("error looking up msg was sent")
 	}
 
+	// if this message was already sent, create a wired status for it
 	if sent {
-		// if this message was already sent, create a wired status for it
-		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
 		msgLog.Warning("duplicate send, marking as wired")
-	} else {
-		// send our message
-		status, err = server.SendMsg(sendCTX, msg)
-		duration := time.Now().Sub(start)
-		secondDuration := float64(duration) / float64(time.Second)
+		return backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
+	}
 
-		if err != nil {
-			msgLog.WithError(err).WithField("elapsed", duration).Error("error sending message")
-			if status == nil {
-				status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
-			}
+	// our backend may know of a better channel to reach this contact on, if so send on that instead
+	channel, err := ResolveReplyChannel(ctx, backend, msg)
+	if err != nil {
+		msgLog.WithError(err).Warning("error resolving reply channel")
+	} else if channel.UUID() != msg.Channel().UUID() {
+		msgLog.WithField("channel_uuid", channel.UUID()).Info("sending on resolved reply channel")
+		msg.WithChannel(channel)
+	}
+	return nil
+}
+
+// sendMessage sends the passed in msg by itself
+func (w *Sender) sendMessage(msg Msg) MsgStatus {
+	// we don't want any individual send taking more than 35s
+	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
+	defer cancel()
+
+	start := time.Now()
+	status, err := w.foreman.server.SendMsg(sendCTX, msg)
+	duration := time.Now().Sub(start)
+
+	if err != nil {
+		w.msgLog(msg).WithError(err).WithField("elapsed", duration).Error("error sending message")
+		if status == nil {
+			status = w.foreman.server.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
 		}
+	}
 
-		// report to librato and log locally
-		if status.Status() == MsgErrored || status.Status() == MsgFailed {
-			msgLog.WithField("elapsed", duration).Warning("msg errored")
-			librato.Default.AddGauge(fmt.Sprintf("courier.msg_send_error_%s", msg.Channel().ChannelType()), secondDuration)
-		} else {
-			msgLog.WithField("elapsed", duration).Info("msg sent")
-			librato.Default.AddGauge(fmt.Sprintf("courier.msg_send_%s", msg.Channel().ChannelType()), secondDuration)
+	w.reportSend(msg, status, duration)
+	return status
+}
+
+// sendBulk sends the passed in msgs, which are all on the same channel, together. If their handler can't send in
+// bulk, e.g. because they were moved to a reply channel of another type, they are sent one at a time instead.
+func (w *Sender) sendBulk(msgs []Msg) []MsgStatus {
+	// a bulk send is a single send, so gets the same time as any other
+	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
+	defer cancel()
+
+	start := time.Now()
+	statuses, err := w.foreman.server.SendMsgs(sendCTX, msgs)
+	duration := time.Now().Sub(start)
+
+	if err != nil {
+		logrus.WithField("comp", "sender").WithField("sender_id", w.id).WithField("channel_uuid", msgs[0].Channel().UUID()).
+			WithError(err).Warning("unable to send in bulk, sending one at a time")
+		statuses = make([]MsgStatus, len(msgs))
+		for i, msg := range msgs {
+			statuses[i] = w.sendMessage(msg)
 		}
+		return statuses
 	}
 
+	for i, msg := range msgs {
+		if statuses[i] == nil {
+			statuses[i] = w.foreman.server.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+		}
+		w.reportSend(msg, statuses[i], duration)
+	}
+	return statuses
+}
+
+// reportSend reports the outcome of sending the passed in msg to librato and logs it locally
+func (w *Sender) reportSend(msg Msg, status MsgStatus, duration time.Duration) {
+	msgLog := w.msgLog(msg)
+	secondDuration := float64(duration) / float64(time.Second)
+
+	if status.Status() == MsgErrored || status.Status() == MsgFailed {
+		msgLog.WithField("elapsed", duration).Warning("msg errored")
+		librato.Default.AddGauge(fmt.Sprintf("courier.msg_send_error_%s", msg.Channel().ChannelType()), secondDuration)
+	} else {
+		msgLog.WithField("elapsed", duration).Info("msg sent")
+		librato.Default.AddGauge(fmt.Sprintf("courier.msg_send_%s", msg.Channel().ChannelType()), secondDuration)
+	}
+}
+
+// writeStatus writes the passed in status and its logs for the passed in msg, and marks its send as complete
+func (w *Sender) writeStatus(msg Msg, status MsgStatus) {
+	msgLog := w.msgLog(msg)
+	backend := w.foreman.server.Backend()
+
 	// we allot 5 seconds to write our status to the db
 	writeCTX, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	err = backend.WriteMsgStatus(writeCTX, status)
+	err := backend.WriteMsgStatus(writeCTX, status)
 	if err != nil {
 		msgLog.WithError(err).Info("error writing msg status")
 	}
 
-	// write our logs as well
-	err = backend.WriteChannelLogs(writeCTX, status.Logs())
+	// write our logs as well, errored and failed sends are never sampled out
+	failed := status.Status() == MsgErrored || status.Status() == MsgFailed
+	err = backend.WriteChannelLogs(writeCTX, w.foreman.logSampler.Sample(status.Logs(), failed))
 	if err != nil {
 		msgLog.WithError(err).Info("error writing msg logs")
 	}
@@ -225,3 +345,16 @@ func (w *Sender) sendMessage(msg Msg) {
 	// mark our send task as complete
 	backend.MarkOutgoingMsgComplete(writeCTX, msg, status)
 }
+
+// msgLog returns a logger for the passed in msg
+func (w *Sender) msgLog(msg Msg) *logrus.Entry {
+	log := logrus.WithField("comp", "sender").WithField("sender_id", w.id)
+	msgLog := log.WithField("msg_id", msg.ID().String()).WithField("msg_text", msg.Text()).WithField("msg_urn", msg.URN().Identity())
+	if len(msg.Attachments()) > 0 {
+		msgLog = msgLog.WithField("attachments", msg.Attachments())
+	}
+	if len(msg.QuickReplies()) > 0 {
+		msgLog = msgLog.WithField("quick_replies", msg.QuickReplies())
+	}
+	return msgLog
+}
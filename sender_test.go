@@ -79,3 +79,65 @@ func TestSending(t *testing.T) {
 	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
 	assert.Equal(MsgWired, mb.msgStatuses[0].Status())
 }
+
+func TestSendingReplyChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+
+	s.Start()
+	defer s.Stop()
+
+	// our message is queued for a channel type we have no handler for, but our backend knows a better channel
+	xxChannel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	mb.SetReplyChannel("tel:+250788383383", dmChannel)
+
+	msg := &mockMsg{
+		channel: xxChannel,
+		id:      NewMsgID(103),
+		uuid:    NilMsgUUID,
+		text:    "test message",
+		urn:     "tel:+250788383383",
+	}
+	mb.PushOutgoingMsg(msg)
+	time.Sleep(time.Second)
+
+	// so it is sent on that channel instead
+	statuses := mb.WrittenMsgStatuses()
+	assert.Equal(1, len(statuses))
+	assert.Equal(MsgSent, statuses[0].Status())
+	assert.Equal(dmChannel.UUID(), statuses[0].ChannelUUID())
+}
+
+func TestSendingInBulk(t *testing.T) {
+	assert := assert.New(t)
+
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+
+	// queue our messages before we start so they are all waiting when they are popped
+	xxChannel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	for i := 0; i < 3; i++ {
+		mb.PushOutgoingMsg(&mockMsg{channel: dmChannel, id: NewMsgID(int64(201 + i)), uuid: NilMsgUUID, text: "broadcast", urn: "tel:+250788383383"})
+	}
+	mb.PushOutgoingMsg(&mockMsg{channel: xxChannel, id: NewMsgID(204), uuid: NilMsgUUID, text: "other", urn: "tel:+250788383383"})
+
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second)
+
+	// the msgs on our bulk sending channel are sent together, the one on another channel by itself
+	handler := activeHandlers[ChannelType("DM")].(*dummyHandler)
+	assert.Equal([][]MsgID{{NewMsgID(201), NewMsgID(202), NewMsgID(203)}}, handler.BulkSends())
+
+	statuses := mb.WrittenMsgStatuses()
+	assert.Equal(4, len(statuses))
+	for _, status := range statuses[:3] {
+		assert.Equal(MsgSent, status.Status())
+	}
+	assert.Equal(NewMsgID(204), statuses[3].ID())
+	assert.Equal(MsgErrored, statuses[3].Status())
+}
@@ -3,12 +3,16 @@ package courier
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,8 +32,13 @@ type Server interface {
 	Config() *config.Courier
 
 	AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) error
+	AddHandlerMiddleware(middleware HandlerMiddleware)
 
 	SendMsg(context.Context, Msg) (MsgStatus, error)
+	SendMsgs(context.Context, []Msg) ([]MsgStatus, error)
+	PollStatuses(context.Context) error
+	PollScheduler() *PollScheduler
+	SilentChannels(context.Context, time.Duration) ([]ChannelUUID, error)
 
 	Backend() Backend
 
@@ -54,11 +63,14 @@ func NewServer(config *config.Courier, backend Backend) Server {
 // NewServerWithLogger creates a new Server for the passed in configuration. The server will have to be started
 // afterwards, which is when configuration options are checked.
 func NewServerWithLogger(config *config.Courier, backend Backend, logger *logrus.Logger) Server {
+	// we parse our trusted proxies once here, if any are invalid we refuse to start
+	trustedProxies, trustedProxiesErr := ParseCIDRs(config.TrustedProxies)
+
 	router := chi.NewRouter()
 	router.Use(middleware.DefaultCompress)
 	router.Use(middleware.StripSlashes)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
+	router.Use(trustedRealIP(trustedProxies))
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.Timeout(15 * time.Second))
 
@@ -71,6 +83,10 @@ func NewServerWithLogger(config *config.Courier, backend Backend, logger *logrus
 
 		router:     router,
 		chanRouter: chanRouter,
+		logSampler: NewChannelLogSampler(config.ChannelLogSampleRate),
+		polls:      NewPollScheduler(backend, statusPollInitialDelay, statusPollMaxDelay, statusPollLookback),
+
+		trustedProxiesErr: trustedProxiesErr,
 
 		stopChan:  make(chan bool),
 		waitGroup: &sync.WaitGroup{},
@@ -92,6 +108,11 @@ func (s *server) Start() error {
 		librato.Default.Start()
 	}
 
+	// check our trusted proxies before we rely on them to tell us who our clients are
+	if s.trustedProxiesErr != nil {
+		return fmt.Errorf("invalid trusted proxies: %s", s.trustedProxiesErr)
+	}
+
 	// start our backend
 	err := s.backend.Start()
 	if err != nil {
@@ -101,11 +122,29 @@ func (s *server) Start() error {
 	// start our spool flushers
 	startSpoolFlushers(s)
 
+	// and our status polling, msgs which are missing statuses are only looked for if that is enabled
+	s.polls.Start(statusPollInterval, s.stopChan, s.waitGroup)
+	if s.config.StatusPollWindow > 0 {
+		startStatusPoller(s)
+	}
+
+	// and our sweeper for msgs whose delivery reports never arrived, if that is enabled
+	if s.config.FailUnreportedMsgs {
+		startDeliveryReportSweeper(s)
+	}
+
 	// wire up our main pages
 	s.router.NotFound(s.handle404)
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
 	s.router.Get("/status", s.handleStatus)
+	s.router.Post("/replay/{id:[0-9]+}", s.handleReplay)
+
+	// build the middleware chain our handler routes are wrapped in
+	err = s.configureMiddleware()
+	if err != nil {
+		return err
+	}
 
 	// initialize our handlers
 	s.initializeChannelHandlers()
@@ -189,10 +228,64 @@ func (s *server) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
 		return nil, fmt.Errorf("unable to find handler for channel type: %s", msg.Channel().ChannelType())
 	}
 
+	// wait for a free slot if this handler limits concurrent sends
+	if limited, isLimited := handler.(SendLimitedHandler); isLimited {
+		release, err := limited.AcquireSend(ctx, msg.Channel())
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
 	// have the handler send it
 	return handler.SendMsg(ctx, msg)
 }
 
+// SendMsgs sends the passed in msgs, which must all be on the same channel, in bulk. An error is returned if the
+// handler for their channel can't send in bulk, otherwise a status is returned for each msg in order.
+func (s *server) SendMsgs(ctx context.Context, msgs []Msg) ([]MsgStatus, error) {
+	channel := msgs[0].Channel()
+	handler, found := activeHandlers[channel.ChannelType()]
+	if !found {
+		return nil, fmt.Errorf("unable to find handler for channel type: %s", channel.ChannelType())
+	}
+	bulk, isBulk := handler.(BulkSendingHandler)
+	if !isBulk {
+		return nil, fmt.Errorf("handler for channel type %s can't send in bulk", channel.ChannelType())
+	}
+
+	// a bulk send takes a single slot if this handler limits concurrent sends
+	if limited, isLimited := handler.(SendLimitedHandler); isLimited {
+		release, err := limited.AcquireSend(ctx, channel)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	return bulk.SendMsgs(ctx, msgs), nil
+}
+
+// SilentChannels returns the channels which have received msgs before but haven't received any for longer than the
+// passed in threshold, which can mean their provider is having an outage. Channels are ordered longest silent first.
+func (s *server) SilentChannels(ctx context.Context, threshold time.Duration) ([]ChannelUUID, error) {
+	lastReceived, err := s.backend.GetLastReceived(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-threshold)
+	silent := make([]ChannelUUID, 0)
+	for uuid, receivedOn := range lastReceived {
+		if receivedOn.Before(cutoff) {
+			silent = append(silent, uuid)
+		}
+	}
+
+	sort.Slice(silent, func(i, j int) bool { return lastReceived[silent[i]].Before(lastReceived[silent[j]]) })
+	return silent, nil
+}
+
 func (s *server) WaitGroup() *sync.WaitGroup { return s.waitGroup }
 func (s *server) StopChan() chan bool        { return s.stopChan }
 func (s *server) Config() *config.Courier    { return s.config }
@@ -208,7 +301,11 @@ type server struct {
 	router     *chi.Mux
 	chanRouter *chi.Mux
 
-	foreman *Foreman
+	foreman    *Foreman
+	logSampler *ChannelLogSampler
+	polls      *PollScheduler
+
+	trustedProxiesErr error
 
 	config *config.Courier
 
@@ -216,7 +313,9 @@ type server struct {
 	stopChan  chan bool
 	stopped   bool
 
-	routes []string
+	routes          []string
+	middlewares     []HandlerMiddleware
+	middlewareMutex sync.RWMutex
 }
 
 func (s *server) initializeChannelHandlers() {
@@ -267,10 +366,34 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 
 		r = r.WithContext(ctx)
 
+		// channels with a callback token reject requests which don't include it
+		if !checkCallbackToken(channel, r) {
+			logrus.WithField("channel_uuid", channel.UUID()).WithField("url", r.URL.String()).Warning("request with missing or invalid callback token")
+			writeJSONResponse(ctx, w, http.StatusUnauthorized, &errorResponse{[]string{"missing or invalid callback token"}})
+			return
+		}
+
+		// don't let anyone make us read more than our limit
+		maxBytes := int64(s.config.MaxRequestBytes)
+		if maxBytes > 0 {
+			if r.ContentLength > maxBytes {
+				writeRequestTooLarge(ctx, w, maxBytes)
+				return
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+		}
+
 		// read the bytes from our body so we can create a channel log for this request
 		response := &bytes.Buffer{}
 		request, err := httputil.DumpRequest(r, true)
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeRequestTooLarge(ctx, w, maxBytes)
+				return
+			}
 			WriteError(ctx, w, r, err)
 			return
 		}
@@ -280,6 +403,22 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		ww.Tee(response)
 
 		logs := make([]*ChannelLog, 0, 1)
+		failed := false
+
+		// a handler which panics on a bad payload shouldn't take us down, we log the request which caused it against
+		// its channel
+		defer func() {
+			if p := recover(); p != nil {
+				err := fmt.Errorf("panic handling request: %v", p)
+				logrus.WithError(err).WithField("url", url).WithField("request", string(request)).WithField("stack", string(debug.Stack())).Error("panic receiving message")
+				writeJSONResponse(ctx, ww, http.StatusInternalServerError, &errorResponse{[]string{"internal server error"}})
+
+				log := NewChannelLog("Channel Error", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), time.Now().Sub(start), err)
+				if err := s.backend.WriteChannelLogs(ctx, []*ChannelLog{log}); err != nil {
+					logrus.WithError(err).Error("error writing channel log")
+				}
+			}
+		}()
 
 		events, err := handlerFunc(ctx, channel, ww, r)
 		duration := time.Now().Sub(start)
@@ -307,13 +446,15 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 				logs = append(logs, NewChannelLog("Event Received", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
 				librato.Default.AddGauge(fmt.Sprintf("courier.evt_receive_%s", channel.ChannelType()), secondDuration)
 			case MsgStatus:
-				logs = append(logs, NewChannelLog("Status Updated", channel, e.ID(), r.Method, url, ww.Status(), string(request), response.String(), duration, err))
+				logs = append(logs, NewChannelLog("Status Updated", channel, e.ID(), r.Method, url, ww.Status(), string(request), response.String(), duration, err).WithCorrelationID(e.CorrelationID()))
+				logs = append(logs, e.Logs()...)
+				failed = failed || e.Status() == MsgErrored || e.Status() == MsgFailed
 				librato.Default.AddGauge(fmt.Sprintf("courier.msg_status_%s", channel.ChannelType()), secondDuration)
 			}
 		}
 
-		// and write these out
-		err = s.backend.WriteChannelLogs(ctx, logs)
+		// and write these out, errors are never sampled out
+		err = s.backend.WriteChannelLogs(ctx, s.logSampler.Sample(logs, failed || err != nil))
 
 		// log any error writing our channel log but don't break the request
 		if err != nil {
@@ -322,16 +463,82 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 	}
 }
 
+// where requests to the routes of channels with a callback token can include it
+const (
+	CallbackTokenParam  = "token"
+	CallbackTokenHeader = "X-Callback-Token"
+)
+
+// checkCallbackToken returns whether the passed in request includes the callback token of the passed in channel, or
+// true if the channel doesn't have one
+func checkCallbackToken(channel Channel, r *http.Request) bool {
+	token := channel.StringConfigForKey(ConfigCallbackToken, "")
+	if token == "" {
+		return true
+	}
+
+	given := r.Header.Get(CallbackTokenHeader)
+	if given == "" {
+		given = r.URL.Query().Get(CallbackTokenParam)
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
 func (s *server) AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) error {
 	method = strings.ToLower(method)
 	channelType := strings.ToLower(string(handler.ChannelType()))
 
 	path := fmt.Sprintf("/%s/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/%s", channelType, action)
-	s.chanRouter.Method(method, path, s.channelHandleWrapper(handler, handlerFunc))
+	s.chanRouter.Method(method, path, s.wrapWithMiddleware(handler, s.channelHandleWrapper(handler, handlerFunc)))
 	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), action))
 	return nil
 }
 
+// AddHandlerMiddleware adds the passed in middleware to the chain wrapping every channel handler route, middleware
+// is applied in the order added, the first added being outermost
+func (s *server) AddHandlerMiddleware(middleware HandlerMiddleware) {
+	s.middlewareMutex.Lock()
+	defer s.middlewareMutex.Unlock()
+
+	s.middlewares = append(s.middlewares, middleware)
+}
+
+// configureMiddleware adds the middleware in our config to the chain wrapping our channel handler routes, followed by
+// our allowed IPs so that rejected requests are still logged and timed. This is always added as channels can have
+// their own allowed IPs.
+func (s *server) configureMiddleware() error {
+	for _, name := range s.config.HandlerMiddleware {
+		middleware, found := namedMiddleware[strings.TrimSpace(name)]
+		if !found {
+			return fmt.Errorf("unknown handler middleware: '%s'", name)
+		}
+		s.AddHandlerMiddleware(middleware)
+	}
+
+	allowed, err := parseAllowedIPs(s.config.AllowedIPs)
+	if err != nil {
+		return err
+	}
+	allowIPs, err := NewAllowIPsMiddleware(s.backend, allowed)
+	if err != nil {
+		return fmt.Errorf("invalid allowed IPs: %s", err)
+	}
+	s.AddHandlerMiddleware(allowIPs)
+	return nil
+}
+
+// wrapWithMiddleware wraps the passed in route in our middleware chain. The chain is built per request so that
+// middleware added after routes are registered is still applied.
+func (s *server) wrapWithMiddleware(handler ChannelHandler, route http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.middlewareMutex.RLock()
+		middlewares := s.middlewares
+		s.middlewareMutex.RUnlock()
+
+		chainMiddleware(handler, route, middlewares).ServeHTTP(w, r)
+	}
+}
+
 func prependHeaders(body string, statusCode int, resp http.ResponseWriter) string {
 	output := &bytes.Buffer{}
 	output.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode)))
@@ -397,6 +604,70 @@ func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf.Bytes())
 }
 
+// IsAdminRequest returns whether the passed in request carries our admin token, which is needed to use our maintenance
+// endpoints. No request is an admin one if we have no admin token configured.
+func IsAdminRequest(config *config.Courier, r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+	expected := fmt.Sprintf("Token %s", config.AdminToken)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// handleReplay re-issues the outgoing request captured in a stored channel log, returning the fresh response. This
+// is purely a debugging aid, no message state is changed and no new channel log is written.
+func (s *server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if !IsAdminRequest(s.config, r) {
+		WriteUnauthorized(r.Context(), w, r, "invalid or missing admin token")
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	log, err := s.backend.GetChannelLog(r.Context(), id)
+	if err != nil {
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	req, err := log.NewRequest()
+	if err != nil {
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	// the replayed request's own failure is a valid result, it's what we are here to see
+	rr, err := utils.MakeHTTPRequest(req)
+	errString := ""
+	if err != nil {
+		errString = err.Error()
+	}
+
+	writeData(r.Context(), w, http.StatusOK, "Log Replayed", &replayData{
+		rr.Method,
+		rr.URL,
+		rr.StatusCode,
+		rr.Request,
+		rr.Response,
+		errString,
+		float64(rr.Elapsed) / float64(time.Millisecond),
+	})
+}
+
+type replayData struct {
+	Method     string  `json:"method"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code"`
+	Request    string  `json:"request"`
+	Response   string  `json:"response"`
+	Error      string  `json:"error,omitempty"`
+	ElapsedMS  float64 `json:"elapsed_ms"`
+}
+
 // for use in request.Context
 type contextKey int
 
@@ -1,7 +1,11 @@
 package courier
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,8 +20,10 @@ func TestServer(t *testing.T) {
 	config := config.NewTest()
 	config.StatusUsername = "admin"
 	config.StatusPassword = "password123"
+	config.AdminToken = "sesame"
 
-	server := NewServerWithLogger(config, NewMockBackend(), logger)
+	mb := NewMockBackend()
+	server := NewServerWithLogger(config, mb, logger)
 	server.Start()
 	defer server.Stop()
 
@@ -54,4 +60,63 @@ func TestServer(t *testing.T) {
 	rr, err = utils.MakeHTTPRequest(req)
 	assert.Error(t, err)
 	assert.Contains(t, string(rr.Body), "method not allowed")
+
+	// log a request against a test provider we can replay
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+		w.Write([]byte(`{"replayed": true}`))
+	}))
+	defer provider.Close()
+
+	req, _ = http.NewRequest("POST", provider.URL+"/send", strings.NewReader(`{"text": "hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "DM", "2020", "US", nil)
+	mb.WriteChannelLogs(context.Background(), []*ChannelLog{NewChannelLogFromRR("Message Sent", channel, NewMsgID(10), rr)})
+
+	// replay without our admin token
+	req, _ = http.NewRequest("POST", "http://localhost:8080/replay/1", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Equal(t, 401, rr.StatusCode)
+
+	// replay a log that doesn't exist
+	req, _ = http.NewRequest("POST", "http://localhost:8080/replay/2", nil)
+	req.Header.Set("Authorization", "Token sesame")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Contains(t, string(rr.Body), "channel log not found")
+
+	// replay our log
+	req, _ = http.NewRequest("POST", "http://localhost:8080/replay/1", nil)
+	req.Header.Set("Authorization", "Token sesame")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"status_code":202`)
+	assert.Contains(t, string(rr.Body), fmt.Sprintf(`"url":"%s/send"`, provider.URL))
+	assert.Contains(t, string(rr.Body), `{\"replayed\": true}`)
+}
+
+func TestSilentChannels(t *testing.T) {
+	mb := NewMockBackend()
+	server := NewServer(config.NewTest(), mb)
+
+	quiet := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil)
+	quieter := NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "IB", "2021", "US", nil)
+	busy := NewMockChannel("5f4a7e1b-6a5c-4d8e-9a77-2b0b6f0e7c21", "IB", "2022", "US", nil)
+
+	ctx := context.Background()
+	mb.MarkChannelReceived(ctx, quiet, time.Now().Add(-time.Hour*2))
+	mb.MarkChannelReceived(ctx, quieter, time.Now().Add(-time.Hour*5))
+	mb.MarkChannelReceived(ctx, busy, time.Now().Add(-time.Minute))
+
+	silent, err := server.SilentChannels(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []ChannelUUID{quieter.UUID(), quiet.UUID()}, silent)
+
+	silent, err = server.SilentChannels(ctx, time.Hour*3)
+	assert.NoError(t, err)
+	assert.Equal(t, []ChannelUUID{quieter.UUID()}, silent)
 }
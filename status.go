@@ -1,5 +1,10 @@
 package courier
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // MsgStatusValue is the status of a message
 type MsgStatusValue string
 
@@ -15,6 +20,12 @@ const (
 	NilMsgStatus MsgStatusValue = ""
 )
 
+// MsgPrice is what a provider charged for sending a message, as reported on its status
+type MsgPrice struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
 //-----------------------------------------------------------------------------
 // MsgStatusUpdate Interface
 //-----------------------------------------------------------------------------
@@ -32,6 +43,21 @@ type MsgStatus interface {
 	Status() MsgStatusValue
 	SetStatus(MsgStatusValue)
 
+	RetryAfter() time.Duration
+	SetRetryAfter(time.Duration)
+
+	CorrelationID() string
+	SetCorrelationID(string)
+
+	Price() *MsgPrice
+	SetPrice(*MsgPrice)
+
+	CampaignReference() string
+	SetCampaignReference(string)
+
+	Metadata() json.RawMessage
+	SetMetadata(key string, value interface{})
+
 	Logs() []*ChannelLog
 	AddLog(log *ChannelLog)
 }
@@ -0,0 +1,84 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the most overdue msgs we fail for each channel type each time we sweep
+const sweepBatchSize = 100
+
+// FailUnreportedMsgs fails the msgs of each handler that declares a delivery report window which are still sent once
+// that window has passed, as their provider is never going to tell us what happened to them
+func (s *server) FailUnreportedMsgs(ctx context.Context) error {
+	lister, isLister := s.backend.(SentMsgLister)
+	if !isLister {
+		return nil
+	}
+
+	now := time.Now()
+
+	for channelType, handler := range activeHandlers {
+		reporting, isReporting := handler.(DeliveryReportingHandler)
+		if !isReporting || reporting.DeliveryReportWindow() <= 0 {
+			continue
+		}
+		window := reporting.DeliveryReportWindow()
+
+		msgs, err := lister.GetSentMsgs(ctx, channelType, now.Add(-window), sweepBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			log := logrus.WithField("comp", "sweeper").WithField("msg_id", msg.ID().String()).WithField("channel_uuid", msg.Channel().UUID())
+
+			status := s.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
+			status.AddLog(NewChannelLog("Delivery Report Timeout", msg.Channel(), msg.ID(), "", "", NilStatusCode, "", "", 0,
+				fmt.Errorf("no delivery report received within %s of sending", window)))
+
+			err = s.backend.WriteMsgStatus(ctx, status)
+			if err != nil {
+				log.WithError(err).Error("error failing msg without delivery report")
+				continue
+			}
+			s.backend.WriteChannelLogs(ctx, status.Logs())
+		}
+	}
+
+	return nil
+}
+
+// startDeliveryReportSweeper starts a goroutine which fails msgs whose delivery reports are overdue every five minutes
+// until our server is stopped
+func startDeliveryReportSweeper(s *server) {
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+
+		log := logrus.WithField("comp", "sweeper")
+		log.WithField("state", "started").Info("delivery report sweeper started")
+
+		for {
+			select {
+
+			// our server is shutting down, exit
+			case <-s.stopChan:
+				log.WithField("state", "stopped").Info("delivery report sweeper stopped")
+				return
+
+			// every five minutes we fail any msgs whose delivery reports are overdue
+			case <-time.After(time.Minute * 5):
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute*4)
+				err := s.FailUnreportedMsgs(ctx)
+				cancel()
+				if err != nil {
+					log.WithError(err).Error("error failing msgs without delivery reports")
+				}
+			}
+		}
+	}()
+}
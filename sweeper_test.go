@@ -0,0 +1,38 @@
+package courier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nyaruka/courier/config"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailUnreportedMsgs(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "DM", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	server := NewServer(config.NewTest(), mb).(*server)
+	server.initializeChannelHandlers()
+
+	// nothing to fail
+	assert.NoError(t, server.FailUnreportedMsgs(context.Background()))
+	assert.Equal(t, 0, len(mb.msgStatuses))
+
+	mb.AddUnreportedMsg(mb.NewOutgoingMsg(channel, NewMsgID(10), urns.URN("tel:+250788383383"), "unreported", false, nil))
+	assert.NoError(t, server.FailUnreportedMsgs(context.Background()))
+
+	// our msg is failed and we log why
+	status, err := mb.GetLastMsgStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, NewMsgID(10), status.ID())
+	assert.Equal(t, MsgFailed, status.Status())
+	assert.Equal(t, 1, len(mb.msgStatuses))
+
+	log, err := mb.GetChannelLog(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Delivery Report Timeout", log.Description)
+	assert.Equal(t, "no delivery report received within 4h0m0s of sending", log.Error)
+}
@@ -2,12 +2,14 @@ package courier
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
 
 	"time"
 
+	"github.com/buger/jsonparser"
 	_ "github.com/lib/pq" // postgres driver
 	"github.com/nyaruka/courier/config"
 	"github.com/nyaruka/gocommon/urns"
@@ -27,17 +29,27 @@ type MockBackend struct {
 	outgoingMsgs    []Msg
 	msgStatuses     []MsgStatus
 	channelEvents   []ChannelEvent
+	channelLogs     []*ChannelLog
+	channelErrors   []*ChannelError
+	unconfirmedMsgs []Msg
+	unreportedMsgs  []Msg
+	replyChannels   map[urns.URN]Channel
+	lastReceived    map[ChannelUUID]time.Time
 	lastContactName string
 
 	stoppedMsgContacts []Msg
 	sentMsgs           map[MsgID]bool
+	attachments        map[string][]byte
 }
 
 // NewMockBackend returns a new mock backend suitable for testing
 func NewMockBackend() *MockBackend {
 	return &MockBackend{
-		channels: make(map[ChannelUUID]Channel),
-		sentMsgs: make(map[MsgID]bool),
+		channels:      make(map[ChannelUUID]Channel),
+		sentMsgs:      make(map[MsgID]bool),
+		attachments:   make(map[string][]byte),
+		lastReceived:  make(map[ChannelUUID]time.Time),
+		replyChannels: make(map[urns.URN]Channel),
 	}
 }
 
@@ -65,6 +77,30 @@ func (mb *MockBackend) GetLastMsgStatus() (MsgStatus, error) {
 	return mb.msgStatuses[len(mb.msgStatuses)-1], nil
 }
 
+// WrittenMsgs returns all the messages written to this backend, in the order they were written
+func (mb *MockBackend) WrittenMsgs() []Msg {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return append([]Msg(nil), mb.queueMsgs...)
+}
+
+// WrittenMsgStatuses returns all the status updates written to this backend, in the order they were written
+func (mb *MockBackend) WrittenMsgStatuses() []MsgStatus {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return append([]MsgStatus(nil), mb.msgStatuses...)
+}
+
+// WrittenChannelEvents returns all the channel events written to this backend, in the order they were written
+func (mb *MockBackend) WrittenChannelEvents() []ChannelEvent {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return append([]ChannelEvent(nil), mb.channelEvents...)
+}
+
 // GetLastContactName returns the contact name set on the last msg or channel event written
 func (mb *MockBackend) GetLastContactName() string {
 	return mb.lastContactName
@@ -72,12 +108,17 @@ func (mb *MockBackend) GetLastContactName() string {
 
 // NewIncomingMsg creates a new message from the given params
 func (mb *MockBackend) NewIncomingMsg(channel Channel, urn urns.URN, text string) Msg {
-	return &mockMsg{channel: channel, urn: urn, text: text}
+	return &mockMsg{channel: channel, urn: urn, text: text, createdOn: time.Now()}
 }
 
 // NewOutgoingMsg creates a new outgoing message from the given params
 func (mb *MockBackend) NewOutgoingMsg(channel Channel, id MsgID, urn urns.URN, text string, highPriority bool, replies []string) Msg {
-	return &mockMsg{channel: channel, id: id, urn: urn, text: text, highPriority: highPriority, quickReplies: replies}
+	return &mockMsg{channel: channel, id: id, urn: urn, text: text, highPriority: highPriority, quickReplies: replies, createdOn: time.Now()}
+}
+
+// NewTestMsg creates a new outgoing message to be sent directly rather than through our queue
+func (mb *MockBackend) NewTestMsg(channel Channel, urn urns.URN, text string) Msg {
+	return &mockMsg{channel: channel, urn: urn, text: text, uuid: NewMsgUUID(), createdOn: time.Now()}
 }
 
 // PushOutgoingMsg is a test method to add a message to our queue of messages to send
@@ -133,9 +174,80 @@ func (mb *MockBackend) MarkOutgoingMsgComplete(ctx context.Context, msg Msg, s M
 
 // WriteChannelLogs writes the passed in channel logs to the DB
 func (mb *MockBackend) WriteChannelLogs(ctx context.Context, logs []*ChannelLog) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.channelLogs = append(mb.channelLogs, logs...)
+	return nil
+}
+
+// WriteChannelError writes the passed in channel error, for our mock we just remember it
+func (mb *MockBackend) WriteChannelError(ctx context.Context, channelError *ChannelError) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.channelErrors = append(mb.channelErrors, channelError)
 	return nil
 }
 
+// GetChannelErrors returns the channel errors written so far
+func (mb *MockBackend) GetChannelErrors() []*ChannelError {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.channelErrors
+}
+
+// MarkChannelReceived records when the passed in channel last received a msg
+func (mb *MockBackend) MarkChannelReceived(ctx context.Context, channel Channel, receivedOn time.Time) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.lastReceived[channel.UUID()] = receivedOn
+	return nil
+}
+
+// GetLastReceived returns when each channel last received a msg
+func (mb *MockBackend) GetLastReceived(ctx context.Context) (map[ChannelUUID]time.Time, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	lastReceived := make(map[ChannelUUID]time.Time, len(mb.lastReceived))
+	for uuid, receivedOn := range mb.lastReceived {
+		lastReceived[uuid] = receivedOn
+	}
+	return lastReceived, nil
+}
+
+// GetChannelLog returns the channel log with the passed in id, for our mock ids are 1 based positions in our written logs
+func (mb *MockBackend) GetChannelLog(ctx context.Context, id int64) (*ChannelLog, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if id < 1 || id > int64(len(mb.channelLogs)) {
+		return nil, ErrChannelLogNotFound
+	}
+	return mb.channelLogs[id-1], nil
+}
+
+// SaveAttachment saves the passed in attachment data, for our mock we just remember it and return a fake URL
+func (mb *MockBackend) SaveAttachment(ctx context.Context, channel Channel, contentType string, data []byte, extension string) (string, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	url := fmt.Sprintf("https://backend.com/attachments/%s.%s", NewMsgUUID(), extension)
+	mb.attachments[url] = data
+	return url, nil
+}
+
+// GetAttachment returns the data saved for the passed in attachment URL
+func (mb *MockBackend) GetAttachment(url string) []byte {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.attachments[url]
+}
+
 // SetErrorOnQueue is a mock method which makes the QueueMsg call throw the passed in error on next call
 func (mb *MockBackend) SetErrorOnQueue(shouldError bool) {
 	mb.errorOnQueue = shouldError
@@ -147,6 +259,9 @@ func (mb *MockBackend) WriteMsg(ctx context.Context, m Msg) error {
 		return errors.New("unable to queue message")
 	}
 
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
 	mb.queueMsgs = append(mb.queueMsgs, m)
 	mb.lastContactName = m.(*mockMsg).contactName
 	return nil
@@ -214,6 +329,81 @@ func (mb *MockBackend) AddChannel(channel Channel) {
 	mb.channels[channel.UUID()] = channel
 }
 
+// ResolveChannel returns the channel with the passed in type and address, or nil if there isn't one
+func (mb *MockBackend) ResolveChannel(ctx context.Context, channelType ChannelType, to string, from urns.URN) (Channel, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	for _, channel := range mb.channels {
+		if channel.ChannelType() == channelType && channel.Address() == to {
+			return channel, nil
+		}
+	}
+	return nil, nil
+}
+
+// SetReplyChannel sets the channel msgs to the passed in URN will be sent on by ResolveReplyChannel
+func (mb *MockBackend) SetReplyChannel(urn urns.URN, channel Channel) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.replyChannels[urn] = channel
+}
+
+// ResolveReplyChannel returns the channel set for the URN of the passed in msg, or nil if there isn't one
+func (mb *MockBackend) ResolveReplyChannel(ctx context.Context, msg Msg) (Channel, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.replyChannels[msg.URN()], nil
+}
+
+// AddUnconfirmedMsg adds a msg which will be returned by GetUnconfirmedMsgs
+func (mb *MockBackend) AddUnconfirmedMsg(msg Msg) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.unconfirmedMsgs = append(mb.unconfirmedMsgs, msg)
+}
+
+// GetUnconfirmedMsgs returns the unconfirmed msgs we've been given for the passed in channel type, our mock ignores
+// when they were sent
+func (mb *MockBackend) GetUnconfirmedMsgs(ctx context.Context, channelType ChannelType, sentAfter time.Time, sentBefore time.Time, limit int) ([]Msg, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	msgs := make([]Msg, 0)
+	for _, msg := range mb.unconfirmedMsgs {
+		if msg.Channel().ChannelType() == channelType && len(msgs) < limit {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// AddUnreportedMsg adds a msg which will be returned by GetSentMsgs
+func (mb *MockBackend) AddUnreportedMsg(msg Msg) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.unreportedMsgs = append(mb.unreportedMsgs, msg)
+}
+
+// GetSentMsgs returns the unreported msgs we've been given for the passed in channel type, our mock ignores when they were
+// sent
+func (mb *MockBackend) GetSentMsgs(ctx context.Context, channelType ChannelType, sentBefore time.Time, limit int) ([]Msg, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	msgs := make([]Msg, 0)
+	for _, msg := range mb.unreportedMsgs {
+		if msg.Channel().ChannelType() == channelType && len(msgs) < limit {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
 // ClearChannels is a utility function on our mock server to clear all added channels
 func (mb *MockBackend) ClearChannels() {
 	mb.channels = nil
@@ -230,6 +420,9 @@ func (mb *MockBackend) Cleanup() error { return nil }
 
 // ClearQueueMsgs clears our mock msg queue
 func (mb *MockBackend) ClearQueueMsgs() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
 	mb.queueMsgs = nil
 }
 
@@ -286,6 +479,9 @@ func (c *MockChannel) Country() string { return c.country }
 
 // SetConfig sets the passed in config parameter
 func (c *MockChannel) SetConfig(key string, value interface{}) {
+	if c.config == nil {
+		c.config = make(map[string]interface{})
+	}
 	c.config[key] = value
 }
 
@@ -361,6 +557,10 @@ type mockMsg struct {
 	receivedOn *time.Time
 	sentOn     *time.Time
 	wiredOn    *time.Time
+	sendAt     *time.Time
+	createdOn  time.Time
+	priority   MsgPriority
+	metadata   json.RawMessage
 }
 
 func (m *mockMsg) Channel() Channel       { return m.channel }
@@ -378,6 +578,20 @@ func (m *mockMsg) QuickReplies() []string { return m.quickReplies }
 func (m *mockMsg) ReceivedOn() *time.Time { return m.receivedOn }
 func (m *mockMsg) SentOn() *time.Time     { return m.sentOn }
 func (m *mockMsg) WiredOn() *time.Time    { return m.wiredOn }
+func (m *mockMsg) SendAt() *time.Time     { return m.sendAt }
+func (m *mockMsg) CreatedOn() time.Time   { return m.createdOn }
+
+func (m *mockMsg) Metadata() json.RawMessage { return m.metadata }
+
+func (m *mockMsg) Priority() MsgPriority {
+	if m.priority != "" {
+		return m.priority
+	}
+	if m.highPriority {
+		return MsgPriorityHigh
+	}
+	return MsgPriorityNormal
+}
 
 func (m *mockMsg) WithContactName(name string) Msg   { m.contactName = name; return m }
 func (m *mockMsg) WithReceivedOn(date time.Time) Msg { m.receivedOn = &date; return m }
@@ -385,6 +599,21 @@ func (m *mockMsg) WithExternalID(id string) Msg      { m.externalID = id; return
 func (m *mockMsg) WithID(id MsgID) Msg               { m.id = id; return m }
 func (m *mockMsg) WithUUID(uuid MsgUUID) Msg         { m.uuid = uuid; return m }
 func (m *mockMsg) WithAttachment(url string) Msg     { m.attachments = append(m.attachments, url); return m }
+func (m *mockMsg) WithSendAt(date time.Time) Msg     { m.sendAt = &date; return m }
+func (m *mockMsg) WithCreatedOn(date time.Time) Msg  { m.createdOn = date; return m }
+func (m *mockMsg) WithPriority(p MsgPriority) Msg    { m.priority = p; return m }
+func (m *mockMsg) WithChannel(c Channel) Msg         { m.channel = c; return m }
+
+func (m *mockMsg) WithMetadata(key string, value interface{}) Msg {
+	if m.metadata == nil {
+		m.metadata = json.RawMessage("{}")
+	}
+	encoded, err := json.Marshal(value)
+	if err == nil {
+		m.metadata, _ = jsonparser.Set(m.metadata, encoded, key)
+	}
+	return m
+}
 
 //-----------------------------------------------------------------------------
 // Mock status implementation
@@ -396,6 +625,12 @@ type mockMsgStatus struct {
 	externalID string
 	status     MsgStatusValue
 	createdOn  time.Time
+	retryAfter time.Duration
+
+	correlationID string
+	price         *MsgPrice
+	campaignRef   string
+	metadata      json.RawMessage
 
 	logs []*ChannelLog
 }
@@ -410,6 +645,30 @@ func (m *mockMsgStatus) SetExternalID(id string) { m.externalID = id }
 func (m *mockMsgStatus) Status() MsgStatusValue          { return m.status }
 func (m *mockMsgStatus) SetStatus(status MsgStatusValue) { m.status = status }
 
+func (m *mockMsgStatus) RetryAfter() time.Duration         { return m.retryAfter }
+func (m *mockMsgStatus) SetRetryAfter(delay time.Duration) { m.retryAfter = delay }
+
+func (m *mockMsgStatus) CorrelationID() string      { return m.correlationID }
+func (m *mockMsgStatus) SetCorrelationID(id string) { m.correlationID = id }
+
+func (m *mockMsgStatus) Price() *MsgPrice         { return m.price }
+func (m *mockMsgStatus) SetPrice(price *MsgPrice) { m.price = price }
+
+func (m *mockMsgStatus) CampaignReference() string       { return m.campaignRef }
+func (m *mockMsgStatus) SetCampaignReference(ref string) { m.campaignRef = ref }
+
+func (m *mockMsgStatus) Metadata() json.RawMessage { return m.metadata }
+
+func (m *mockMsgStatus) SetMetadata(key string, value interface{}) {
+	if m.metadata == nil {
+		m.metadata = json.RawMessage("{}")
+	}
+	encoded, err := json.Marshal(value)
+	if err == nil {
+		m.metadata, _ = jsonparser.Set(m.metadata, encoded, key)
+	}
+}
+
 func (m *mockMsgStatus) Logs() []*ChannelLog    { return m.logs }
 func (m *mockMsgStatus) AddLog(log *ChannelLog) { m.logs = append(m.logs, log) }
 
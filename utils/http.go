@@ -1,13 +1,24 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -24,8 +35,60 @@ type RequestResponse struct {
 	Response   string
 	Body       []byte
 	Elapsed    time.Duration
+
+	// RetryAfter is how long the server asked us to wait before retrying, zero if it didn't say
+	RetryAfter time.Duration
+
+	// ErrorType is why we failed to get a response, empty if we got one
+	ErrorType RequestErrorType
+
+	// BodyTruncated is whether the response body was longer than we were willing to read, in which case Body and
+	// Response only contain its start
+	BodyTruncated bool
+
+	// Retried are the earlier attempts at this request if it was retried, oldest first
+	Retried []*RequestResponse
+}
+
+// HTTPRequestOptions are the limits MakeHTTPRequestWithOptions puts on a request, zero values mean no limit
+type HTTPRequestOptions struct {
+	// MaxBodyBytes is the most of the response body we will read
+	MaxBodyBytes int64
+
+	// ResponseTimeout is how long we wait for the full response once we have a connection, this is on top of the time
+	// spent connecting, which is only limited by the client's own timeout
+	ResponseTimeout time.Duration
+
+	// Client is the client we make the request with, our shared client if it isn't set
+	Client *http.Client
 }
 
+// DefaultHTTPRequestOptions are the options used by MakeHTTPRequest
+var DefaultHTTPRequestOptions = HTTPRequestOptions{MaxBodyBytes: 10 * 1024 * 1024}
+
+// ErrResponseTimeout is returned when we don't get a full response within a request's response timeout
+var ErrResponseTimeout = errors.New("timed out waiting for response")
+
+// RequestErrorType classifies why a request failed to get any response
+type RequestErrorType string
+
+const (
+	// RequestErrorTimeout means the request timed out, the server may still have acted on it
+	RequestErrorTimeout RequestErrorType = "timeout"
+
+	// RequestErrorCanceled means the request's context was canceled before it completed
+	RequestErrorCanceled RequestErrorType = "canceled"
+
+	// RequestErrorDNS means the request's host couldn't be resolved
+	RequestErrorDNS RequestErrorType = "dns"
+
+	// RequestErrorConnectionRefused means the server refused our connection
+	RequestErrorConnectionRefused RequestErrorType = "connection_refused"
+
+	// RequestErrorConnection means any other failure to connect or read a response
+	RequestErrorConnection RequestErrorType = "connection"
+)
+
 const (
 	// RRStatusSuccess represents that the webhook was successful
 	RRStatusSuccess RequestResponseStatus = "S"
@@ -37,10 +100,17 @@ const (
 	RRStatusFailure RequestResponseStatus = "E"
 )
 
+// setUserAgent identifies us as courier on the passed in request, unless it has its own user agent set already
+func setUserAgent(req *http.Request) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", HTTPUserAgent)
+	}
+}
+
 // MakeInsecureHTTPRequest fires the passed in http request against a transport that does not validate
 // SSL certificates.
 func MakeInsecureHTTPRequest(req *http.Request) (*RequestResponse, error) {
-	req.Header.Set("User-Agent", HTTPUserAgent)
+	setUserAgent(req)
 
 	start := time.Now()
 	requestTrace, err := httputil.DumpRequestOut(req, true)
@@ -56,7 +126,7 @@ func MakeInsecureHTTPRequest(req *http.Request) (*RequestResponse, error) {
 	}
 	defer resp.Body.Close()
 
-	rr, err := newRRFromResponse(req.Method, string(requestTrace), resp)
+	rr, err := newRRFromResponse(req.Method, string(requestTrace), resp, DefaultHTTPRequestOptions.MaxBodyBytes)
 	rr.Elapsed = time.Now().Sub(start)
 	return rr, err
 }
@@ -64,7 +134,30 @@ func MakeInsecureHTTPRequest(req *http.Request) (*RequestResponse, error) {
 // MakeHTTPRequest fires the passed in http request, returning any errors encountered. RequestResponse is always set
 // regardless of any errors being set
 func MakeHTTPRequest(req *http.Request) (*RequestResponse, error) {
-	req.Header.Set("User-Agent", HTTPUserAgent)
+	return MakeHTTPRequestWithOptions(req, DefaultHTTPRequestOptions)
+}
+
+// MakeHTTPRequestWithOptions fires the passed in http request with the passed in limits, returning any errors
+// encountered. RequestResponse is always set regardless of any errors being set
+func MakeHTTPRequestWithOptions(req *http.Request, options HTTPRequestOptions) (*RequestResponse, error) {
+	setUserAgent(req)
+
+	// our response timeout only starts once we have a connection, so cancel our request from a timer started then
+	var timedOut int32
+	if options.ResponseTimeout > 0 {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+
+		timer := time.AfterFunc(options.ResponseTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		timer.Stop()
+		defer timer.Stop()
+
+		trace := &httptrace.ClientTrace{GotConn: func(httptrace.GotConnInfo) { timer.Reset(options.ResponseTimeout) }}
+		req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+	}
 
 	start := time.Now()
 	requestTrace, err := httputil.DumpRequestOut(req, true)
@@ -73,15 +166,27 @@ func MakeHTTPRequest(req *http.Request) (*RequestResponse, error) {
 		return rr, err
 	}
 
-	resp, err := GetHTTPClient().Do(req)
+	client := options.Client
+	if client == nil {
+		client = GetHTTPClient()
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			err = ErrResponseTimeout
+		}
 		rr, _ := newRRFromRequestAndError(req, string(requestTrace), err)
 		return rr, err
 	}
 	defer resp.Body.Close()
 
-	rr, err := newRRFromResponse(req.Method, string(requestTrace), resp)
+	rr, err := newRRFromResponse(req.Method, string(requestTrace), resp, options.MaxBodyBytes)
 	rr.Elapsed = time.Now().Sub(start)
+	if err != nil && atomic.LoadInt32(&timedOut) == 1 {
+		err = ErrResponseTimeout
+		rr.ErrorType = RequestErrorTimeout
+	}
 	return rr, err
 }
 
@@ -93,13 +198,15 @@ func newRRFromRequestAndError(r *http.Request, requestTrace string, requestError
 
 	rr.Request = requestTrace
 	rr.Status = RRConnectionFailure
+	rr.ErrorType = ClassifyRequestError(requestError)
 	rr.Body = []byte(requestError.Error())
 
 	return &rr, nil
 }
 
-// newRRFromResponse creates a new RequestResponse based on the passed in http Response
-func newRRFromResponse(method string, requestTrace string, r *http.Response) (*RequestResponse, error) {
+// newRRFromResponse creates a new RequestResponse based on the passed in http Response, reading at most maxBodyBytes
+// of its body if that is set
+func newRRFromResponse(method string, requestTrace string, r *http.Response, maxBodyBytes int64) (*RequestResponse, error) {
 	var err error
 	rr := RequestResponse{}
 	rr.Method = method
@@ -114,6 +221,7 @@ func newRRFromResponse(method string, requestTrace string, r *http.Response) (*R
 	}
 
 	rr.Request = requestTrace
+	rr.RetryAfter = parseRetryAfter(r.Header.Get("Retry-After"), time.Now())
 
 	// figure out if our Response is something that looks like text from our headers
 	isText := false
@@ -127,21 +235,34 @@ func newRRFromResponse(method string, requestTrace string, r *http.Response) (*R
 		isText = true
 	}
 
-	// only dump the whole body if this looks like text
-	response, err := httputil.DumpResponse(r, isText)
-	if err != nil {
-		return &rr, err
-	}
-	rr.Response = string(response)
-
+	// only read the body if this looks like text, and then no more of it than we've been told to
 	if isText {
-		bodyBytes, err := ioutil.ReadAll(r.Body)
+		reader := io.Reader(r.Body)
+		if maxBodyBytes > 0 {
+			reader = io.LimitReader(r.Body, maxBodyBytes+1)
+		}
+		bodyBytes, err := ioutil.ReadAll(reader)
 		if err != nil {
 			return &rr, err
 		}
+		if maxBodyBytes > 0 && int64(len(bodyBytes)) > maxBodyBytes {
+			bodyBytes = bodyBytes[:maxBodyBytes]
+			rr.BodyTruncated = true
+
+			// our dump should describe the body we kept
+			r.ContentLength = maxBodyBytes
+			r.TransferEncoding = nil
+		}
 		rr.Body = bodyBytes
+		r.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
 	}
 
+	response, err := httputil.DumpResponse(r, isText)
+	if err != nil {
+		return &rr, err
+	}
+	rr.Response = string(response)
+
 	// return an error if we got a non-200 status
 	if err == nil && rr.Status != RRStatusSuccess {
 		err = fmt.Errorf("received non 200 status: %d", rr.StatusCode)
@@ -150,6 +271,72 @@ func newRRFromResponse(method string, requestTrace string, r *http.Response) (*R
 	return &rr, err
 }
 
+// ClassifyRequestError returns why the passed in error, returned by an HTTP client, stopped us getting a response
+func ClassifyRequestError(err error) RequestErrorType {
+	// unwrap the errors the HTTP client and net packages wrap the underlying cause in
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+			continue
+		case *net.OpError:
+			if _, isDNS := e.Err.(*net.DNSError); isDNS {
+				return RequestErrorDNS
+			}
+			if e.Timeout() {
+				return RequestErrorTimeout
+			}
+			err = e.Err
+			continue
+		case *os.SyscallError:
+			err = e.Err
+			continue
+		}
+		break
+	}
+
+	switch err {
+	case context.Canceled:
+		return RequestErrorCanceled
+	case context.DeadlineExceeded, ErrResponseTimeout:
+		return RequestErrorTimeout
+	case syscall.ECONNREFUSED:
+		return RequestErrorConnectionRefused
+	}
+
+	if _, isDNS := err.(*net.DNSError); isDNS {
+		return RequestErrorDNS
+	}
+	if netErr, isNet := err.(net.Error); isNet && netErr.Timeout() {
+		return RequestErrorTimeout
+	}
+	return RequestErrorConnection
+}
+
+// parseRetryAfter parses the value of a Retry-After header which can either be a number of seconds or an HTTP date,
+// returning zero if it is missing, invalid or in the past
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
 // GetHTTPClient returns the shared HTTP client used by all Courier threads
 func GetHTTPClient() *http.Client {
 	once.Do(func() {
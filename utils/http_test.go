@@ -1,6 +1,18 @@
 package utils
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestClient(t *testing.T) {
 	client := GetHTTPClient()
@@ -22,3 +34,139 @@ func TestClient(t *testing.T) {
 		t.Error("GetHTTPClient should always return same client")
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 1, 18, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("foo", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-5", now))
+	assert.Equal(t, time.Second*120, parseRetryAfter("120", now))
+	assert.Equal(t, time.Second*30, parseRetryAfter("Thu, 18 Jan 2018 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Thu, 18 Jan 2018 11:00:00 GMT", now))
+}
+
+func TestRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(429)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	rr, err := MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Equal(t, 429, rr.StatusCode)
+	assert.Equal(t, time.Second*60, rr.RetryAfter)
+}
+
+func TestUserAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("User-Agent")))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	rr, err := MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Equal(t, HTTPUserAgent, string(rr.Body))
+
+	// requests which set their own user agent keep it
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("User-Agent", "Acme/1.0")
+	rr, err = MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "Acme/1.0", string(rr.Body))
+}
+
+func TestClassifyRequestError(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	// grab a port that nothing is listening on
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedURL := closed.URL
+	closed.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	rr, err := MakeHTTPRequest(req.WithContext(ctx))
+	assert.Error(t, err)
+	assert.Equal(t, RequestErrorTimeout, rr.ErrorType)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequest(http.MethodGet, slow.URL, nil)
+	rr, err = MakeHTTPRequest(req.WithContext(canceled))
+	assert.Error(t, err)
+	assert.Equal(t, RequestErrorCanceled, rr.ErrorType)
+
+	req, _ = http.NewRequest(http.MethodGet, closedURL, nil)
+	rr, err = MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Equal(t, RequestErrorConnectionRefused, rr.ErrorType)
+
+	// a successful request has no error type
+	req, _ = http.NewRequest(http.MethodGet, slow.URL, nil)
+	rr, err = MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Equal(t, RequestErrorType(""), rr.ErrorType)
+
+	dnsErr := &url.Error{Op: "Get", URL: "http://foo.invalid", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "foo.invalid"}}}
+	assert.Equal(t, RequestErrorDNS, ClassifyRequestError(dnsErr))
+	assert.Equal(t, RequestErrorConnection, ClassifyRequestError(errors.New("boom")))
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	rr, err := MakeHTTPRequestWithOptions(req, HTTPRequestOptions{MaxBodyBytes: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, rr.StatusCode)
+	assert.Equal(t, "aaaaaaaaaa", string(rr.Body))
+	assert.True(t, rr.BodyTruncated)
+	assert.True(t, strings.HasSuffix(rr.Response, "\r\n\r\naaaaaaaaaa"))
+	assert.Contains(t, rr.Response, "Content-Length: 10")
+
+	// bodies which fit aren't truncated
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	rr, err = MakeHTTPRequestWithOptions(req, HTTPRequestOptions{MaxBodyBytes: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 100), string(rr.Body))
+	assert.False(t, rr.BodyTruncated)
+
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	rr, err = MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 100), string(rr.Body))
+	assert.True(t, strings.HasSuffix(rr.Response, strings.Repeat("a", 100)))
+}
+
+func TestResponseTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") == "true" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?slow=true", nil)
+	rr, err := MakeHTTPRequestWithOptions(req, HTTPRequestOptions{ResponseTimeout: 50 * time.Millisecond})
+	assert.Equal(t, ErrResponseTimeout, err)
+	assert.Equal(t, RRConnectionFailure, rr.Status)
+	assert.Equal(t, RequestErrorTimeout, rr.ErrorType)
+
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	rr, err = MakeHTTPRequestWithOptions(req, HTTPRequestOptions{ResponseTimeout: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(rr.Body))
+}
@@ -0,0 +1,134 @@
+package courier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// StatusWebhookSignatureHeader is the header we put the signature of our webhook payloads in
+const StatusWebhookSignatureHeader = "X-Courier-Signature"
+
+// how many statuses we hold waiting to be posted before we start dropping them
+const statusWebhookQueueSize = 1000
+
+// how many times we try to post a status
+const statusWebhookAttempts = 3
+
+// StatusWebhook posts the statuses of msgs which have reached a final state (delivered or failed) to an external URL,
+// including what the provider charged for the msg and the campaign it was sent for if it told us.
+// Statuses are posted in the background, retrying failed posts, and if a secret is set each payload is signed with it.
+type StatusWebhook struct {
+	url     string
+	secret  string
+	backoff time.Duration
+	queue   chan *statusWebhookPayload
+}
+
+type statusWebhookPayload struct {
+	ID          MsgID           `json:"id"`
+	ExternalID  string          `json:"external_id,omitempty"`
+	Status      MsgStatusValue  `json:"status"`
+	ChannelUUID ChannelUUID     `json:"channel_uuid"`
+	Price       *MsgPrice       `json:"price,omitempty"`
+	Campaign    string          `json:"campaign_reference,omitempty"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+}
+
+// NewStatusWebhook creates a new webhook which posts to the passed in URL, signing payloads with secret if it is set
+func NewStatusWebhook(url string, secret string) *StatusWebhook {
+	return &StatusWebhook{
+		url:     url,
+		secret:  secret,
+		backoff: time.Second,
+		queue:   make(chan *statusWebhookPayload, statusWebhookQueueSize),
+	}
+}
+
+// Notify queues the passed in status to be posted if it is final, it never blocks and statuses are dropped if our
+// queue is full. Statuses written by external id are posted with their external id, and with the id of their msg if
+// the backend resolved it when writing them.
+func (w *StatusWebhook) Notify(status MsgStatus) {
+	if (status.ID() == NilMsgID && status.ExternalID() == "") || (status.Status() != MsgDelivered && status.Status() != MsgFailed) {
+		return
+	}
+
+	payload := &statusWebhookPayload{
+		ID:          status.ID(),
+		ExternalID:  status.ExternalID(),
+		Status:      status.Status(),
+		ChannelUUID: status.ChannelUUID(),
+		Price:       status.Price(),
+		Campaign:    status.CampaignReference(),
+		Metadata:    status.Metadata(),
+	}
+
+	select {
+	case w.queue <- payload:
+	default:
+		logrus.WithField("comp", "status_webhook").WithField("msg_id", status.ID().String()).WithField("external_id", status.ExternalID()).Error("status webhook queue full, dropping status")
+	}
+}
+
+// Start starts a goroutine which posts our queued statuses until the passed in stop channel is closed
+func (w *StatusWebhook) Start(stopChan chan bool, waitGroup *sync.WaitGroup) {
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+
+		log := logrus.WithField("comp", "status_webhook")
+		log.WithField("state", "started").Info("status webhook started")
+
+		for {
+			select {
+			case <-stopChan:
+				log.WithField("state", "stopped").Info("status webhook stopped")
+				return
+
+			case payload := <-w.queue:
+				err := w.post(payload)
+				if err != nil {
+					log.WithError(err).WithField("msg_id", payload.ID.String()).WithField("external_id", payload.ExternalID).Error("error posting status to webhook")
+				}
+			}
+		}
+	}()
+}
+
+// post posts the passed in payload to our URL, retrying with an increasing backoff if it fails
+func (w *StatusWebhook) post(payload *statusWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, _ := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if w.secret != "" {
+			req.Header.Set(StatusWebhookSignatureHeader, SignStatusWebhook(w.secret, body))
+		}
+
+		_, err = utils.MakeHTTPRequest(req)
+		if err == nil || attempt == statusWebhookAttempts {
+			return err
+		}
+		time.Sleep(w.backoff * time.Duration(attempt))
+	}
+}
+
+// SignStatusWebhook returns the signature of the passed in webhook body, the hex encoded HMAC-SHA256 of the body using
+// the passed in secret. Receivers should compute this themselves and compare it against our signature header.
+func SignStatusWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,97 @@
+package courier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusWebhook(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	failures := 1
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+
+		// fail our first post so that it is retried
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	stopChan := make(chan bool)
+	waitGroup := &sync.WaitGroup{}
+	webhook := NewStatusWebhook(server.URL, "sesame")
+	webhook.backoff = time.Millisecond
+	webhook.Start(stopChan, waitGroup)
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", nil)
+	mb.AddChannel(channel)
+
+	// non-final statuses aren't posted
+	webhook.Notify(mb.NewMsgStatusForID(channel, NewMsgID(10), MsgWired))
+
+	status := mb.NewMsgStatusForID(channel, NewMsgID(11), MsgDelivered)
+	status.SetExternalID("ext1")
+	status.SetPrice(&MsgPrice{Amount: 0.01, Currency: "EUR"})
+	status.SetCampaignReference("spring-drive")
+	status.SetMetadata("sms_count", 2)
+	webhook.Notify(status)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-requests:
+			body := <-bodies
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, SignStatusWebhook("sesame", body), r.Header.Get(StatusWebhookSignatureHeader))
+
+			payload := &statusWebhookPayload{}
+			assert.NoError(t, json.Unmarshal(body, payload))
+			assert.Equal(t, NewMsgID(11), payload.ID)
+			assert.Equal(t, "ext1", payload.ExternalID)
+			assert.Equal(t, MsgDelivered, payload.Status)
+			assert.Equal(t, channel.UUID(), payload.ChannelUUID)
+			assert.Equal(t, &MsgPrice{Amount: 0.01, Currency: "EUR"}, payload.Price)
+			assert.Equal(t, "spring-drive", payload.Campaign)
+			assert.JSONEq(t, `{"sms_count": 2}`, string(payload.Metadata))
+		case <-time.After(time.Second):
+			assert.Fail(t, "timed out waiting for webhook post")
+		}
+	}
+
+	// statuses written by external id are posted even if we don't know the id of their msg
+	webhook.Notify(mb.NewMsgStatusForID(channel, NilMsgID, MsgFailed))
+	webhook.Notify(mb.NewMsgStatusForExternalID(channel, "ext2", MsgFailed))
+
+	select {
+	case <-requests:
+		payload := &statusWebhookPayload{}
+		assert.NoError(t, json.Unmarshal(<-bodies, payload))
+		assert.Equal(t, NilMsgID, payload.ID)
+		assert.Equal(t, "ext2", payload.ExternalID)
+		assert.Equal(t, MsgFailed, payload.Status)
+	case <-time.After(time.Second):
+		assert.Fail(t, "timed out waiting for webhook post")
+	}
+
+	close(stopChan)
+	waitGroup.Wait()
+	assert.Equal(t, 0, len(requests))
+
+	// our signature is a hex encoded HMAC-SHA256
+	assert.Equal(t, "fb68b8213cea88bc331a1caab7936988c32ecc878cb53afbcc8a728d5caffd46", SignStatusWebhook("sesame", []byte(`{"id":11}`)))
+}